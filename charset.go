@@ -0,0 +1,42 @@
+package fw
+
+import (
+	"reflect"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// fieldCharset returns the encoding named by the charset annotation of structField, or nil if the
+// field has no charset annotation. Charsets can only be used where column offsets are byte accurate,
+// which is when the decoder measures in bytes or the records are delimited.
+func (config setterConfig) fieldCharset(structField reflect.StructField) (encoding.Encoding, error) {
+
+	name, ok := structField.Tag.Lookup(charsetTagName)
+	if !ok {
+		return nil, nil
+	}
+
+	if config.splitter == nil && config.widthMode != WidthBytes {
+		return nil, &InvalidTagError{Field: structField, Tag: charsetTagName}
+	}
+
+	charset, err := ianaindex.IANA.Encoding(name)
+	if err != nil || charset == nil {
+		return nil, &InvalidTagError{Field: structField, Tag: charsetTagName}
+	}
+
+	return charset, nil
+}
+
+// charsetValueSetterFunc converts the column from charset to UTF-8 before it is trimmed and passed to setter.
+func charsetValueSetterFunc(currentField reflect.StructField, idx, from, to int, trimmer *fieldTrimmer, charset encoding.Encoding, setter valueSetter) func(reflect.Value, *record) error {
+	return func(v reflect.Value, r *record) error {
+		rawField := r.field(from, to)
+		decoded, err := charset.NewDecoder().String(rawField)
+		if err != nil {
+			return r.locate(&CastingError{Err: err, Value: rawField, Field: currentField}, from, to)
+		}
+		return r.locate(setter(v.Field(idx), currentField, trimmer.trim(decoded)), from, to)
+	}
+}
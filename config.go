@@ -0,0 +1,234 @@
+package fw
+
+import (
+	"io"
+	"reflect"
+	"time"
+)
+
+// A DecoderConfig holds the options of a [Decoder] so that they can be applied to any number of new
+// decoders with [NewDecoderFromConfig]. The exported fields have the same meaning as the fields of
+// the Decoder with the same names. The remaining fields hold the settings made by the Decoder's methods.
+//
+// Maps and slices are copied when a configuration is taken from a decoder and when it is applied to
+// one, so neither the decoder nor the configuration is affected by later changes to the other. Functions,
+// such as converters and OnWarning, are shared.
+//
+// Every field is applied as it is, including zero values, so a configuration should start from
+// [DefaultDecoderConfig] or [Decoder.Config] rather than an empty DecoderConfig.
+type DecoderConfig struct {
+	RecordTerminator      []byte
+	FieldSeparator        string
+	HeaderSeparator       string
+	FieldPadding          string
+	SkipFirstRecord       bool
+	TrimPartialTerminator bool
+	IgnoreEmptyRecords    bool
+	SkipLengthCheck       bool
+	Delimited             bool
+	DisableSetterCache    bool
+	WidthMode             WidthMode
+	ColumnDelimiter       rune
+	CheckTypeLayout       bool
+	RequireMappedFields   bool
+	StrictFields          bool
+	OnDuplicateHeader     DuplicateHeaderPolicy
+	DefaultLocation       *time.Location
+	FixedRecordLength     int
+	NormalizeForm         NormalizeForm
+	NormalizeValues       bool
+	InferPadding          int
+	DetectMisalignment    bool
+	OnWarning             func(warning error)
+	TrailerPredicate      func(line string) bool
+	HeaderLines           int
+	StripCurrency         bool
+	CurrencySymbols       string
+	TabWidth              int
+	UseJSONTags           bool
+	Filter                func(line string) bool
+	TrimGreedy            bool
+	StartMarker           []byte
+	EndMarker             []byte
+	Ruled                 bool
+
+	Headers        map[string][]int // Headers are the headers given to SetHeaders, or nil to read them from the input
+	Aliases        map[string]string
+	Converters     map[reflect.Type]Converter
+	TimeLayouts    map[string]string // TimeLayouts are the layouts given to RegisterTimeLayout
+	FastDecoders   map[reflect.Type]func(line string, dst interface{}) error
+	ChecksumColumn string
+	Checksum       func(record string) (string, bool)
+	MaxRecordSize  int // MaxRecordSize is the size given to SetMaxRecordSize, or zero for the default
+	Gzip           bool
+}
+
+// DefaultDecoderConfig returns the configuration of a decoder created by [NewDecoder]. It is the starting
+// point for a configuration built by hand.
+func DefaultDecoderConfig() DecoderConfig {
+	return NewDecoder(nil).Config()
+}
+
+// Config returns the configuration of the decoder. Headers are only included if they were given to
+// [Decoder.SetHeaders]; headers read from the input belong to that input and are not part of the
+// configuration. Nothing about the progress of the decoder, such as its statistics, is included.
+func (decoder *Decoder) Config() DecoderConfig {
+
+	config := DecoderConfig{
+		RecordTerminator:      append([]byte(nil), decoder.RecordTerminator...),
+		FieldSeparator:        decoder.FieldSeparator,
+		HeaderSeparator:       decoder.HeaderSeparator,
+		FieldPadding:          decoder.FieldPadding,
+		SkipFirstRecord:       decoder.SkipFirstRecord,
+		TrimPartialTerminator: decoder.TrimPartialTerminator,
+		IgnoreEmptyRecords:    decoder.IgnoreEmptyRecords,
+		SkipLengthCheck:       decoder.SkipLengthCheck,
+		Delimited:             decoder.Delimited,
+		DisableSetterCache:    decoder.DisableSetterCache,
+		WidthMode:             decoder.WidthMode,
+		ColumnDelimiter:       decoder.ColumnDelimiter,
+		CheckTypeLayout:       decoder.CheckTypeLayout,
+		RequireMappedFields:   decoder.RequireMappedFields,
+		StrictFields:          decoder.StrictFields,
+		OnDuplicateHeader:     decoder.OnDuplicateHeader,
+		DefaultLocation:       decoder.DefaultLocation,
+		FixedRecordLength:     decoder.FixedRecordLength,
+		NormalizeForm:         decoder.NormalizeForm,
+		NormalizeValues:       decoder.NormalizeValues,
+		InferPadding:          decoder.InferPadding,
+		DetectMisalignment:    decoder.DetectMisalignment,
+		OnWarning:             decoder.OnWarning,
+		TrailerPredicate:      decoder.TrailerPredicate,
+		HeaderLines:           decoder.HeaderLines,
+		StripCurrency:         decoder.StripCurrency,
+		CurrencySymbols:       decoder.CurrencySymbols,
+		TabWidth:              decoder.TabWidth,
+		UseJSONTags:           decoder.UseJSONTags,
+		Filter:                decoder.Filter,
+		TrimGreedy:            decoder.TrimGreedy,
+		StartMarker:           append([]byte(nil), decoder.StartMarker...),
+		EndMarker:             append([]byte(nil), decoder.EndMarker...),
+		Ruled:                 decoder.Ruled,
+		Aliases:               copyStringMap(decoder.aliases),
+		TimeLayouts:           copyStringMap(decoder.timeLayouts),
+		Converters:            copyConverters(decoder.converters),
+		FastDecoders:          copyFastDecoders(decoder.fastDecoders),
+		ChecksumColumn:        decoder.checksumColumn,
+		Checksum:              decoder.checksum,
+		MaxRecordSize:         decoder.maxRecordSize,
+	}
+
+	if decoder.headersGiven {
+		config.Headers = copyHeaders(decoder.headers)
+	}
+	_, config.Gzip = decoder.reader.r.(*gzipReader)
+
+	return config
+}
+
+// NewDecoderFromConfig returns a new decoder that reads from r with the options in config. Decoding returns
+// [ErrNoRecordTerminator] if config has neither a RecordTerminator nor a FixedRecordLength.
+func NewDecoderFromConfig(r io.Reader, config DecoderConfig) *Decoder {
+
+	decoder := NewDecoder(r)
+
+	if config.Headers != nil {
+		decoder.SetHeaders(copyHeaders(config.Headers))
+	}
+	if config.MaxRecordSize > 0 {
+		decoder.SetMaxRecordSize(config.MaxRecordSize)
+	}
+	decoder.SetGzip(config.Gzip)
+
+	decoder.RecordTerminator = append([]byte(nil), config.RecordTerminator...)
+	decoder.FieldSeparator = config.FieldSeparator
+	decoder.HeaderSeparator = config.HeaderSeparator
+	decoder.FieldPadding = config.FieldPadding
+	decoder.SkipFirstRecord = config.SkipFirstRecord
+	decoder.TrimPartialTerminator = config.TrimPartialTerminator
+	decoder.IgnoreEmptyRecords = config.IgnoreEmptyRecords
+	decoder.SkipLengthCheck = config.SkipLengthCheck
+	decoder.Delimited = config.Delimited
+	decoder.DisableSetterCache = config.DisableSetterCache
+	decoder.WidthMode = config.WidthMode
+	decoder.ColumnDelimiter = config.ColumnDelimiter
+	decoder.CheckTypeLayout = config.CheckTypeLayout
+	decoder.RequireMappedFields = config.RequireMappedFields
+	decoder.StrictFields = config.StrictFields
+	decoder.OnDuplicateHeader = config.OnDuplicateHeader
+	decoder.DefaultLocation = config.DefaultLocation
+	decoder.FixedRecordLength = config.FixedRecordLength
+	decoder.NormalizeForm = config.NormalizeForm
+	decoder.NormalizeValues = config.NormalizeValues
+	decoder.InferPadding = config.InferPadding
+	decoder.DetectMisalignment = config.DetectMisalignment
+	decoder.OnWarning = config.OnWarning
+	decoder.TrailerPredicate = config.TrailerPredicate
+	decoder.HeaderLines = config.HeaderLines
+	decoder.StripCurrency = config.StripCurrency
+	decoder.CurrencySymbols = config.CurrencySymbols
+	decoder.TabWidth = config.TabWidth
+	decoder.UseJSONTags = config.UseJSONTags
+	decoder.Filter = config.Filter
+	decoder.TrimGreedy = config.TrimGreedy
+	decoder.StartMarker = append([]byte(nil), config.StartMarker...)
+	decoder.EndMarker = append([]byte(nil), config.EndMarker...)
+	decoder.Ruled = config.Ruled
+
+	decoder.aliases = copyStringMap(config.Aliases)
+	decoder.timeLayouts = copyStringMap(config.TimeLayouts)
+	decoder.converters = copyConverters(config.Converters)
+	decoder.fastDecoders = copyFastDecoders(config.FastDecoders)
+	decoder.checksumColumn = config.ChecksumColumn
+	decoder.checksum = config.Checksum
+
+	return decoder
+}
+
+// copyHeaders returns a copy of headers which shares nothing with it.
+func copyHeaders(headers map[string][]int) map[string][]int {
+	if headers == nil {
+		return nil
+	}
+	copied := make(map[string][]int, len(headers))
+	for name, index := range headers {
+		copied[name] = append([]int(nil), index...)
+	}
+	return copied
+}
+
+// copyStringMap returns a copy of m, such as the aliases or time layouts.
+func copyStringMap(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+// copyConverters returns a copy of converters.
+func copyConverters(converters map[reflect.Type]Converter) map[reflect.Type]Converter {
+	if len(converters) == 0 {
+		return nil
+	}
+	copied := make(map[reflect.Type]Converter, len(converters))
+	for k, v := range converters {
+		copied[k] = v
+	}
+	return copied
+}
+
+// copyFastDecoders returns a copy of fastDecoders.
+func copyFastDecoders(fastDecoders map[reflect.Type]func(string, interface{}) error) map[reflect.Type]func(string, interface{}) error {
+	if len(fastDecoders) == 0 {
+		return nil
+	}
+	copied := make(map[reflect.Type]func(string, interface{}) error, len(fastDecoders))
+	for k, v := range fastDecoders {
+		copied[k] = v
+	}
+	return copied
+}
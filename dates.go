@@ -0,0 +1,207 @@
+package fw
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	isoWeekFormat   = "isoweek"
+	ordinalFormat   = "ordinal"
+	pivotTagName    = "pivot"
+	unixFormat      = "unix"
+	unixMilliFormat = "unixmilli"
+)
+
+// timeParser returns the function used to parse the value of a time field. The format annotation
+// is either a layout for [time.Parse], one of the ISO 8601 date forms which it can't handle or an
+// epoch timestamp. The pivot annotation sets the century of two digit years parsed with a layout.
+// Times which don't give a location are in location. A format starting with "@" names one of layouts.
+func timeParser(structField reflect.StructField, location *time.Location, layouts map[string]string) (func(string) (time.Time, error), error) {
+
+	if location == nil {
+		location = time.UTC
+	}
+
+	timeFormat, err := timeLayout(structField, layouts)
+	if err != nil {
+		return nil, err
+	}
+
+	switch timeFormat {
+	case isoWeekFormat:
+		return dateInLocation(parseISOWeek, location), nil
+	case ordinalFormat:
+		return dateInLocation(parseOrdinal, location), nil
+	case unixFormat:
+		return func(value string) (time.Time, error) {
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			return time.Unix(seconds, 0).In(location), err
+		}, nil
+	case unixMilliFormat:
+		return func(value string) (time.Time, error) {
+			millis, err := strconv.ParseInt(value, 10, 64)
+			return time.UnixMilli(millis).In(location), err
+		}, nil
+	}
+
+	pivotTag, ok := structField.Tag.Lookup(pivotTagName)
+	if !ok {
+		return func(value string) (time.Time, error) {
+			return time.ParseInLocation(timeFormat, value, location)
+		}, nil
+	}
+
+	pivot, err := strconv.Atoi(pivotTag)
+	if err != nil || pivot < 0 || pivot > 100 {
+		return nil, &InvalidTagError{Field: structField, Tag: pivotTagName}
+	}
+
+	return func(value string) (time.Time, error) {
+		t, err := time.ParseInLocation(timeFormat, value, location)
+		if err != nil {
+			return t, err
+		}
+		return pivotYear(t, pivot)
+	}, nil
+}
+
+// timeLayout returns the format annotation of a time field, RFC 3339 if it has none or, for a format
+// starting with "@", the one of layouts which it names.
+func timeLayout(structField reflect.StructField, layouts map[string]string) (string, error) {
+	timeFormat, ok := structField.Tag.Lookup(format)
+	if !ok {
+		return time.RFC3339, nil
+	}
+	if strings.HasPrefix(timeFormat, "@") {
+		if timeFormat, ok = layouts[timeFormat[1:]]; !ok {
+			return "", &InvalidTagError{Field: structField, Tag: format}
+		}
+	}
+	return timeFormat, nil
+}
+
+// timeFormatter returns the function used to format the value of a time field for the [Encoder],
+// using the format annotation in the same way as timeParser. Dates formatted as ISO 8601 week or
+// ordinal dates use the hyphenated forms.
+func timeFormatter(structField reflect.StructField, layouts map[string]string) (func(time.Time) string, error) {
+
+	timeFormat, err := timeLayout(structField, layouts)
+	if err != nil {
+		return nil, err
+	}
+
+	switch timeFormat {
+	case isoWeekFormat:
+		return func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%04d-W%02d-%d", year, week, (int(t.Weekday())+6)%7+1)
+		}, nil
+	case ordinalFormat:
+		return func(t time.Time) string {
+			return fmt.Sprintf("%04d-%03d", t.Year(), t.YearDay())
+		}, nil
+	case unixFormat:
+		return func(t time.Time) string {
+			return strconv.FormatInt(t.Unix(), 10)
+		}, nil
+	case unixMilliFormat:
+		return func(t time.Time) string {
+			return strconv.FormatInt(t.UnixMilli(), 10)
+		}, nil
+	}
+
+	return func(t time.Time) string {
+		return t.Format(timeFormat)
+	}, nil
+}
+
+// dateInLocation returns a parser giving midnight in location on the date returned by parse.
+func dateInLocation(parse func(string) (time.Time, error), location *time.Location) func(string) (time.Time, error) {
+	return func(value string) (time.Time, error) {
+		t, err := parse(value)
+		if err != nil {
+			return t, err
+		}
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, location), nil
+	}
+}
+
+// pivotYear moves t into the century given by pivot: years (within the century) below pivot are
+// in the 2000s and the rest are in the 1900s.
+func pivotYear(t time.Time, pivot int) (time.Time, error) {
+	year := t.Year() % 100
+	if year < pivot {
+		year += 2000
+	} else {
+		year += 1900
+	}
+	if year == t.Year() {
+		return t, nil
+	}
+	pivoted := time.Date(year, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	if pivoted.Day() != t.Day() {
+		return time.Time{}, fmt.Errorf("%s is not a valid date in %d", t.Format("January 2"), year)
+	}
+	return pivoted, nil
+}
+
+// parseISOWeek parses an ISO 8601 week date such as 2024-W05-3 (or 2024W053), where the
+// year is the ISO week numbering year and days are numbered from Monday (1) to Sunday (7).
+func parseISOWeek(value string) (time.Time, error) {
+
+	compact := strings.ReplaceAll(value, "-", "")
+	if len(compact) != 8 || compact[4] != 'W' || !isDigits(compact[:4]+compact[5:]) || (len(value) != 8 && (len(value) != 10 || value[4] != '-' || value[8] != '-')) {
+		return time.Time{}, fmt.Errorf("%q is not an ISO 8601 week date", value)
+	}
+
+	year, yearErr := strconv.Atoi(compact[:4])
+	week, weekErr := strconv.Atoi(compact[5:7])
+	day, dayErr := strconv.Atoi(compact[7:])
+	if yearErr != nil || weekErr != nil || dayErr != nil || week < 1 || week > 53 || day < 1 || day > 7 {
+		return time.Time{}, fmt.Errorf("%q is not an ISO 8601 week date", value)
+	}
+
+	// 4 January is always in week 1 so week 1 starts on the Monday on or before it.
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	monday := jan4.AddDate(0, 0, -((int(jan4.Weekday()) + 6) % 7))
+	t := monday.AddDate(0, 0, (week-1)*7+day-1)
+
+	if isoYear, isoWeek := t.ISOWeek(); isoYear != year || isoWeek != week {
+		return time.Time{}, fmt.Errorf("%d has no week %d", year, week)
+	}
+	return t, nil
+}
+
+// parseOrdinal parses an ISO 8601 ordinal date such as 2024-045 (or 2024045).
+func parseOrdinal(value string) (time.Time, error) {
+
+	compact := strings.Replace(value, "-", "", 1)
+	if len(compact) != 7 || !isDigits(compact) || (len(value) != 7 && value[4] != '-') {
+		return time.Time{}, fmt.Errorf("%q is not an ISO 8601 ordinal date", value)
+	}
+
+	year, yearErr := strconv.Atoi(compact[:4])
+	day, dayErr := strconv.Atoi(compact[4:])
+	if yearErr != nil || dayErr != nil || day < 1 {
+		return time.Time{}, fmt.Errorf("%q is not an ISO 8601 ordinal date", value)
+	}
+
+	t := time.Date(year, time.January, day, 0, 0, 0, 0, time.UTC)
+	if t.Year() != year {
+		return time.Time{}, fmt.Errorf("%d has no day %d", year, day)
+	}
+	return t, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
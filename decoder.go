@@ -4,15 +4,57 @@ package fw
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"reflect"
 	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 const (
-	columnTagName = "column"
-	format        = "format"
+	columnTagName          = "column"
+	format                 = "format"
+	numericTagName         = "numeric"
+	scaleTagName           = "scale"
+	setterTagName          = "setter"
+	maxLenTagName          = "maxlen"
+	minWidthTagName        = "minwidth"
+	widthTagName           = "width"
+	stringerFormat         = "stringer"
+	kvTagName              = "kv"
+	charsetTagName         = "charset"
+	joinTagName            = "join"
+	widthFromTagName       = "widthFrom"
+	strictPadTagName       = "strictPad"
+	keepOneTagName         = "keepOne"
+	padTagName             = "pad"
+	padSideTagName         = "padSide"
+	labelTagName           = "label"
+	indexTagName           = "index"
+	flagTagName            = "flag"
+	trueTagName            = "true"
+	normalizeTagName       = "normalize"
+	encodingTagName        = "encoding"
+	falseTagName           = "false"
+	defaultTagName         = "default"
+	fromTagName            = "from"
+	jsonTagName            = "json"
+	labelSepTagName        = "labelSep"
+	optionalTagName        = "optional"
+	alignTagName           = "align"
+	floatTagName           = "float"
+	fortranFloat           = "fortran"
+	defaultLabelSeparator  = ":"
+	defaultKVSeparators    = ";="
+	defaultCurrencySymbols = "$£€¥"
+	packedNumeric          = "packed"
+	joinedColumnSeparator  = "+"
 )
 
 // A Decoder reads and decodes fixed width data from an input stream.
@@ -22,9 +64,103 @@ const (
 // # Annotations
 //
 // Structs are annotated with the name of the input field/column with the column annotation. Referencing a column
-// which does not exist will cause the field to be silently ignored during processing. Given the range of date/time
+// which does not exist will cause the field to be silently ignored during processing unless [Decoder.StrictFields]
+// is set. As with encoding/json, column:"-" means that a field is never decoded (or encoded), even if there is a
+// column with its name, and column:"-," refers to a column named "-". Given the range of date/time
 // formats in data, [time.Time] fields are supported additionally by the format annotation which allows the template
-// for [time.ParseDate] to be provided.
+// for [time.ParseDate] to be provided. The formats "isoweek" and "ordinal" decode ISO 8601 week dates such as
+// 2024-W05-3 and ordinal dates such as 2024-045 (with or without the hyphens) as midnight. The formats "unix" and
+// "unixmilli" decode integer columns holding seconds or milliseconds since the Unix epoch. Times are in
+// [Decoder.DefaultLocation] unless the input gives a location. A format of "@" followed by a name, such as
+// format:"@iso", uses the layout registered under that name with [Decoder.RegisterTimeLayout].
+// Two digit years are placed in a century by [time.Parse] with a fixed rule; the pivot annotation replaces it so
+// that with pivot:"50" the years 00 to 49 are 2000 to 2049 and 50 to 99 are 1950 to 1999. The pivot is applied
+// to every parsed year so it should only be used with layouts which have two digit years.
+// A blank column decoded into a *time.Time field gives nil.
+//
+// The default annotation gives the value used in place of a blank column, so a time.Time field annotated with
+// default:"2000-01-01" format:"2006-01-02" is set to a sentinel date rather than failing to parse. The default is
+// used before any other processing of the value and it takes precedence over the nil given to a blank pointer. The
+// default of a time field must be valid for its format.
+//
+// Fields of type map[string]string are decoded from key/value pairs such as "k1=v1;k2=v2". The kv annotation gives
+// the pair separator followed by the key/value separator, so the default is kv:";=". Keys and values are trimmed of
+// white space and an empty column gives an empty map.
+//
+// A column annotation can join several columns into one value by separating their names with "+", for example
+// column:"First+Last". Each of the columns is trimmed and those which are not empty are joined with the value of the
+// join annotation (which defaults to nothing) before conversion, so join:" " gives "John Smith" and a field with
+// column:"Units+Fraction" and join:"." gives 12.5 from the columns "12" and "5". A column with a name containing
+// "+" is used as is if it exists. Joined columns can't be used with the setter, charset or numeric:"packed"
+// annotations or with sub-records.
+//
+// A column can have a width given by the record itself. The widthFrom annotation names an integer field, declared
+// earlier in the struct so that it is decoded first, which holds the width of the column. The column starts at the
+// start of the column given by the headers and the end from the headers is ignored, so it is normally the last
+// column and used with [Decoder.SkipLengthCheck]. A DynamicWidthError is returned if the width is negative or goes
+// beyond the end of the record. widthFrom can't be used with delimited records or with the annotations which
+// can't be used with joined columns.
+//
+// The from annotation gives the offset from which a field takes the rest of the record, whatever columns follow,
+// which suits free text remarks at the end of records. The offset is measured in the same way as the headers. A
+// record which ends before the offset gives a blank value, and records of different lengths need SkipLengthCheck.
+// from can't be used with delimited records or nested structs.
+//
+// Columns added by newer versions of a record can be annotated with optional:"true". When the type being decoded
+// has optional columns, a record shorter than the headers passes the length check as long as it reaches the start
+// of the first of them, and a field whose column starts at or after the end of the record is left unchanged. An
+// optional column which is cut short is decoded from the part which is present. Every column which is not optional
+// must end before the first optional column starts, otherwise an OptionalColumnError is returned, so the optional
+// columns are always the last. Joined columns and widthFrom can't be optional. The length check only takes optional
+// columns into account in [Decoder.Decode], [Decoder.DecodeContext] and [Decoder.DecodeN], where the type is
+// known before the record is read.
+//
+// The charset annotation names the character set (using IANA names such as "Shift_JIS" or "windows-1252") of a
+// column which is not UTF-8. The raw bytes of the column are converted to UTF-8 before they are trimmed and converted.
+// The decoder has no input wide character set so all other columns are expected to be UTF-8. As the column
+// offsets must be byte accurate the annotation requires [Decoder.WidthMode] to be [WidthBytes] or delimited
+// records and it can't be combined with packed numbers.
+//
+// Boolean fields annotated with flag are decoded from checkbox style columns: flag:"X" is true when the column
+// holds X and false when it is blank, and any other value is an error. With flag:"" any value which is not blank is
+// true. Blank columns decode to nil for pointer fields.
+//
+// Boolean fields are decoded from "true", "yes", "1" and the other values accepted by [strconv.ParseBool]. The true
+// and false annotations add the representations used by a file, so a field with true:"Y" false:"N" is also decoded
+// from Y and N. The [Encoder] writes booleans using the same annotations.
+//
+// Floating point fields annotated with float:"fortran" also accept the D exponent written by Fortran programs, so
+// "1.5D3" and "-2.0d-4" are decoded as 1.5e3 and -2.0e-4. Values with an E exponent or none are decoded as usual.
+//
+// Numeric and boolean fields annotated with strictPad:"true" must be padded only at the ends of their column. A
+// value which still contains padding once it has been trimmed, such as "12 34", causes a CastingError naming the
+// field. This catches misaligned records which happen to have the right length.
+//
+// A column holding labelled values such as "NM:Smith DOB:1990" can be decoded into several fields with the label
+// annotation. A field with label:"DOB" is decoded from the text which follows "DOB:" up to the next white space.
+// The labelSep annotation replaces the ":" between the label and the value. A field whose label is not in the column
+// is left unchanged. Use the kv annotation to decode all of the pairs in a column into a map.
+//
+// Columns are trimmed of all padding by default. The keepOne annotation, with a value of "left", "right" or "both",
+// keeps a single padding character on that side of the value when there is any, for formats where one space is
+// part of the value.
+//
+// The pad annotation gives characters which are trimmed from a column as well as [Decoder.FieldPadding], such as
+// pad:"*". The padSide annotation, "left" or "right", limits this to one end of the value, so numbers padded with
+// zeros are read with pad:"0" padSide:"left". A column holding only a digit used as padding decodes as that digit,
+// so zero is not lost. An empty pad annotation turns off padding inferred with [Decoder.InferPadding].
+//
+// Fields of type []byte hold the trimmed text of their column unless they are annotated with encoding:"hex" or
+// encoding:"base64", in which case the text is decoded into bytes. Text which is not valid in the encoding causes a
+// CastingError.
+//
+// The maxlen annotation truncates the trimmed value of a column to the given number of runes before
+// it is converted. This is useful when only a prefix of a wide column is significant.
+//
+// String fields annotated with normalize are changed after they have been trimmed: normalize:"lower" and
+// normalize:"upper" change the case of the value, normalize:"title" capitalizes each word and normalize:"trimfold"
+// folds the case for caseless comparison and replaces each run of white space inside the value with a single space,
+// which is useful for join keys. All of these follow the Unicode rules for every language.
 //
 // # Usable target structures
 //
@@ -33,10 +169,50 @@ const (
 //
 // All basic go data types are supported automatically. As mentioned above [time.Time] is supported explicitly. Any other
 // data type must support the [encoding.TextUnmarshaler] interface.  Any other data type will cause an error to be returned.
+// Fields may be pointers to any supported type, with any number of levels of indirection. Nil pointers are
+// allocated as needed; when decoding into a field with several levels, pointers which are already set are kept
+// and only the innermost pointer is replaced.
+//
+// # Setter methods
+//
+// A field annotated with setter:"MethodName" is decoded by calling the named method on a pointer to the struct
+// with the trimmed column value. The method must have the signature func(string) error and, because methods are
+// found by reflection, it must be exported. The field itself may be unexported as the method is responsible for
+// storing the value. Fields are decoded in the order they are declared in the struct so a setter method can
+// rely on the fields declared before it already having been set. An [InvalidSetterError] is returned for a
+// method which is not exported, doesn't exist or has the wrong signature.
+//
+// # Record unmarshalers
+//
+// A struct whose pointer implements [RecordUnmarshaler] is decoded by calling its UnmarshalRecord method with the
+// whole record and the headers instead of decoding its fields. Its annotations are ignored, so CheckTypeLayout
+// has no effect, but the record is still read, length checked and counted as usual and a registered checksum
+// still applies.
+//
+// # Sub-records
+//
+// A column can itself contain fixed width data. If a struct field (or pointer to a struct) is not decoded as text
+// and its type has fields annotated with width, the column is decoded into it using a nested layout. The fields
+// with a width annotation are laid out one after the other in declaration order starting at the first position
+// of the column, so the offset of a nested field is the start of the column plus the widths of the fields before
+// it. The nested layout must fit within the column. The column is not trimmed before it is split but each nested
+// field is trimmed as usual.
+//
+// # Packed decimal
+//
+// Integer and floating point fields can be annotated with numeric:"packed" to decode packed BCD (COMP-3) data,
+// two digits per byte with the sign in the final nibble. The scale annotation gives the number of implied decimal
+// places and is only valid for floating point fields. Packed columns are not trimmed. Column offsets are always
+// counted in runes and each byte which is not part of a valid UTF-8 sequence counts as a single rune, so packed
+// columns are effectively byte counted as long as the rest of the record is single byte data. Packed data can contain
+// any byte value so the [Decoder.RecordTerminator] must be chosen with care.
 type Decoder struct {
 	scanner          *bufio.Scanner
+	reader           *contextReader
 	RecordTerminator []byte // RecordTerminator identifies the sequence of bytes used to indicate end of record (default is "\n")
 	FieldSeparator   string // FieldSeparator is used to identify the characters between fields and also to trim those characters. It's used as part of a regular expression (default is a space)
+	HeaderSeparator  string // HeaderSeparator, if set, is used instead of FieldSeparator to split the header line (and records when Delimited is set) and is trimmed from the column names
+	FieldPadding     string // FieldPadding, if set, is used instead of FieldSeparator to trim the padding from values. Like FieldSeparator it's used as part of a regular expression
 	done             bool
 	headersParsed    bool
 	headersLength    int
@@ -44,45 +220,286 @@ type Decoder struct {
 	// By default, it is not skipped. If SetColumns is called, headers will be skipped.
 	// It may then be desirable to reset it. If SetColumns has been called, the headers
 	// will be read and discarded if SkipFirstRecord is true
-	IgnoreEmptyRecords bool // IgnoreEmptyRecores can be set to true to so that empty records
+
+	// TrimPartialTerminator can be set to true to remove the start of a multi-byte RecordTerminator (such as the "\r"
+	// of "\r\n") from the end of the final record when the input ends without a complete terminator. If nothing is left
+	// the final record is dropped. By default the final record is returned exactly as read. Input which ends with a
+	// complete terminator never produces an extra empty record.
+	TrimPartialTerminator bool
+	IgnoreEmptyRecords    bool // IgnoreEmptyRecores can be set to true to so that empty records
 	// will not cause an invalid record length error
 	SkipLengthCheck bool // SkipLengthCheck can be set to true to allow records to have a different
 	// length to the headers. This should be set when the final field may be have been whitespace trimmed
-	lineNum    int
-	headers    map[string][]int
-	lastType   reflect.Type
-	lastSetter structSetter
+
+	// Delimited can be set to true to split records (and the header line) on HeaderSeparator or FieldSeparator rather
+	// than by position. Columns are numbered from zero in the order they appear and the length of a record is its
+	// number of columns. Values are trimmed of white space rather than FieldSeparator. A field annotated with index:"n"
+	// is decoded from column n whatever the headers say; a column beyond the end of a record is empty. To read input
+	// with no header line, call SetHeaders with an empty map and set SkipLengthCheck.
+	Delimited bool
+
+	// DisableSetterCache can be set to true to stop the conversion functions built for each struct type being stored in
+	// the process wide cache, which is never evicted. This avoids unbounded growth when decoding many dynamically
+	// created types at the cost of building the conversion functions again whenever a decoder switches to a different
+	// type. See also [ClearSetterCache].
+	DisableSetterCache bool
+
+	// WidthMode defines whether column offsets and record lengths are measured in runes (the default), bytes or display
+	// cells. It applies to offsets from the header line and those given to SetHeaders.
+	WidthMode WidthMode
+
+	// ColumnDelimiter can be set to a character, such as '|', which marks the boundaries between columns in both the
+	// header line and the records. Columns read from the header line lie between the delimiters and exclude them.
+	// Offsets given to SetHeaders may include the delimiters, in which case a delimiter at either end of a column is
+	// removed before the value is trimmed of FieldPadding or FieldSeparator.
+	ColumnDelimiter rune
+
+	// CheckTypeLayout can be set to true to check that each record is long enough to hold every column mapped by the
+	// type it is decoded into, returning a LayoutMismatchError if not. This is most useful with SkipLengthCheck and
+	// DecodeFunc, where records of different types have different lengths.
+	CheckTypeLayout bool
+
+	// RequireMappedFields can be set to true to return ErrNoMappedFields when none of the fields of the struct being
+	// decoded match a column, which usually means the wrong struct or headers are in use.
+	RequireMappedFields bool
+
+	// StrictFields can be set to true to return a MissingColumnError when a field with a column annotation names a
+	// column which is not in the headers. Fields without a column annotation are still ignored when there is no column
+	// with their name. It also makes an alias for a name which is not in the header line an error.
+	StrictFields bool
+
+	// OnDuplicateHeader defines how a name which appears more than once in the header line is handled. By default the
+	// last column with the name is used. Names are compared after aliases are applied.
+	OnDuplicateHeader DuplicateHeaderPolicy
+
+	// DefaultLocation is the location of decoded times when the input doesn't give one, including epoch timestamps and
+	// ISO 8601 week and ordinal dates. UTC is used if it is nil.
+	DefaultLocation *time.Location
+
+	// FixedRecordLength can be set to split the input into records of exactly this length rather than on
+	// RecordTerminator, for input with no terminators at all. The length is in bytes when WidthMode is WidthBytes and
+	// in runes otherwise. A shorter final record is returned as it is and so fails the length check unless
+	// SkipLengthCheck is set. The header line, if there is one, must be the same length.
+	FixedRecordLength int
+
+	// NormalizeForm can be set to convert the names in the header line and the column names of fields to a Unicode
+	// normalization form before they are matched, so that a composed name matches a decomposed one. Names given to
+	// SetHeaders are used as they are. Aliases are applied after the header names are normalized.
+	NormalizeForm NormalizeForm
+
+	// NormalizeValues can be set to true to also convert the trimmed value of each field to NormalizeForm before it is
+	// decoded.
+	NormalizeValues bool
+
+	// InferPadding can be set to the number of records to sample, once the header line has been read, to find columns
+	// padded with a character other than FieldPadding, such as numbers padded with zeros. The sampled records are
+	// decoded as usual. The padding found is only removed from numeric fields, so text such as an ID or postcode
+	// with leading zeros is kept as it is; other fields can use a pad annotation. A field's pad annotation replaces
+	// the padding found for its column. Padding is not inferred for headers given to SetHeaders.
+	InferPadding int
+
+	// DetectMisalignment can be set to true to look for records which are not aligned with the headers. When the last
+	// character of a column and the first character of the column which starts immediately after it are both not
+	// padding, the text probably runs across the boundary, so a MisalignmentWarning is passed to OnWarning. This is a
+	// heuristic: a value which fills its column next to one which starts at the left of its column is reported too. It
+	// has no effect on delimited records or columns separated by a ColumnDelimiter.
+	DetectMisalignment bool
+
+	// OnWarning is called with problems found in records which don't stop them being decoded, such as a
+	// MisalignmentWarning. Warnings are discarded if it is nil.
+	OnWarning func(warning error)
+
+	// TrailerPredicate, if set, is called with each record after the header line, exactly as read. When it returns true
+	// the record is a trailer, such as a summary line, and the decoder stops as if the input had ended there, so it is
+	// never decoded as a data record and the rest of the input is not read. The trailer is available from
+	// [Decoder.Trailer] and [Decoder.DecodeTrailer].
+	TrailerPredicate func(line string) bool
+
+	// HeaderLines is the number of lines which make up the header (default is 1). The columns are found in the last of
+	// them as usual. Each label on an earlier line is a group which covers the columns starting from its first
+	// character up to the start of the next label on that line, and the name of a column is made by joining the labels
+	// of the groups covering its start and its own label with ".", so "2023" over "Q1" gives "2023.Q1". Columns before
+	// the first label of a line have no group from it. The record length is taken from the last line. With Delimited or
+	// a ColumnDelimiter the earlier lines are discarded, as are all of them when SetHeaders has been used and
+	// SkipFirstRecord is set.
+	HeaderLines int
+
+	// StripCurrency can be set to true to remove a currency symbol, and any spaces between it and the number, from the
+	// start or end of the values of numeric fields, so "$ 1234.56", "-£12" and "12.50 €" can be decoded. A sign may
+	// come before the symbol. Once a symbol is removed, commas grouping the digits in threes, as in "$ 1,234.56", are
+	// removed too. Other symbols are left in place and so cause a CastingError.
+	StripCurrency   bool
+	CurrencySymbols string // CurrencySymbols are the symbols removed when StripCurrency is set (default is "$£€¥")
+
+	// TabWidth can be set to expand each tab in the input, including the header line, to the spaces needed to reach the
+	// next multiple of TabWidth before offsets are found, so that files edited by hand line up as they are displayed.
+	// Positions are measured in the units of WidthMode. Records are split before tabs are expanded, so
+	// FixedRecordLength applies to the input as it is. Tabs are left alone if it is zero, the default.
+	TabWidth int
+
+	// UseJSONTags can be set to true to take the column name of a field without a column annotation from its json
+	// annotation, so that structs annotated for encoding/json can be decoded. As with encoding/json the name ends at
+	// the first comma, json:"-" means that the field is never decoded and a json annotation without a name is ignored.
+	// A column annotation always takes precedence.
+	UseJSONTags bool
+
+	// Filter, if set, is called with each data record exactly as read, after TrailerPredicate. Records for which it
+	// returns false are discarded without being checked or decoded, and are counted as skipped in [Decoder.Stats]
+	// rather than as records, so they don't count towards the limit of DecodeN.
+	Filter func(line string) bool
+
+	// TrimGreedy defines how padding is removed from the ends of each column (default is true). When it is true every
+	// padding character at each end is removed, so a column padded with spaces whose field is annotated with pad:"0"
+	// gives "7" from "  007". When it is false only the run of the padding character found at each end is removed,
+	// so "  007" gives "007" while "00007" still gives "7".
+	TrimGreedy bool
+
+	// StartMarker, if not empty, marks the start of fixed width data embedded in a larger input. Every line up to and
+	// including the first line which is exactly StartMarker, or StartMarker followed by a space and a name, is
+	// discarded, so the header line, if there is one, is the line after it. The data ends at the next such line, which
+	// starts another section (see [Decoder.NextSection]). If no line matches, the input is treated as empty. Line
+	// numbers still count from the start of the input.
+	StartMarker []byte
+
+	// EndMarker, if not empty, marks the end of fixed width data embedded in a larger input. The decoder stops at the
+	// first line which is exactly EndMarker, as if the input ended there, and the rest of the input is not read unless
+	// [Decoder.NextSection] is called. If no line matches, the data runs to the end of the input.
+	EndMarker []byte
+
+	// Ruled can be set to true to read tables drawn with ruler lines, such as "+------+-----+". A ruler line holds only
+	// '-', '=', '+' and ColumnDelimiter characters, with at least one '-' or '='. The columns are given by the first
+	// ruler line rather than the header line: each run of '-' or '=' is a column and the characters between the runs
+	// belong to no column, so the bars of the records are never part of a value. The header line is either the line
+	// before the ruler or, if the first line is a ruler, the line after it, and the name of each column is the text at
+	// its position, trimmed of HeaderSeparator or FieldSeparator. A MissingRulerError is returned if neither line is a
+	// ruler. Any other ruler lines, before, between or after the records, are discarded without being counted.
+	// HeaderLines is ignored. Ruled can't be combined with Delimited; ErrRuledDelimited is returned if both are set.
+	Ruled bool
+
+	splitter         *regexp.Regexp
+	pending          []string // pending holds records read ahead by Peek and InferPadding
+	fromRecords      bool
+	records          [][]byte
+	lineNum          int
+	headers          map[string][]int
+	aliases          map[string]string
+	aliased          map[string]bool // aliased holds the names in the header line which were renamed by an alias
+	lastType         reflect.Type
+	lastSetter       structSetter
+	lastLayoutLength int
+	lastOptional     bool // lastOptional is set when lastType has optional columns, the first starting at lastOptionalFrom
+	lastOptionalFrom int
+	markerStarted    bool    // markerStarted is set once the line starting a section has been found
+	markerEnded      bool    // markerEnded is set once the section has ended
+	markerEnding     *string // markerEnding is the line which ended the section, which is counted by NextSection
+	converters       map[reflect.Type]Converter
+	timeLayouts      map[string]string
+	fastDecoders     map[reflect.Type]func(line string, dst interface{}) error
+	checksumColumn   string
+	checksum         func(record string) (string, bool)
+	checksumTrimmer  *fieldTrimmer
+	setterCache      map[structSetterKey]structSetter
+	stats            DecoderStats
+	boundaries       []columnBoundary
+	columnPadding    map[string]columnPadding
+	padding          *regexp.Regexp
+	headersGiven     bool // headersGiven is set when the headers come from SetHeaders rather than the input
+	headerSkipped    bool // headerSkipped is set once the header line has been discarded after SetHeaders
+	maxRecordSize    int
+	scanned          bool // scanned is set once the input has been scanned, after which the buffer can't be changed
+	trailer          *string
 }
 
 // NewDecoder returns a new decoder that reads from r.
 func NewDecoder(r io.Reader) *Decoder {
+	reader := &contextReader{ctx: context.Background(), r: r}
 	dec := &Decoder{
-		scanner:          bufio.NewScanner(r),
+		scanner:          bufio.NewScanner(reader),
+		reader:           reader,
 		RecordTerminator: []byte("\n"),
 		FieldSeparator:   " ",
+		HeaderLines:      1,
+		TrimGreedy:       true,
 	}
 	dec.scanner.Split(dec.scan)
 	return dec
 }
 
 // Unmarshal decodes a buffer into the array or structed pointed to by v
-// If v is not an array only the first record will be read
+// If v is not an array only the first record will be read. Unmarshal is single shot: buf is not
+// changed, so unmarshalling the same buffer into a struct again always gives the first record. To read
+// the records which follow, create a [Decoder] for buf and call [Decoder.Decode] for each of them.
 func Unmarshal(buf []byte, v interface{}) error {
 	return UnmarshalReader(bytes.NewReader(buf), v)
 }
 
 // UnmarshalReader decodes an io.Reader into the array or structed pointed to by v
-// If v is not an array only the first record will be read
+// If v is not an array only the first record will be read. Input is read in blocks so r may have
+// been read beyond the first record; use a [Decoder] to read the remaining records.
 func UnmarshalReader(r io.Reader, v interface{}) error {
 	return NewDecoder(r).Decode(v)
 }
 
+// UnmarshalReaderContext decodes an io.Reader into the array or structed pointed to by v,
+// stopping when ctx is done. See [Decoder.DecodeContext] for the handling of partial results.
+func UnmarshalReaderContext(ctx context.Context, r io.Reader, v interface{}) error {
+	return NewDecoder(r).DecodeContext(ctx, v)
+}
+
 // Decode reads from its input and stores the decoded data to the value
 // pointed to by v. v may point to a struct or a slice of structs (or pointers to structs)
 //
+// When v points to a struct the record is decoded into it as it is: fields with a column in the
+// input are replaced and every other field keeps its value, so a struct holding defaults can be
+// overlaid with the data in a file. Nested structs reached through non-nil pointers are decoded
+// into the existing allocation. Records decoded into a slice are appended as new zero values.
+//
+// Each call with a struct decodes the next record, so records can be read one at a time in a loop which
+// ends when io.EOF is returned. Once the input is exhausted every later call returns io.EOF, whether v
+// points to a struct or a slice.
+//
 // Currently, the maximum decodable line length is bufio.MaxScanTokenSize-1. ErrTooLong
 // is returned if a line is encountered that too long to decode.
 func (decoder *Decoder) Decode(v interface{}) error {
+	return decoder.DecodeContext(context.Background(), v)
+}
+
+// DecodeContext behaves as [Decoder.Decode] but stops when ctx is done, returning ctx.Err().
+// The context is checked before each record is read and before each read from the underlying
+// reader; a read which is already blocked is not interrupted. When decoding to a slice, records
+// decoded before ctx was done remain appended to the slice. Once a read has failed because of the
+// context the decoder cannot be used again.
+func (decoder *Decoder) DecodeContext(ctx context.Context, v interface{}) error {
+	return decoder.decode(ctx, v, -1)
+}
+
+// DecodeN reads at most n more records and appends them to the slice pointed to by v, so that a large
+// input can be processed in batches. The headers are read by the first call and kept for the calls
+// which follow. If fewer than n records remain they are all appended and nil is returned; io.EOF is
+// returned once no records remain. An InvalidInputError is returned if v is not a pointer to a slice.
+func (decoder *Decoder) DecodeN(v interface{}, n int) error {
+
+	rv := reflect.ValueOf(v)
+	if v == nil || rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Slice || n < 0 {
+		return &InvalidInputError{Type: reflect.TypeOf(v)}
+	}
+
+	if decoder.done {
+		return io.EOF
+	}
+
+	length := rv.Elem().Len()
+	if err := decoder.decode(context.Background(), v, n); err != nil {
+		return err
+	}
+	if n > 0 && rv.Elem().Len() == length {
+		return io.EOF
+	}
+	return nil
+}
+
+// decode decodes into v, reading at most limit records into a slice unless limit is negative.
+func (decoder *Decoder) decode(ctx context.Context, v interface{}, limit int) error {
 
 	var (
 		err error
@@ -94,9 +511,16 @@ func (decoder *Decoder) Decode(v interface{}) error {
 	}
 
 	if decoder.done {
-		return fmt.Errorf("processing already complete")
+		return io.EOF
 	}
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	decoder.reader.ctx = ctx
+	defer func() { decoder.reader.ctx = context.Background() }()
+
 	rv := reflect.ValueOf(v)
 
 	if rv.Kind() != reflect.Ptr || rv.IsNil() {
@@ -121,7 +545,7 @@ func (decoder *Decoder) Decode(v interface{}) error {
 			return err
 		}
 
-		err, ok = decoder.readLines(rv)
+		err, ok = decoder.readLines(ctx, rv, limit)
 
 	} else {
 
@@ -147,61 +571,117 @@ func (decoder *Decoder) Decode(v interface{}) error {
 	return err
 }
 
-// At this point we *know* that v is a pointer to a slice.
-func (decoder *Decoder) readLines(slice reflect.Value) (error, bool) {
+// At this point we *know* that v is a pointer to a slice. The setter is resolved once, before the first
+// record is read, as every element has the same type. Structs are decoded directly into the slice
+// rather than being allocated separately and copied. At most limit records are read unless it is negative.
+func (decoder *Decoder) readLines(ctx context.Context, slice reflect.Value, limit int) (error, bool) {
 
+	isPointer := slice.Type().Elem().Kind() == reflect.Pointer
 	structType := slice.Type().Elem()
-	if structType.Kind() == reflect.Pointer {
+	if isPointer {
 		structType = structType.Elem()
 	}
 
-	for {
-		nv := reflect.New(structType).Elem()
-		err, ok := decoder.readLine(nv)
+	// The type is resolved first as its optional columns change the length check.
+	if err := decoder.useType(structType); err != nil {
+		return err, false
+	}
+
+	for read := 0; limit < 0 || read < limit; read++ {
+		if err := ctx.Err(); err != nil {
+			return err, false
+		}
+
+		line, err, ok := decoder.readRecord(decoder.shortestRecord())
 		if err != nil {
 			return err, false
 		}
-		if ok {
-			if slice.Type().Elem().Kind() == reflect.Pointer {
-				slice.Set(reflect.Append(slice, nv.Addr()))
+		if !ok {
+			break
+		}
+
+		if isPointer {
+			nv := reflect.New(structType)
+			if err := decoder.setRecord(nv.Elem(), line); err != nil {
+				return err, false
+			}
+			slice.Set(reflect.Append(slice, nv))
+		} else {
+			n := slice.Len()
+			if n < slice.Cap() {
+				slice.SetLen(n + 1)
+				slice.Index(n).Set(reflect.Zero(structType))
 			} else {
-				slice.Set(reflect.Append(slice, nv))
+				slice.Set(reflect.Append(slice, reflect.Zero(structType)))
+			}
+			if err := decoder.setRecord(slice.Index(n), line); err != nil {
+				slice.SetLen(n)
+				return err, false
 			}
-		}
-		if decoder.done {
-			break
 		}
 	}
 	return nil, true
 
 }
+
 func (decoder *Decoder) readLine(item reflect.Value) (error, bool) {
 
-	var (
-		t    reflect.Type
-		line string
-	)
+	// The type is resolved first as its optional columns change the length check.
+	if err := decoder.useType(item.Type()); err != nil {
+		return err, false
+	}
+
+	line, err, ok := decoder.readRecord(decoder.shortestRecord())
+	if err != nil || !ok {
+		return err, false
+	}
+
+	return decoder.setRecord(item, line), true
+
+}
+
+// readRecord reads the next record which passes the length checks. Records shorter than the headers
+// are accepted if they are at least shortest long, which is the length of the headers unless the type
+// being decoded has optional columns. ok is false when there are no records left.
+func (decoder *Decoder) readRecord(shortest int) (line string, err error, ok bool) {
+
 	for {
-		ok := decoder.scanner.Scan()
+		line, ok = decoder.nextRecord()
 		if !ok {
 			if decoder.scanner.Err() != nil {
-				return decoder.scanner.Err(), false
+				return "", decoder.scanner.Err(), false
 			}
 
 			decoder.done = true
-			return nil, false
+			return "", nil, false
 		}
 
 		decoder.lineNum++
-		line = decoder.scanner.Text()
-		lineLen := len([]rune(line))
-		t = item.Type()
+
+		if decoder.Ruled && decoder.isRuler(line) {
+			continue
+		}
+
+		if decoder.TrailerPredicate != nil && decoder.TrailerPredicate(line) {
+			trailer := line
+			decoder.trailer = &trailer
+			decoder.done = true
+			return "", nil, false
+		}
+
+		if decoder.Filter != nil && !decoder.Filter(line) {
+			decoder.stats.Skipped++
+			continue
+		}
+
+		lineLen := decoder.recordLength(line)
 
 		if lineLen == decoder.headersLength {
 			break
 		}
 
 		if lineLen == 0 && decoder.IgnoreEmptyRecords {
+			decoder.stats.Skipped++
 			continue
 		}
 
@@ -209,101 +689,1498 @@ func (decoder *Decoder) readLine(item reflect.Value) (error, bool) {
 			break
 		}
 
+		if lineLen >= shortest && lineLen < decoder.headersLength {
+			break
+		}
+
 		if (lineLen == 0 && !decoder.IgnoreEmptyRecords) || (lineLen != decoder.headersLength && !decoder.SkipLengthCheck) {
-			return &InvalidLengthError{
+			decoder.stats.Errors++
+			return "", &InvalidLengthError{
 				Headers:       decoder.headers,
 				Line:          line,
 				LineNum:       decoder.lineNum,
+				Length:        lineLen,
 				HeadersLength: decoder.headersLength,
 			}, false
 		}
 	}
 
+	decoder.stats.Records++
+	return line, nil, true
+}
+
+// shortestRecord returns the length of the shortest record which can be decoded into the current type: the
+// start of its first optional column, or the length of the headers if it has none.
+func (decoder *Decoder) shortestRecord() int {
+	if decoder.lastOptional {
+		return decoder.lastOptionalFrom
+	}
+	return decoder.headersLength
+}
+
+// decodeRecord decodes line into item, which must be an addressable struct.
+func (decoder *Decoder) decodeRecord(item reflect.Value, line string) error {
+	if err := decoder.useType(item.Type()); err != nil {
+		return err
+	}
+	return decoder.setRecord(item, line)
+}
+
+// useType makes the setter for t the current setter if it isn't already.
+func (decoder *Decoder) useType(t reflect.Type) error {
 	if t != decoder.lastType {
-		var err error
-		decoder.lastType = t
-		decoder.lastSetter, err = cachedStructSetter(t, decoder.headers, decoder.FieldSeparator)
+		setter, err := decoder.structSetter(t)
 		if err != nil {
-			return err, false
+			return err
+		}
+		decoder.lastType = t
+		decoder.lastSetter = setter
+		decoder.lastLayoutLength = 0
+		decoder.lastOptional = false
+		if _, fast := decoder.fastDecoders[t]; !fast && !reflect.PointerTo(t).Implements(recordUnmarshalerType) {
+			config := decoder.setterConfig()
+			decoder.lastLayoutLength = config.layoutLength(t)
+			decoder.lastOptionalFrom, decoder.lastOptional = config.optionalFrom(t)
+			if decoder.lastOptional && decoder.lastOptionalFrom < decoder.lastLayoutLength {
+				decoder.lastLayoutLength = decoder.lastOptionalFrom
+			}
+		}
+		if decoder.checksum != nil {
+			if _, ok := decoder.headers[decoder.checksumColumn]; !ok {
+				return fmt.Errorf("%w: %q", ErrNoChecksumColumn, decoder.checksumColumn)
+			}
+			trimmer, err := decoder.setterConfig().fieldTrimmer()
+			if err != nil {
+				return err
+			}
+			decoder.checksumTrimmer = trimmer
 		}
 	}
+	return nil
+}
 
-	return decoder.lastSetter(item, line), true
-
+// setRecord decodes line into item using the current setter.
+func (decoder *Decoder) setRecord(item reflect.Value, line string) error {
+	err := decoder.applySetter(item, line)
+	if err != nil {
+		decoder.stats.Errors++
+	}
+	return err
 }
 
-func (decoder *Decoder) parseHeaders() error {
+// applySetter checks line and passes it to the current setter.
+func (decoder *Decoder) applySetter(item reflect.Value, line string) error {
 
-	if decoder.headersParsed && !decoder.SkipFirstRecord {
-		return nil
+	if decoder.CheckTypeLayout {
+		if length := decoder.recordLength(line); length < decoder.lastLayoutLength {
+			return &LayoutMismatchError{
+				Type:         item.Type(),
+				Line:         line,
+				LineNum:      decoder.lineNum,
+				Length:       length,
+				LayoutLength: decoder.lastLayoutLength,
+			}
+		}
 	}
 
-	headerRegexp, err := regexp.Compile(fmt.Sprintf(".+?(?:%s+|$)", decoder.FieldSeparator))
-	if err != nil {
-		return err
+	if decoder.checksum != nil {
+		if err := decoder.verifyChecksum(line); err != nil {
+			return err
+		}
 	}
-	// this won't fail if above didn't
-	trimRegexp, _ := regexp.Compile(fmt.Sprintf("%s+", decoder.FieldSeparator))
 
-	ok := decoder.scanner.Scan()
-	if !ok {
-		if decoder.scanner.Err() != nil {
-			return decoder.scanner.Err()
+	if decoder.DetectMisalignment && decoder.OnWarning != nil && !decoder.Delimited {
+		decoder.checkAlignment(line)
+	}
+
+	return decoder.lastSetter(item, line)
+}
+
+// columnBoundary is the position where one column ends and the next begins.
+type columnBoundary struct {
+	column string
+	next   string
+	at     int
+}
+
+// checkAlignment passes a MisalignmentWarning to OnWarning for each column boundary in line which
+// has text on both sides of it.
+func (decoder *Decoder) checkAlignment(line string) {
+
+	if decoder.boundaries == nil {
+		decoder.boundaries = findBoundaries(decoder.headers)
+	}
+	if decoder.padding == nil {
+		padding, err := regexp.Compile("^(?:" + decoder.fieldPadding() + ")+$")
+		if err != nil {
+			return
 		}
+		decoder.padding = padding
+	}
 
-		decoder.done = true
-		return nil
+	r := newRecord(line, nil, decoder.WidthMode)
+	for _, boundary := range decoder.boundaries {
+		before, after := r.field(boundary.at-1, boundary.at), r.field(boundary.at, boundary.at+1)
+		if before == "" || after == "" {
+			continue
+		}
+		if !decoder.padding.MatchString(before) && !decoder.padding.MatchString(after) {
+			decoder.OnWarning(&MisalignmentWarning{
+				Column:  boundary.column,
+				Next:    boundary.next,
+				Line:    line,
+				LineNum: decoder.lineNum,
+			})
+		}
 	}
-	decoder.lineNum++
+}
 
-	// this may be called just to consume the header...
-	if decoder.headersParsed && decoder.SkipFirstRecord {
-		return nil
+// findBoundaries returns the boundaries between columns in headers which are next to each other.
+func findBoundaries(headers map[string][]int) []columnBoundary {
+	boundaries := []columnBoundary{}
+	for name, index := range headers {
+		for next, nextIndex := range headers {
+			if index[1] == nextIndex[0] && index[0] < index[1] && nextIndex[0] < nextIndex[1] {
+				boundaries = append(boundaries, columnBoundary{column: name, next: next, at: index[1]})
+			}
+		}
 	}
+	sort.Slice(boundaries, func(i, j int) bool {
+		return boundaries[i].at < boundaries[j].at
+	})
+	return boundaries
+}
 
-	line := decoder.scanner.Text()
-	decoder.headersLength = len([]rune(line))
+// verifyChecksum checks the checksum column of line with the registered checksum function. The column
+// is known to be in the headers as useType checks it.
+func (decoder *Decoder) verifyChecksum(line string) error {
 
-	indices := headerRegexp.FindAllStringIndex(line, -1)
-	decoder.headers = make(map[string][]int)
-	for _, index := range indices {
-		header := line[index[0]:index[1]]
-		decoder.headers[trimRegexp.ReplaceAllString(header, "")] = index
+	index := decoder.headers[decoder.checksumColumn]
+
+	var splitter *regexp.Regexp
+	if decoder.Delimited {
+		splitter = decoder.splitter
 	}
+	r := newRecord(line, splitter, decoder.WidthMode)
 
-	decoder.headersParsed = true
+	var preceding, value string
+	if splitter != nil {
+		if separators := splitter.FindAllStringIndex(line, index[0]); index[0] > 0 && len(separators) == index[0] {
+			preceding = line[:separators[index[0]-1][0]]
+		}
+		value = r.field(index[0], index[1])
+	} else {
+		// Records which are too short for the checksum column are checked against an empty checksum.
+		length := decoder.WidthMode.length(line)
+		if index[0] < length {
+			preceding = r.field(0, index[0])
+		} else {
+			preceding = r.field(0, length)
+		}
+		if index[1] <= length {
+			value = r.field(index[0], index[1])
+		}
+	}
+	value = decoder.checksumTrimmer.trim(value)
+
+	expected, valid := decoder.checksum(preceding)
+	if !valid || expected != value {
+		return &ChecksumError{
+			Column:   decoder.checksumColumn,
+			Value:    value,
+			Expected: expected,
+			Line:     line,
+			LineNum:  decoder.lineNum,
+		}
+	}
 	return nil
 }
 
-// SetHeaders overrides any headers parsed from the first line of input.
-// If decoder.SetHeaders is called , decoder.SkipFirstRecord is set to false.
-// If decoder.SkipFirstRecord is then set to true, the first line will be read
-// but not parsed
-func (decoder *Decoder) SetHeaders(headers map[string][]int) {
-	decoder.headers = headers
+// DecodeRecords decodes records which have already been split, for example when each record arrives
+// as a separate message, into v as [Decoder.Decode] would. The input stream of the decoder is not used so
+// a decoder created with NewDecoder(nil) can be used. If the headers have not been set or parsed, the first
+// record is parsed as the header line. DecodeRecords can be called repeatedly with further records; the
+// headers, line numbers and other state of the decoder carry over from one call to the next. When v is a
+// struct only the first data record is decoded and the remainder are discarded.
+func (decoder *Decoder) DecodeRecords(records [][]byte, v interface{}) error {
 
-	for _, v := range headers {
-		if v[1] > decoder.headersLength {
-			decoder.headersLength = v[1]
+	done := decoder.done
+	decoder.done = false
+	decoder.fromRecords = true
+	decoder.records = records
+
+	defer func() {
+		decoder.done = done
+		decoder.fromRecords = false
+		decoder.records = nil
+	}()
+
+	return decoder.Decode(v)
+}
+
+// CountRecords counts the data records in r using the default decoder settings. See [Decoder.CountRecords].
+func CountRecords(r io.Reader) (int, error) {
+	return NewDecoder(r).CountRecords()
+}
+
+// CountRecords reads the remaining input and returns the number of data records without decoding them.
+// The header line is read (or skipped) as for [Decoder.Decode] and is not counted. Records are split and
+// checked in the same way as when decoding so RecordTerminator, IgnoreEmptyRecords and SkipLengthCheck are
+// honoured and a record with the wrong length causes an error. The input is consumed, so the caller must
+// re-open or seek the input before decoding it, and io.EOF is returned if it has already been read.
+func (decoder *Decoder) CountRecords() (int, error) {
+
+	if decoder.done {
+		return 0, io.EOF
+	}
+
+	if err := decoder.parseHeaders(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for {
+		_, err, ok := decoder.readRecord(decoder.headersLength)
+		if err != nil {
+			return count, err
 		}
+		if !ok {
+			return count, nil
+		}
+		count++
 	}
+}
 
-	decoder.headersParsed = true
-	decoder.SkipFirstRecord = false
+// Transform decodes the records in, passes each one to fn and writes it to out, using the default decoder and
+// encoder settings. See [Decoder.Transform].
+func Transform(in io.Reader, out io.Writer, prototype interface{}, fn func(record interface{}) error) error {
+	return NewDecoder(in).Transform(NewEncoder(out), prototype, fn)
 }
 
-func (decoder *Decoder) scan(data []byte, atEOF bool) (advance int, token []byte, err error) {
-	if atEOF && len(data) == 0 {
-		return 0, nil, nil
+// Transform decodes every remaining record into a new value of the type of prototype, which must be a struct
+// or a pointer to a struct, passes a pointer to it to fn and then writes it with encoder, one record at a time.
+// Unless encoder already has a layout it is given the decoder's headers with [Encoder.SetLayout], so the records
+// are written at the offsets they were read from and the header line, if encoder writes one, names every column.
+// Offsets are used as rune offsets by the encoder. Columns without a field are blank unless [Encoder.PreserveRaw]
+// is set, in which case each line starts as the record it was decoded from. Transform stops at the first error
+// returned by fn, the decoder or the encoder. It can't be used with delimited records. io.EOF is returned if
+// the input has already been read.
+func (decoder *Decoder) Transform(encoder *Encoder, prototype interface{}, fn func(record interface{}) error) error {
+
+	t := reflect.TypeOf(prototype)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
 	}
-	if i := bytes.Index(data, decoder.RecordTerminator); i >= 0 {
-		// We have a full newline-terminated line.
-		return i + len(decoder.RecordTerminator), data[0:i], nil
+	if t == nil || t.Kind() != reflect.Struct {
+		return &InvalidInputError{Type: reflect.TypeOf(prototype)}
 	}
-	// If we're at EOF, we have a final, non-terminated line. Return it.
-	if atEOF {
-		return len(data), data, nil
+	if decoder.Delimited {
+		return ErrDelimitedTransform
 	}
-	// Request more data.
-	return 0, nil, nil
+
+	if decoder.done {
+		return io.EOF
+	}
+	if err := decoder.parseHeaders(); err != nil {
+		return err
+	}
+	if encoder.columns == nil {
+		headers, err := decoder.DecodeHeaderOnly()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		encoder.SetLayout(headers)
+	}
+
+	defer func() { encoder.raw = "" }()
+	return decoder.DecodeFunc(func(raw string) (interface{}, error) {
+		encoder.raw = raw
+		return reflect.New(t).Interface(), nil
+	}, func(v interface{}) error {
+		if err := fn(v); err != nil {
+			return err
+		}
+		return encoder.Encode(v)
+	})
+}
+
+// DecodeFunc decodes every remaining record, calling choose with the raw record to get the value to
+// decode it into. choose must return a non-nil pointer to a struct, or nil to skip the record. Once the
+// value has been decoded it is passed to sink. Decoding stops at the first error returned by choose,
+// sink or the decoder itself. DecodeFunc returns nil when all the records have been read and io.EOF if
+// it is called again after that.
+func (decoder *Decoder) DecodeFunc(choose func(raw string) (interface{}, error), sink func(v interface{}) error) error {
+
+	if decoder.done {
+		return io.EOF
+	}
+
+	if err := decoder.parseHeaders(); err != nil {
+		return err
+	}
+
+	for {
+		line, err, ok := decoder.readRecord(decoder.headersLength)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+
+		v, err := choose(line)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			continue
+		}
+
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+			return &InvalidInputError{Type: rv.Type()}
+		}
+
+		if err := decoder.decodeRecord(rv.Elem(), line); err != nil {
+			return err
+		}
+
+		if err := sink(v); err != nil {
+			return err
+		}
+	}
+}
+
+// DecodeToChannel decodes every remaining record into a new value of the type of prototype and sends
+// it to ch. prototype must be a struct or a pointer to a struct; pointers are sent if it is a pointer.
+// See [Decoder.DecodeToChannelContext].
+func (decoder *Decoder) DecodeToChannel(ch chan<- interface{}, prototype interface{}) error {
+	return decoder.DecodeToChannelContext(context.Background(), ch, prototype)
+}
+
+// DecodeToChannelContext behaves as [Decoder.DecodeToChannel] but stops when ctx is done, returning
+// ctx.Err(). Each record is decoded only once the previous one has been sent, so a slow receiver holds
+// back the decoder and the capacity of ch sets how far ahead of the receiver it can get. ch is closed
+// when DecodeToChannelContext returns, so the receiver can range over it and then check the error
+// returned, which is nil when all the records have been sent. It is normally run in its own goroutine.
+func (decoder *Decoder) DecodeToChannelContext(ctx context.Context, ch chan<- interface{}, prototype interface{}) error {
+
+	defer close(ch)
+
+	t := reflect.TypeOf(prototype)
+	isPointer := t != nil && t.Kind() == reflect.Ptr
+	if isPointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return &InvalidInputError{Type: reflect.TypeOf(prototype)}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	decoder.reader.ctx = ctx
+	defer func() { decoder.reader.ctx = context.Background() }()
+
+	return decoder.DecodeFunc(func(string) (interface{}, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return reflect.New(t).Interface(), nil
+	}, func(v interface{}) error {
+		if !isPointer {
+			v = reflect.ValueOf(v).Elem().Interface()
+		}
+		select {
+		case ch <- v:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// structSetter returns the setter for t. The process wide cache is only used when no converters
+// are registered because converters are specific to a decoder; otherwise the decoder's own cache is used.
+func (decoder *Decoder) structSetter(t reflect.Type) (structSetter, error) {
+
+	if fn, ok := decoder.fastDecoders[t]; ok {
+		return func(item reflect.Value, line string) error {
+			return fn(line, item.Addr().Interface())
+		}, nil
+	}
+
+	if reflect.PointerTo(t).Implements(recordUnmarshalerType) {
+		return decoder.unmarshalRecord, nil
+	}
+
+	config := decoder.setterConfig()
+
+	if decoder.DisableSetterCache {
+		return createStructSetter(t, config)
+	}
+
+	if len(decoder.converters) == 0 {
+		return cachedStructSetter(t, config)
+	}
+
+	key := config.cacheKey(t)
+	if setter, ok := decoder.setterCache[key]; ok {
+		return setter, nil
+	}
+	setter, err := createStructSetter(t, config)
+	if err != nil {
+		return nil, err
+	}
+	if decoder.setterCache == nil {
+		decoder.setterCache = make(map[structSetterKey]structSetter)
+	}
+	decoder.setterCache[key] = setter
+	return setter, nil
+}
+
+// recordLength returns the length of line in runes or, for delimited data, the number of columns.
+func (decoder *Decoder) recordLength(line string) int {
+	if decoder.Delimited {
+		if line == "" {
+			return 0
+		}
+		return len(decoder.splitter.Split(line, -1))
+	}
+	return decoder.WidthMode.length(line)
+}
+
+func (decoder *Decoder) setterConfig() setterConfig {
+	config := setterConfig{
+		headers:         decoder.headers,
+		fieldSeparator:  decoder.fieldPadding(),
+		converters:      decoder.converters,
+		timeLayouts:     decoder.timeLayouts,
+		requireMapped:   decoder.RequireMappedFields,
+		strictFields:    decoder.StrictFields,
+		location:        decoder.DefaultLocation,
+		widthMode:       decoder.WidthMode,
+		columnDelimiter: decoder.ColumnDelimiter,
+		normalizeNames:  decoder.NormalizeForm,
+		useJSONTags:     decoder.UseJSONTags,
+		trimGreedy:      decoder.TrimGreedy,
+		columnPadding:   decoder.columnPadding,
+	}
+	if decoder.NormalizeValues {
+		config.normalizeValues = decoder.NormalizeForm
+	}
+	if decoder.StripCurrency {
+		config.currencySymbols = decoder.CurrencySymbols
+		if config.currencySymbols == "" {
+			config.currencySymbols = defaultCurrencySymbols
+		}
+	}
+	if decoder.Delimited {
+		config.splitter = decoder.splitter
+	}
+	return config
+}
+
+// headerSeparator returns HeaderSeparator, or FieldSeparator if it isn't set.
+func (decoder *Decoder) headerSeparator() string {
+	if decoder.HeaderSeparator != "" {
+		return decoder.HeaderSeparator
+	}
+	return decoder.FieldSeparator
+}
+
+// fieldPadding returns FieldPadding, or FieldSeparator if it isn't set.
+func (decoder *Decoder) fieldPadding() string {
+	if decoder.FieldPadding != "" {
+		return decoder.FieldPadding
+	}
+	return decoder.FieldSeparator
+}
+
+func (decoder *Decoder) parseHeaders() error {
+
+	// The padding is compiled into the trimmers of the setters, so check it before they're created.
+	if _, err := regexp.Compile(decoder.fieldPadding()); err != nil {
+		return err
+	}
+	if decoder.headersGiven {
+		if err := checkOffsets(decoder.headers); err != nil {
+			return err
+		}
+	}
+	if decoder.Ruled && decoder.Delimited {
+		return ErrRuledDelimited
+	}
+
+	if decoder.Delimited {
+		var err error
+		if decoder.splitter, err = regexp.Compile(decoder.headerSeparator()); err != nil {
+			return err
+		}
+	}
+
+	if decoder.headersParsed && (!decoder.SkipFirstRecord || decoder.headerSkipped) {
+		return nil
+	}
+
+	headerRegexp, err := regexp.Compile(fmt.Sprintf(".+?(?:(?:%s)+|$)", decoder.headerSeparator()))
+	if err != nil {
+		return err
+	}
+	// this won't fail if above didn't
+	trimRegexp, _ := regexp.Compile(fmt.Sprintf("(?:%s)+", decoder.headerSeparator()))
+
+	var groupLines []string
+	line, ok := decoder.nextRecord()
+	for {
+		if !ok {
+			if decoder.scanner.Err() != nil {
+				return decoder.scanner.Err()
+			}
+
+			decoder.done = true
+			return nil
+		}
+		decoder.lineNum++
+		if len(groupLines) >= decoder.HeaderLines-1 {
+			break
+		}
+		groupLines = append(groupLines, line)
+		line, ok = decoder.nextRecord()
+	}
+
+	// this may be called just to consume the header, which is only done once. Nothing is taken from
+	// it, so the offsets and length given to SetHeaders are used for every record.
+	if decoder.headersParsed && decoder.SkipFirstRecord {
+		decoder.headerSkipped = true
+		return nil
+	}
+
+	decoder.headers = make(map[string][]int)
+	decoder.aliased = make(map[string]bool)
+	decoder.boundaries = nil
+	decoder.padding = nil
+
+	if decoder.Delimited {
+		columns := decoder.splitter.Split(line, -1)
+		for i, column := range columns {
+			if err := decoder.addHeader(strings.TrimSpace(column), []int{i, i + 1}); err != nil {
+				return err
+			}
+		}
+		decoder.headersLength = len(columns)
+		return decoder.headersRead()
+	}
+
+	if decoder.Ruled {
+		if err := decoder.parseRuledHeaders(line); err != nil {
+			return err
+		}
+		return decoder.headersRead()
+	}
+
+	decoder.headersLength = decoder.WidthMode.length(line)
+
+	if decoder.ColumnDelimiter != 0 {
+		if err := decoder.parseDelimitedHeaders(line, trimRegexp); err != nil {
+			return err
+		}
+		return decoder.headersRead()
+	}
+
+	groups := make([][]headerGroup, len(groupLines))
+	for i, groupLine := range groupLines {
+		for _, index := range headerRegexp.FindAllStringIndex(groupLine, -1) {
+			groups[i] = append(groups[i], headerGroup{
+				label: trimRegexp.ReplaceAllString(groupLine[index[0]:index[1]], ""),
+				from:  decoder.WidthMode.length(groupLine[:index[0]]),
+			})
+		}
+	}
+
+	indices := headerRegexp.FindAllStringIndex(line, -1)
+	for _, index := range indices {
+		from := decoder.WidthMode.length(line[:index[0]])
+		to := from + decoder.WidthMode.length(line[index[0]:index[1]])
+		name := groupedName(groups, from, trimRegexp.ReplaceAllString(line[index[0]:index[1]], ""))
+		if err := decoder.addHeader(name, []int{from, to}); err != nil {
+			return err
+		}
+	}
+
+	return decoder.headersRead()
+}
+
+// headersRead finishes parsing the header line. When StrictFields is set an UnknownAliasError is returned
+// if any of the aliases did not match a name in it.
+func (decoder *Decoder) headersRead() error {
+	if decoder.StrictFields {
+		var unknown []string
+		for name := range decoder.aliases {
+			if !decoder.aliased[name] {
+				unknown = append(unknown, name)
+			}
+		}
+		if len(unknown) > 0 {
+			sort.Strings(unknown)
+			return &UnknownAliasError{Aliases: unknown, LineNum: decoder.lineNum}
+		}
+	}
+	decoder.headersParsed = true
+	return decoder.inferPadding()
+}
+
+// checkOffsets returns an InvalidOffsetsError for the first column, in name order, which is not given
+// as {from, to} with 0 <= from <= to.
+func checkOffsets(headers map[string][]int) error {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if index := headers[name]; len(index) != 2 || index[0] < 0 || index[1] < index[0] {
+			return &InvalidOffsetsError{Column: name, Offsets: index}
+		}
+	}
+	return nil
+}
+
+// headerGroup is a label on a header line before the last which covers the columns starting from its
+// position up to the next label.
+type headerGroup struct {
+	label string
+	from  int
+}
+
+// groupedName returns the name of the column starting at from, which is its label prefixed by the label
+// of the group covering it on each of the earlier header lines.
+func groupedName(groups [][]headerGroup, from int, label string) string {
+	parts := make([]string, 0, len(groups)+1)
+	for _, line := range groups {
+		covering := -1
+		for i, group := range line {
+			if group.from <= from {
+				covering = i
+			}
+		}
+		if covering >= 0 && line[covering].label != "" {
+			parts = append(parts, line[covering].label)
+		}
+	}
+	return strings.Join(append(parts, label), ".")
+}
+
+// inferPadding reads up to InferPadding records ahead of the decoder and, for each column, looks for a
+// padding character used in addition to FieldPadding. A character is used if every sampled value which
+// isn't blank starts (or ends) with it once FieldPadding has been removed and at least one value has two
+// or more of it there. Only zeros on the left and punctuation or symbols on either side are considered.
+func (decoder *Decoder) inferPadding() error {
+
+	decoder.columnPadding = nil
+	if decoder.InferPadding <= 0 {
+		return nil
+	}
+
+	for len(decoder.pending) < decoder.InferPadding {
+		line, ok := decoder.readInput()
+		if !ok {
+			if err := decoder.scanner.Err(); err != nil {
+				return err
+			}
+			break
+		}
+		decoder.pending = append(decoder.pending, line)
+	}
+
+	var splitter *regexp.Regexp
+	if decoder.Delimited {
+		splitter = decoder.splitter
+	}
+	records := make([]*record, len(decoder.pending))
+	for i, line := range decoder.pending {
+		records[i] = newRecord(line, splitter, decoder.WidthMode)
+	}
+
+	padding := regexp.MustCompile("^(?:" + decoder.fieldPadding() + ")$")
+	if splitter != nil {
+		padding = regexp.MustCompile(`^\s$`)
+	}
+	for name, index := range decoder.headers {
+		values := make([][]rune, 0, len(records))
+		for _, r := range records {
+			value := []rune(r.field(index[0], index[1]))
+			for len(value) > 0 && padding.MatchString(string(value[0])) {
+				value = value[1:]
+			}
+			for len(value) > 0 && padding.MatchString(string(value[len(value)-1])) {
+				value = value[:len(value)-1]
+			}
+			if len(value) > 0 {
+				values = append(values, value)
+			}
+		}
+		if pad, ok := inferColumnPadding(values); ok {
+			if decoder.columnPadding == nil {
+				decoder.columnPadding = make(map[string]columnPadding)
+			}
+			decoder.columnPadding[name] = pad
+		}
+	}
+	return nil
+}
+
+// inferColumnPadding looks for a padding character at the start or end of every value.
+func inferColumnPadding(values [][]rune) (columnPadding, bool) {
+
+	if len(values) == 0 {
+		return columnPadding{}, false
+	}
+
+	edge := func(left bool) (rune, bool) {
+		var pad rune
+		repeated := false
+		for i, value := range values {
+			c, next := value[0], 1
+			if !left {
+				c, next = value[len(value)-1], len(value)-2
+			}
+			if i == 0 {
+				pad = c
+			}
+			if c != pad || (c == '0' && !left) || !(c == '0' || unicode.IsPunct(c) || unicode.IsSymbol(c)) {
+				return 0, false
+			}
+			if next >= 0 && next < len(value) && value[next] == c {
+				repeated = true
+			}
+		}
+		return pad, repeated
+	}
+
+	if pad, ok := edge(true); ok {
+		return columnPadding{chars: string(pad), side: "left"}, true
+	}
+	if pad, ok := edge(false); ok {
+		return columnPadding{chars: string(pad), side: "right"}, true
+	}
+	return columnPadding{}, false
+}
+
+// parseDelimitedHeaders finds the columns between each ColumnDelimiter in line. Text before the first
+// and after the last delimiter is treated as a column if it has a name.
+func (decoder *Decoder) parseDelimitedHeaders(line string, trimRegexp *regexp.Regexp) error {
+	start := 0
+	for {
+		end := strings.IndexRune(line[start:], decoder.ColumnDelimiter)
+		if end < 0 {
+			end = len(line)
+		} else {
+			end += start
+		}
+		if name := trimRegexp.ReplaceAllString(line[start:end], ""); name != "" {
+			from := decoder.WidthMode.length(line[:start])
+			if err := decoder.addHeader(name, []int{from, from + decoder.WidthMode.length(line[start:end])}); err != nil {
+				return err
+			}
+		}
+		if end == len(line) {
+			return nil
+		}
+		start = end + utf8.RuneLen(decoder.ColumnDelimiter)
+	}
+}
+
+// isRuler reports whether line is a ruler line of a table read with Ruled.
+func (decoder *Decoder) isRuler(line string) bool {
+	ruled := false
+	for _, c := range line {
+		switch {
+		case c == '-' || c == '=':
+			ruled = true
+		case c == '+' || (c == decoder.ColumnDelimiter && c != 0):
+		default:
+			return false
+		}
+	}
+	return ruled
+}
+
+// parseRuledHeaders finds the columns from the ruler line, which is either line or the line after it,
+// and takes their names from the other of the two.
+func (decoder *Decoder) parseRuledHeaders(line string) error {
+
+	names, ruler := line, ""
+	next, ok := decoder.nextRecord()
+	if ok {
+		decoder.lineNum++
+	}
+	switch {
+	case decoder.isRuler(line) && ok:
+		names, ruler = next, line
+	case ok && decoder.isRuler(next):
+		ruler = next
+	default:
+		return &MissingRulerError{LineNum: decoder.lineNum}
+	}
+
+	trimRegexp, err := regexp.Compile(fmt.Sprintf("^(?:%s)+|(?:%s)+$", decoder.headerSeparator(), decoder.headerSeparator()))
+	if err != nil {
+		return err
+	}
+	rulerRunes, nameRunes := []rune(ruler), []rune(names)
+	start := -1
+	for i, c := range append(rulerRunes, '+') {
+		if c == '-' || c == '=' {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start < 0 {
+			continue
+		}
+		end := i
+		if end > len(nameRunes) {
+			end = len(nameRunes)
+		}
+		if end > start {
+			if name := trimRegexp.ReplaceAllString(string(nameRunes[start:end]), ""); name != "" {
+				from := decoder.WidthMode.length(string(rulerRunes[:start]))
+				if err := decoder.addHeader(name, []int{from, from + decoder.WidthMode.length(string(rulerRunes[start:i]))}); err != nil {
+					return err
+				}
+			}
+		}
+		start = -1
+	}
+	decoder.headersLength = decoder.WidthMode.length(ruler)
+	return nil
+}
+
+// addHeader records the position of a column read from the header line, applying any alias and
+// then the OnDuplicateHeader policy.
+func (decoder *Decoder) addHeader(header string, index []int) error {
+	header = decoder.NormalizeForm.string(header)
+	if alias, ok := decoder.aliases[header]; ok {
+		decoder.aliased[header] = true
+		header = alias
+	}
+	if _, exists := decoder.headers[header]; exists {
+		switch decoder.OnDuplicateHeader {
+		case DuplicateHeaderFirst:
+			return nil
+		case DuplicateHeaderFail:
+			return &DuplicateHeaderError{Header: header, LineNum: decoder.lineNum}
+		case DuplicateHeaderSuffix:
+			for n := 2; exists; n++ {
+				renamed := fmt.Sprintf("%s_%d", header, n)
+				if _, exists = decoder.headers[renamed]; !exists {
+					header = renamed
+				}
+			}
+		}
+	}
+	decoder.headers[header] = index
+	return nil
+}
+
+// RecordUnmarshaler is implemented by types which decode whole records themselves. UnmarshalRecord is
+// passed the record exactly as read, without its terminator, and the column offsets in use, which must
+// not be changed. It takes precedence over the annotations of the struct's fields.
+type RecordUnmarshaler interface {
+	UnmarshalRecord(line string, headers map[string][]int) error
+}
+
+var recordUnmarshalerType = reflect.TypeOf(new(RecordUnmarshaler)).Elem()
+
+// unmarshalRecord is the setter for types implementing RecordUnmarshaler.
+func (decoder *Decoder) unmarshalRecord(item reflect.Value, line string) error {
+	return item.Addr().Interface().(RecordUnmarshaler).UnmarshalRecord(line, decoder.headers)
+}
+
+// A DuplicateHeaderPolicy defines how a column name which appears more than once in the header line
+// is handled. See [Decoder.OnDuplicateHeader].
+type DuplicateHeaderPolicy int
+
+const (
+	// DuplicateHeaderLast uses the last column with the name. This is the default.
+	DuplicateHeaderLast DuplicateHeaderPolicy = iota
+	// DuplicateHeaderFirst uses the first column with the name and ignores the others.
+	DuplicateHeaderFirst
+	// DuplicateHeaderFail causes a DuplicateHeaderError to be returned.
+	DuplicateHeaderFail
+	// DuplicateHeaderSuffix renames the second and later columns with the name by adding _2, _3 and so on.
+	DuplicateHeaderSuffix
+)
+
+// Peek returns the next record without consuming it so that the caller can decide how to decode it.
+// If the header line has not yet been read, or skipped after SetHeaders, it is read first. The record is
+// returned exactly as read, including empty records. Peek returns io.EOF if there are no records left or decoding is complete.
+func (decoder *Decoder) Peek() (string, error) {
+
+	if decoder.done {
+		return "", io.EOF
+	}
+
+	if len(decoder.pending) > 0 {
+		return decoder.pending[0], nil
+	}
+
+	if err := decoder.parseHeaders(); err != nil {
+		return "", err
+	}
+	if decoder.done {
+		return "", io.EOF
+	}
+
+	if len(decoder.pending) > 0 {
+		return decoder.pending[0], nil
+	}
+
+	line, ok := decoder.readInput()
+	if !ok {
+		if decoder.scanner.Err() != nil {
+			return "", decoder.scanner.Err()
+		}
+		return "", io.EOF
+	}
+
+	decoder.pending = append(decoder.pending, line)
+	return line, nil
+}
+
+// ReparseHeaders reads a new header line, or HeaderLines lines, replacing the headers and record length in use,
+// so that input made of sections with different layouts can be decoded in one pass. Records which follow are
+// decoded with the new headers, which replace any given to SetHeaders.
+//
+// The caller decides where a section starts. Either [Decoder.Peek] is used to look at the next record before it
+// is decoded, or [Decoder.TrailerPredicate] returns true for the header line of the next section so that decoding
+// stops before it. If a trailer has been found it is used as the first header line and decoding can continue,
+// otherwise the next line of input is read. io.EOF is returned if there is no input left.
+func (decoder *Decoder) ReparseHeaders() error {
+
+	if decoder.trailer != nil {
+		decoder.pending = append([]string{*decoder.trailer}, decoder.pending...)
+		decoder.trailer = nil
+		decoder.lineNum--
+		decoder.done = false
+	}
+	if decoder.done {
+		return io.EOF
+	}
+
+	decoder.headersParsed = false
+	decoder.headersGiven = false
+	decoder.headerSkipped = false
+	decoder.lastType = nil
+	decoder.lastSetter = nil
+
+	if err := decoder.parseHeaders(); err != nil {
+		return err
+	}
+	if !decoder.headersParsed {
+		return io.EOF
+	}
+	return nil
+}
+
+// Trailer returns the record which matched [Decoder.TrailerPredicate] and true, or false if no
+// trailer has been found.
+func (decoder *Decoder) Trailer() (string, bool) {
+	if decoder.trailer == nil {
+		return "", false
+	}
+	return *decoder.trailer, true
+}
+
+// DecodeTrailer decodes the record which matched [Decoder.TrailerPredicate] into v, which must be a
+// pointer to a struct. The trailer is decoded with the same headers as the data records but is not length
+// checked, CheckTypeLayout and a registered checksum do not apply to it and it is not counted in
+// [Decoder.Stats]. io.EOF is returned if no trailer has been found.
+func (decoder *Decoder) DecodeTrailer(v interface{}) error {
+
+	rv := reflect.ValueOf(v)
+	if v == nil || rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return &InvalidInputError{Type: reflect.TypeOf(v)}
+	}
+	if decoder.trailer == nil {
+		return io.EOF
+	}
+
+	if err := decoder.useType(rv.Elem().Type()); err != nil {
+		return err
+	}
+	return decoder.lastSetter(rv.Elem(), *decoder.trailer)
+}
+
+// nextRecord returns the first record read ahead by Peek or InferPadding or reads the next record
+// from the input.
+func (decoder *Decoder) nextRecord() (string, bool) {
+	if len(decoder.pending) > 0 {
+		line := decoder.pending[0]
+		decoder.pending = decoder.pending[1:]
+		return line, true
+	}
+	return decoder.readInput()
+}
+
+// readInput reads the next record from the input, skipping the lines before StartMarker and stopping at
+// EndMarker.
+func (decoder *Decoder) readInput() (string, bool) {
+	if decoder.markerEnded {
+		return "", false
+	}
+	if len(decoder.StartMarker) > 0 && !decoder.markerStarted {
+		if _, ok := decoder.findSection(); !ok {
+			return "", false
+		}
+	}
+	line, ok := decoder.scanInput()
+	if !ok {
+		return "", false
+	}
+	_, isStart := decoder.sectionStart(line)
+	if isStart || (len(decoder.EndMarker) > 0 && line == string(decoder.EndMarker)) {
+		decoder.markerEnded = true
+		decoder.markerEnding = &line
+		return "", false
+	}
+	return decoder.WidthMode.expandTabs(line, decoder.TabWidth), true
+}
+
+// findSection skips to the line which starts the next section and returns the name of the section. ok is
+// false if there is none.
+func (decoder *Decoder) findSection() (name string, ok bool) {
+	for {
+		line, more := decoder.scanInput()
+		if !more {
+			decoder.markerEnded = true
+			return "", false
+		}
+		decoder.lineNum++
+		if name, ok = decoder.sectionStart(line); ok {
+			decoder.markerStarted = true
+			return name, true
+		}
+	}
+}
+
+// sectionStart reports whether line starts a section, being StartMarker or StartMarker followed by a space
+// and the name of the section, which is returned.
+func (decoder *Decoder) sectionStart(line string) (name string, ok bool) {
+	marker := string(decoder.StartMarker)
+	switch {
+	case marker == "":
+		return "", false
+	case line == marker:
+		return "", true
+	case strings.HasPrefix(line, marker+" "):
+		return strings.TrimSpace(line[len(marker):]), true
+	}
+	return "", false
+}
+
+// NextSection moves to the next section of an input holding several tables, each with its own header line and
+// layout, and returns its name. A section starts with a line which is StartMarker, or StartMarker followed by a
+// space and the name of the section, and ends at a line which is EndMarker, at the line which starts the next
+// section or at the end of the input. Whatever is left of the current section is discarded, as are any lines
+// before the start of the next, and the next call to [Decoder.Decode] reads the header line of the new section
+// and can decode into a different type. Headers given to SetHeaders are kept and apply to every section. The
+// first call moves to the first section unless decoding has already started there. io.EOF is returned when there
+// are no sections left. An error is returned if StartMarker is empty.
+func (decoder *Decoder) NextSection() (string, error) {
+
+	if len(decoder.StartMarker) == 0 {
+		return "", ErrNoStartMarker
+	}
+
+	if decoder.markerStarted {
+		decoder.lineNum += len(decoder.pending)
+		decoder.pending = nil
+		for {
+			if _, ok := decoder.readInput(); !ok {
+				break
+			}
+			decoder.lineNum++
+		}
+	}
+	if err := decoder.scanner.Err(); err != nil {
+		return "", err
+	}
+
+	ending := decoder.markerEnding
+	decoder.markerStarted, decoder.markerEnded, decoder.markerEnding = false, false, nil
+
+	var (
+		name string
+		ok   bool
+	)
+	if ending != nil {
+		decoder.lineNum++
+		if name, ok = decoder.sectionStart(*ending); ok {
+			decoder.markerStarted = true
+		}
+	}
+	if !ok {
+		if name, ok = decoder.findSection(); !ok {
+			if err := decoder.scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+	}
+
+	decoder.pending = nil
+	decoder.done = false
+	decoder.trailer = nil
+	decoder.headersParsed = decoder.headersGiven
+	decoder.headerSkipped = false
+	decoder.lastType = nil
+	decoder.lastSetter = nil
+	decoder.padding = nil
+	return name, nil
+}
+
+// scanInput reads the next record from the input as it is.
+func (decoder *Decoder) scanInput() (string, bool) {
+	if decoder.fromRecords {
+		if len(decoder.records) == 0 {
+			return "", false
+		}
+		line := string(decoder.records[0])
+		decoder.records = decoder.records[1:]
+		decoder.stats.Bytes += int64(len(line))
+		return line, true
+	}
+	decoder.scanned = true
+	if !decoder.scanner.Scan() {
+		return "", false
+	}
+	return decoder.scanner.Text(), true
+}
+
+// SetHeaders overrides any headers parsed from the first line of input.
+// If decoder.SetHeaders is called , decoder.SkipFirstRecord is set to false.
+// If decoder.SkipFirstRecord is then set to true, the first line will be read
+// but not parsed, so a header line with labels at positions which don't match the
+// data can be discarded. The offsets given here are used for every record and the
+// expected record length is the largest end offset, whatever the length of the
+// discarded line. When [Decoder.Delimited] is set, each column should be given as
+// {n, n+1} where n is the zero based position of the column in the record. An
+// [InvalidOffsetsError] is returned when decoding starts if a column does not
+// have a start and an end, the start is negative or the end is before the start.
+func (decoder *Decoder) SetHeaders(headers map[string][]int) {
+	decoder.headers = headers
+	decoder.headersGiven = true
+	decoder.headersLength = 0
+	decoder.boundaries = nil
+	decoder.padding = nil
+	decoder.lastType = nil
+
+	for _, v := range headers {
+		if len(v) > 1 && v[1] > decoder.headersLength {
+			decoder.headersLength = v[1]
+		}
+	}
+
+	decoder.headersParsed = true
+	decoder.SkipFirstRecord = false
+}
+
+// SetWidths sets the headers from the widths of the columns in the order they appear in each record, as
+// layouts are usually documented, and otherwise behaves as [Decoder.SetHeaders]. The first column starts
+// at zero and each of the others starts where the one before it ends. An empty name takes up its width
+// without creating a column, for filler. An [InvalidWidthError] is returned, and the headers are unchanged,
+// if there isn't a width for every name, a width is negative or a name is given more than once.
+func (decoder *Decoder) SetWidths(order []string, widths []int) error {
+
+	if len(order) > len(widths) {
+		return &InvalidWidthError{Column: order[len(widths)], Missing: true}
+	}
+	if len(widths) > len(order) {
+		return &InvalidWidthError{Width: widths[len(order)], Missing: true}
+	}
+
+	headers := make(map[string][]int, len(order))
+	from := 0
+	for i, name := range order {
+		if widths[i] < 0 {
+			return &InvalidWidthError{Column: name, Width: widths[i]}
+		}
+		if name != "" {
+			if _, ok := headers[name]; ok {
+				return &InvalidWidthError{Column: name, Width: widths[i], Duplicate: true}
+			}
+			headers[name] = []int{from, from + widths[i]}
+		}
+		from += widths[i]
+	}
+
+	decoder.SetHeaders(headers)
+	return nil
+}
+
+// DecodeHeaderOnly parses the header line and returns the column offsets without reading any data
+// records, so a subsequent call to [Decoder.Decode] starts with the first data record. If the headers
+// have already been parsed or set with [Decoder.SetHeaders] they are returned and no input is read; the
+// first record is still skipped by Decode when SkipFirstRecord is set. io.EOF is returned if the input
+// is empty. The map returned is a copy which can be changed without affecting the decoder.
+func (decoder *Decoder) DecodeHeaderOnly() (map[string][]int, error) {
+
+	if !decoder.headersParsed {
+		if err := decoder.parseHeaders(); err != nil {
+			return nil, err
+		}
+		if !decoder.headersParsed {
+			return nil, io.EOF
+		}
+	} else if decoder.headersGiven {
+		if err := checkOffsets(decoder.headers); err != nil {
+			return nil, err
+		}
+	}
+
+	return copyHeaders(decoder.headers), nil
+}
+
+// ColumnOrder returns the names of the columns ordered by their start offsets, which is the order
+// in which they appear in a record. Columns with the same start are ordered by their end offsets and
+// then by name. The headers are those parsed from the header line or given to [Decoder.SetHeaders]; nil
+// is returned if neither has happened yet.
+func (decoder *Decoder) ColumnOrder() []string {
+	if decoder.headers == nil {
+		return nil
+	}
+	names := make([]string, 0, len(decoder.headers))
+	for name := range decoder.headers {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := decoder.headers[names[i]], decoder.headers[names[j]]
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		if a[1] != b[1] {
+			return a[1] < b[1]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// SetMaxRecordSize sets the maximum size in bytes of a record (including the header line).
+// Records longer than this cause [bufio.ErrTooLong] to be returned. An error is returned if size
+// is not positive, and [ErrDecodingStarted] if the input has already been read, leaving the maximum
+// unchanged.
+func (decoder *Decoder) SetMaxRecordSize(size int) error {
+	if size <= 0 {
+		return fmt.Errorf("invalid maximum record size %d", size)
+	}
+	if decoder.scanned {
+		return ErrDecodingStarted
+	}
+	decoder.maxRecordSize = size
+	initial := bufio.MaxScanTokenSize
+	if size < initial {
+		initial = size
+	}
+	decoder.scanner.Buffer(make([]byte, 0, initial), size)
+	return nil
+}
+
+// SetGzip sets whether the input is gzip compressed. When enabled the input is decompressed
+// before records are split so all other options apply to the decompressed data. [ErrDecodingStarted]
+// is returned if the input has already been read, leaving the setting unchanged.
+func (decoder *Decoder) SetGzip(compressed bool) error {
+	if decoder.scanned {
+		return ErrDecodingStarted
+	}
+	zr, isGzip := decoder.reader.r.(*gzipReader)
+	if compressed && !isGzip {
+		decoder.reader.r = &gzipReader{r: decoder.reader.r}
+	} else if !compressed && isGzip {
+		decoder.reader.r = zr.r
+	}
+	return nil
+}
+
+// RegisterConverter sets the function used to convert columns decoded into fields of type t
+// (or pointers to t), taking precedence over the built in conversions. The value returned by
+// converter must be assignable to t. Converters are specific to the decoder they are registered
+// with so a decoder with converters does not share conversion functions with other decoders.
+func (decoder *Decoder) RegisterConverter(t reflect.Type, converter Converter) {
+	if decoder.converters == nil {
+		decoder.converters = make(map[reflect.Type]Converter)
+	}
+	decoder.converters[t] = converter
+	decoder.setterCache = nil
+	decoder.lastType = nil
+}
+
+// RegisterTimeLayout registers layout under name so that time fields can refer to it with a format
+// annotation of "@" followed by the name, such as format:"@iso", rather than repeating it. The layout is used
+// exactly as if it had been given in the annotation, so it can also be one of the named formats such as
+// "isoweek". A format annotation naming a layout which has not been registered causes an InvalidTagError when
+// the type is first decoded. Layouts are specific to the decoder they are registered with, so they must
+// also be registered with [Encoder.RegisterTimeLayout] to write the same fields.
+func (decoder *Decoder) RegisterTimeLayout(name, layout string) {
+	if decoder.timeLayouts == nil {
+		decoder.timeLayouts = make(map[string]string)
+	}
+	decoder.timeLayouts[name] = layout
+	decoder.setterCache = nil
+	decoder.lastType = nil
+}
+
+// RegisterFastDecoder sets fn as the function used to decode records into the type of prototype, which
+// must be a struct or a pointer to a struct, so that performance critical code can avoid reflection. fn is
+// passed the record exactly as read and a pointer to the struct to decode it into; the headers in use are
+// available from [Decoder.DecodeHeaderOnly]. It takes precedence over [RecordUnmarshaler] and the field
+// annotations, so CheckTypeLayout has no effect on the type, but records are still read, length checked and
+// counted and a registered checksum still applies. Fast decoders are specific to the decoder they are
+// registered with and are never stored in the setter cache. Registering nil removes the fast decoder.
+func (decoder *Decoder) RegisterFastDecoder(prototype interface{}, fn func(line string, dst interface{}) error) {
+	t := reflect.TypeOf(prototype)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if fn == nil {
+		delete(decoder.fastDecoders, t)
+	} else {
+		if decoder.fastDecoders == nil {
+			decoder.fastDecoders = make(map[reflect.Type]func(string, interface{}) error)
+		}
+		decoder.fastDecoders[t] = fn
+	}
+	decoder.lastType = nil
+}
+
+// RegisterChecksum validates every record with fn before it is decoded. fn is passed the part of the record
+// which precedes the checksum column, so the checksum column and anything after it are excluded. For delimited
+// records this is the preceding columns and the separators between them. fn returns the checksum of its input
+// and false if none can be calculated. A [ChecksumError] is returned if fn returns false or a checksum which is
+// not the same as the trimmed content of the column, and [ErrNoChecksumColumn] before any record is decoded if
+// the column is not in the headers. The column can still be decoded into a field.
+func (decoder *Decoder) RegisterChecksum(columnName string, fn func(record string) (string, bool)) {
+	decoder.checksumColumn = columnName
+	decoder.checksum = fn
+	decoder.lastType = nil
+}
+
+// Stats returns the counts of records, errors and bytes processed by the decoder since it was created
+// or [Decoder.ResetStats] was last called.
+func (decoder *Decoder) Stats() DecoderStats {
+	return decoder.stats
+}
+
+// ResetStats sets all of the counts returned by [Decoder.Stats] to zero.
+func (decoder *Decoder) ResetStats() {
+	decoder.stats = DecoderStats{}
+}
+
+// SetAliases renames columns read from the header line. The keys of aliases are the names
+// found in the input and the values are the names used by the column annotations. Aliases for
+// columns which are not present in the input are ignored unless [Decoder.StrictFields] is set, in which
+// case an [UnknownAliasError] is returned when the header line is parsed. Aliases are applied when the header
+// line is parsed so they have no effect on headers provided by [Decoder.SetHeaders].
+func (decoder *Decoder) SetAliases(aliases map[string]string) {
+	decoder.aliases = aliases
+}
+
+func (decoder *Decoder) scan(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if decoder.FixedRecordLength > 0 {
+		return decoder.scanFixed(data, atEOF)
+	}
+	if len(decoder.RecordTerminator) == 0 {
+		return 0, nil, ErrNoRecordTerminator
+	}
+	if i := bytes.Index(data, decoder.RecordTerminator); i >= 0 {
+		// We have a full newline-terminated line.
+		decoder.stats.Bytes += int64(i + len(decoder.RecordTerminator))
+		return i + len(decoder.RecordTerminator), data[0:i], nil
+	}
+	// If we're at EOF, we have a final, non-terminated line. Return it.
+	if atEOF {
+		decoder.stats.Bytes += int64(len(data))
+		token = data
+		if decoder.TrimPartialTerminator {
+			for n := len(decoder.RecordTerminator) - 1; n > 0; n-- {
+				if bytes.HasSuffix(token, decoder.RecordTerminator[:n]) {
+					token = token[:len(token)-n]
+					break
+				}
+			}
+			if len(token) == 0 {
+				return len(data), nil, nil
+			}
+		}
+		return len(data), token, nil
+	}
+	// Request more data.
+	return 0, nil, nil
+}
+
+// scanFixed splits records of FixedRecordLength bytes or runes.
+func (decoder *Decoder) scanFixed(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	n := 0
+	if decoder.WidthMode == WidthBytes {
+		if len(data) >= decoder.FixedRecordLength {
+			n = decoder.FixedRecordLength
+		}
+	} else {
+		for i, runes := 0, 0; i < len(data) && utf8.FullRune(data[i:]); {
+			_, size := utf8.DecodeRune(data[i:])
+			i += size
+			if runes++; runes == decoder.FixedRecordLength {
+				n = i
+				break
+			}
+		}
+	}
+	if n == 0 {
+		if !atEOF {
+			// Request more data.
+			return 0, nil, nil
+		}
+		n = len(data)
+	}
+	decoder.stats.Bytes += int64(n)
+	return n, data[:n], nil
+}
+
+// contextReader stops reading from r once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (reader *contextReader) Read(p []byte) (int, error) {
+	if err := reader.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return reader.r.Read(p)
+}
+
+// gzipReader decompresses r, reading the gzip header on the first call to Read.
+type gzipReader struct {
+	r  io.Reader
+	zr *gzip.Reader
+}
+
+func (reader *gzipReader) Read(p []byte) (int, error) {
+	if reader.zr == nil {
+		zr, err := gzip.NewReader(reader.r)
+		if err != nil {
+			return 0, err
+		}
+		reader.zr = zr
+	}
+	return reader.zr.Read(p)
 }
@@ -1,16 +1,25 @@
 package fw
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	_ "embed"
 	"fmt"
+	"io"
 	"math"
+	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/japanese"
 )
 
 type TestStruct struct {
@@ -125,6 +134,47 @@ func TestDecodeToStruct(t *testing.T) {
 	assert.Equal(t, expected, obtained)
 }
 
+func TestDecodeStructLoop(t *testing.T) {
+
+	type S struct {
+		Name string
+		Age  int
+	}
+
+	decoder := NewDecoder(strings.NewReader("Name  Age\nPeter 21 \nPaul  30 \n"))
+	obtained := []S{}
+	for {
+		record := S{}
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			break
+		}
+		if !assert.Nil(t, err) {
+			return
+		}
+		obtained = append(obtained, record)
+	}
+	assert.Equal(t, []S{{"Peter", 21}, {"Paul", 30}}, obtained)
+
+	// Every call after the end of the input returns io.EOF.
+	record := S{}
+	assert.Equal(t, io.EOF, decoder.Decode(&record))
+	assert.Equal(t, io.EOF, decoder.Decode(&obtained))
+	assert.Equal(t, S{}, record)
+
+	decoder = NewDecoder(strings.NewReader("Name  Age\nPeter 21 \n"))
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, io.EOF, decoder.Decode(&obtained))
+	assert.Equal(t, io.EOF, decoder.Decode(&record))
+
+	// The other ways of reading the records report the end of the input in the same way.
+	count, err := decoder.CountRecords()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, count)
+	assert.Equal(t, io.EOF, decoder.DecodeFunc(func(string) (interface{}, error) { return &S{}, nil }, func(interface{}) error { return nil }))
+	assert.Equal(t, io.EOF, decoder.Transform(NewEncoder(io.Discard), S{}, func(interface{}) error { return nil }))
+}
+
 func TestDecodeToSliceOfStructs(t *testing.T) {
 	obtained := []TestStruct{}
 	expected := ExpectedTestStruct()
@@ -169,6 +219,18 @@ func TestBadConversions(t *testing.T) {
 			Data:  []byte("Uint\n5.3 "),
 			Error: `failed casting "5.3" to "Uint:uint"`,
 		},
+		{
+			Data:  []byte("Uint\n-5  "),
+			Error: `negative value "-5" not allowed for unsigned field Uint:uint`,
+		},
+		{
+			Data:  []byte("Uint\n-0  "),
+			Error: `failed casting "-0" to "Uint:uint"`,
+		},
+		{
+			Data:  []byte("PUint8\n-12   "),
+			Error: `negative value "-12" not allowed for unsigned field PUint8:*uint8`,
+		},
 		{
 			Data:  []byte("Float32\nhello  "),
 			Error: `failed casting "hello" to "Float32:float32"`,
@@ -435,3 +497,3835 @@ func TestSkipLengthTest(t *testing.T) {
 	})
 
 }
+
+func TestPackedDecimal(t *testing.T) {
+
+	type P struct {
+		ID     string
+		Amount float64 `numeric:"packed" scale:"2"`
+		Count  *int32  `numeric:"packed"`
+	}
+
+	headers := map[string][]int{
+		"ID":     {0, 4},
+		"Amount": {4, 7},
+		"Count":  {7, 9},
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		record := append([]byte("A1  "), 0x12, 0x34, 0x5d, 0x99, 0x9c)
+		obtained := P{}
+
+		decoder := NewDecoder(bytes.NewReader(record))
+		decoder.SetHeaders(headers)
+		err := decoder.Decode(&obtained)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "A1", obtained.ID)
+		assert.Equal(t, -123.45, obtained.Amount)
+		if assert.NotNil(t, obtained.Count) {
+			assert.Equal(t, int32(999), *obtained.Count)
+		}
+	})
+
+	t.Run("bad sign", func(t *testing.T) {
+		record := append([]byte("A1  "), 0x12, 0x34, 0x55, 0x99, 0x9c)
+		obtained := P{}
+
+		decoder := NewDecoder(bytes.NewReader(record))
+		decoder.SetHeaders(headers)
+		err := decoder.Decode(&obtained)
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "invalid packed sign")
+	})
+
+	t.Run("scaled int", func(t *testing.T) {
+		type Q struct {
+			Count int `numeric:"packed" scale:"2"`
+		}
+		obtained := Q{}
+
+		decoder := NewDecoder(bytes.NewReader([]byte{0x12, 0x3c}))
+		decoder.SetHeaders(map[string][]int{"Count": {0, 2}})
+		err := decoder.Decode(&obtained)
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), `unable to create a converter for field "Count"`)
+	})
+}
+
+func TestFloat32Precision(t *testing.T) {
+
+	type F struct {
+		Value  float32
+		PValue *float32
+	}
+
+	// Values which round differently when parsed as a float64 and then converted to float32.
+	values := []string{
+		"1.00000017881393432617187499",
+		"0.1",
+		"16777217.0",
+		"3.4028234664e+38",
+		"1.4012984643e-45",
+	}
+
+	for _, value := range values {
+		t.Run(value, func(t *testing.T) {
+			expected, err := strconv.ParseFloat(value, 32)
+			assert.Nil(t, err)
+
+			data := fmt.Sprintf("%-30s%-30s\n%-30s%-30s", "Value", "PValue", value, value)
+			obtained := F{}
+			err = Unmarshal([]byte(data), &obtained)
+
+			assert.Nil(t, err)
+			assert.Equal(t, float32(expected), obtained.Value)
+			if assert.NotNil(t, obtained.PValue) {
+				assert.Equal(t, float32(expected), *obtained.PValue)
+			}
+		})
+	}
+}
+
+func TestAliases(t *testing.T) {
+
+	type A struct {
+		Name string    `column:"name"`
+		DOB  time.Time `column:"dob" format:"2006-01-02"`
+	}
+
+	source := []byte("name    date_of_birth\nPeter   2008-10-11   ")
+	expected := A{Name: "Peter", DOB: time.Date(2008, 10, 11, 0, 0, 0, 0, time.UTC)}
+
+	t.Run("alias", func(t *testing.T) {
+		obtained := A{}
+		decoder := NewDecoder(bytes.NewReader(source))
+		decoder.SetAliases(map[string]string{"date_of_birth": "dob", "missing": "other"})
+
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, obtained)
+	})
+
+	t.Run("no alias", func(t *testing.T) {
+		obtained := A{}
+		decoder := NewDecoder(bytes.NewReader(source))
+
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, A{Name: "Peter"}, obtained)
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		obtained := A{}
+		decoder := NewDecoder(bytes.NewReader(source))
+		decoder.StrictFields = true
+		decoder.SetAliases(map[string]string{"date_of_birth": "dob", "missing": "other", "absent": "name"})
+
+		err := decoder.Decode(&obtained)
+		assert.Equal(t, &UnknownAliasError{Aliases: []string{"absent", "missing"}, LineNum: 1}, err)
+		assert.Equal(t, A{}, obtained)
+
+		decoder = NewDecoder(bytes.NewReader(source))
+		decoder.StrictFields = true
+		decoder.SetAliases(map[string]string{"date_of_birth": "dob"})
+		assert.Nil(t, decoder.Decode(&obtained))
+		assert.Equal(t, expected, obtained)
+	})
+}
+
+// endlessReader produces an infinite stream of records, calling cancel once limit bytes have been read.
+type endlessReader struct {
+	read   int
+	limit  int
+	cancel func()
+}
+
+func (reader *endlessReader) Read(p []byte) (int, error) {
+	record := []byte("1   \n")
+	n := 0
+	for n+len(record) <= len(p) {
+		n += copy(p[n:], record)
+	}
+	reader.read += n
+	if reader.read >= reader.limit {
+		reader.cancel()
+	}
+	return n, nil
+}
+
+func TestDecodeContext(t *testing.T) {
+
+	type I struct {
+		Int int
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		reader := &endlessReader{limit: 100000, cancel: cancel}
+		obtained := []I{}
+
+		err := UnmarshalReaderContext(ctx, io.MultiReader(bytes.NewReader([]byte("Int \n")), reader), &obtained)
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.NotEmpty(t, obtained)
+		assert.Equal(t, I{Int: 1}, obtained[0])
+	})
+
+	t.Run("already done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		obtained := []I{}
+
+		err := UnmarshalReaderContext(ctx, bytes.NewReader([]byte("Int \n1   ")), &obtained)
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Empty(t, obtained)
+	})
+
+	t.Run("max record size", func(t *testing.T) {
+		obtained := []I{}
+		decoder := NewDecoder(bytes.NewReader([]byte("Int \n1   \n" + strings.Repeat(" ", 100))))
+		assert.Nil(t, decoder.SetMaxRecordSize(50))
+
+		err := decoder.Decode(&obtained)
+
+		assert.ErrorIs(t, err, bufio.ErrTooLong)
+		assert.Equal(t, []I{{Int: 1}}, obtained)
+	})
+
+	t.Run("invalid max record size", func(t *testing.T) {
+		decoder := NewDecoder(bytes.NewReader([]byte("Int \n1   \n")))
+		assert.NotNil(t, decoder.SetMaxRecordSize(0))
+		assert.NotNil(t, decoder.SetMaxRecordSize(-1))
+
+		obtained := I{}
+		assert.Nil(t, decoder.Decode(&obtained))
+		assert.Equal(t, ErrDecodingStarted, decoder.SetMaxRecordSize(50))
+	})
+}
+
+type SetterStruct struct {
+	Currency string
+	amount   string `column:"Amount" setter:"SetAmount"`
+}
+
+func (s *SetterStruct) SetAmount(raw string) error {
+	if raw == "" {
+		return fmt.Errorf("missing amount")
+	}
+	s.amount = raw + " " + s.Currency
+	return nil
+}
+
+func (s *SetterStruct) BadSetter(raw string) {}
+
+func TestSetterMethod(t *testing.T) {
+
+	t.Run("valid", func(t *testing.T) {
+		obtained := []SetterStruct{}
+		err := Unmarshal([]byte("Currency Amount\nGBP      10.50 "), &obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, []SetterStruct{{Currency: "GBP", amount: "10.50 GBP"}}, obtained)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		obtained := []SetterStruct{}
+		err := Unmarshal([]byte("Currency Amount\nGBP            "), &obtained)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "missing amount")
+	})
+
+	t.Run("bad signature", func(t *testing.T) {
+		type B struct {
+			SetterStruct
+			Value string `setter:"BadSetter"`
+		}
+		obtained := []B{}
+		err := Unmarshal([]byte("Value\nhello"), &obtained)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), `setter method "BadSetter" for field "Value"`)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		type C struct {
+			Value string `setter:"setValue"`
+		}
+		obtained := []C{}
+		err := Unmarshal([]byte("Value\nhello"), &obtained)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), `setter method "setValue" for field "Value"`)
+		if assert.IsType(t, &InvalidSetterError{}, err) {
+			assert.True(t, err.(*InvalidSetterError).Unexported)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		type D struct {
+			Value string `setter:"SetValue"`
+		}
+		obtained := []D{}
+		err := Unmarshal([]byte("Value\nhello"), &obtained)
+		assert.Equal(t, &InvalidSetterError{Field: reflect.TypeOf(D{}).Field(0), Method: "SetValue"}, err)
+	})
+}
+
+func TestMaxLen(t *testing.T) {
+
+	type M struct {
+		Key  string  `column:"Name" maxlen:"5"`
+		Name *string `maxlen:"20"`
+	}
+
+	t.Run("truncate", func(t *testing.T) {
+		obtained := M{}
+		err := Unmarshal([]byte("Name                \n  𝜶βγδεζη Smith     "), &obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, "𝜶βγδε", obtained.Key)
+		if assert.NotNil(t, obtained.Name) {
+			assert.Equal(t, "𝜶βγδεζη Smith", *obtained.Name)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		type N struct {
+			Name string `maxlen:"-1"`
+		}
+		obtained := N{}
+		err := Unmarshal([]byte("Name\nabcd"), &obtained)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), `invalid maxlen annotation "-1" for field "Name"`)
+	})
+}
+
+func TestDelimited(t *testing.T) {
+
+	type D struct {
+		Name   string
+		Amount float64
+		When   time.Time `column:"Date" format:"2006-01-02"`
+	}
+
+	source := []byte("Name      \tAmount\tDate      \nPeter     \t  10.5\t2024-01-01\n\nNicki     \t  -1.5\t2024-01-09")
+
+	t.Run("header", func(t *testing.T) {
+		obtained := []D{}
+		decoder := NewDecoder(bytes.NewReader(source))
+		decoder.Delimited = true
+		decoder.FieldSeparator = "\t"
+		decoder.IgnoreEmptyRecords = true
+
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, []D{
+			{Name: "Peter", Amount: 10.5, When: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			{Name: "Nicki", Amount: -1.5, When: time.Date(2024, 1, 9, 0, 0, 0, 0, time.UTC)},
+		}, obtained)
+	})
+
+	t.Run("explicit", func(t *testing.T) {
+		obtained := []D{}
+		decoder := NewDecoder(bytes.NewReader([]byte("Peter\t10.5\t2024-01-01\nNicki\t-1.5\t2024-01-09")))
+		decoder.Delimited = true
+		decoder.FieldSeparator = "\t"
+		decoder.SetHeaders(map[string][]int{"Name": {0, 1}, "Date": {2, 3}})
+
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, []D{
+			{Name: "Peter", When: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			{Name: "Nicki", When: time.Date(2024, 1, 9, 0, 0, 0, 0, time.UTC)},
+		}, obtained)
+	})
+
+	t.Run("wrong length", func(t *testing.T) {
+		obtained := []D{}
+		decoder := NewDecoder(bytes.NewReader([]byte("Name\tAmount\tDate\nPeter\t10.5")))
+		decoder.Delimited = true
+		decoder.FieldSeparator = "\t"
+
+		err := decoder.Decode(&obtained)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "wrong data length in line 2")
+	})
+}
+
+func TestGzip(t *testing.T) {
+
+	type C struct {
+		Alpha  string
+		Beta   string
+		Number float32
+		When   time.Time `column:"Date" format:"2006-01-02"`
+	}
+
+	expected := []C{
+		{Alpha: "𝜶", Beta: "Β", Number: 0.9, When: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Alpha: "Α", Beta: "β", Number: -1.4, When: time.Date(2024, 1, 9, 0, 0, 0, 0, time.UTC)},
+	}
+
+	compressed := &bytes.Buffer{}
+	zw := gzip.NewWriter(compressed)
+	_, err := zw.Write(differentRecord)
+	assert.Nil(t, err)
+	assert.Nil(t, zw.Close())
+
+	t.Run("compressed", func(t *testing.T) {
+		obtained := []C{}
+		decoder := NewDecoder(bytes.NewReader(compressed.Bytes()))
+		assert.Nil(t, decoder.SetGzip(true))
+		decoder.RecordTerminator = []byte{'|'}
+
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, obtained)
+	})
+
+	t.Run("not compressed", func(t *testing.T) {
+		obtained := []C{}
+		decoder := NewDecoder(bytes.NewReader(differentRecord))
+		assert.Nil(t, decoder.SetGzip(true))
+		assert.Nil(t, decoder.SetGzip(false))
+		decoder.RecordTerminator = []byte{'|'}
+
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, obtained)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		obtained := []C{}
+		decoder := NewDecoder(bytes.NewReader(differentRecord))
+		assert.Nil(t, decoder.SetGzip(true))
+
+		err := decoder.Decode(&obtained)
+		assert.ErrorIs(t, err, gzip.ErrHeader)
+	})
+
+	t.Run("too late", func(t *testing.T) {
+		obtained := C{}
+		decoder := NewDecoder(bytes.NewReader(differentRecord))
+		decoder.RecordTerminator = []byte{'|'}
+		assert.Nil(t, decoder.Decode(&obtained))
+		assert.Equal(t, ErrDecodingStarted, decoder.SetGzip(true))
+
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, expected[1], obtained)
+	})
+}
+
+func TestPeek(t *testing.T) {
+
+	type A struct {
+		Alpha  string
+		Number float32
+	}
+
+	decoder := NewDecoder(bytes.NewReader(multiData))
+
+	record, err := decoder.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, "𝜶        Β     0.9        2024-01-01", record)
+
+	record, err = decoder.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, "𝜶        Β     0.9        2024-01-01", record)
+
+	a := A{}
+	err = decoder.Decode(&a)
+	assert.Nil(t, err)
+	assert.Equal(t, A{Alpha: "𝜶", Number: 0.9}, a)
+
+	record, err = decoder.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, "Α        β     -1.4       2024-01-09", record)
+
+	all := []A{}
+	err = decoder.Decode(&all)
+	assert.Nil(t, err)
+	assert.Equal(t, []A{{Alpha: "Α", Number: -1.4}}, all)
+
+	_, err = decoder.Peek()
+	assert.Equal(t, io.EOF, err)
+
+	_, err = NewDecoder(bytes.NewReader(nil)).Peek()
+	assert.Equal(t, io.EOF, err)
+
+	// A header line discarded after SetHeaders is skipped before the record is read.
+	type B struct {
+		A string
+		B string
+	}
+	decoder = NewDecoder(strings.NewReader("hdr hdr\naa  bb \ncc  dd \n"))
+	decoder.SetHeaders(map[string][]int{"A": {0, 4}, "B": {4, 7}})
+	decoder.SkipFirstRecord = true
+	record, err = decoder.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, "aa  bb ", record)
+	b := B{}
+	assert.Nil(t, decoder.Decode(&b))
+	assert.Equal(t, B{A: "aa", B: "bb"}, b)
+	record, err = decoder.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, "cc  dd ", record)
+}
+
+func TestDecodeFunc(t *testing.T) {
+
+	type Person struct {
+		Name string
+		Age  int `column:"Value"`
+	}
+
+	type Comment struct {
+		Text string `column:"Value"`
+	}
+
+	source := []byte("T Name  Value\nP Peter 15   \nC       hello\nX       skip \nP Nicki 37   ")
+
+	obtained := []interface{}{}
+	decoder := NewDecoder(bytes.NewReader(source))
+	err := decoder.DecodeFunc(func(raw string) (interface{}, error) {
+		switch raw[0] {
+		case 'P':
+			return &Person{}, nil
+		case 'C':
+			return &Comment{}, nil
+		default:
+			return nil, nil
+		}
+	}, func(v interface{}) error {
+		obtained = append(obtained, v)
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{
+		&Person{Name: "Peter", Age: 15},
+		&Comment{Text: "hello"},
+		&Person{Name: "Nicki", Age: 37},
+	}, obtained)
+
+	t.Run("bad value", func(t *testing.T) {
+		decoder := NewDecoder(bytes.NewReader(source))
+		err := decoder.DecodeFunc(func(raw string) (interface{}, error) {
+			return Person{}, nil
+		}, func(v interface{}) error {
+			return nil
+		})
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "input value is not a non-nil pointer")
+	})
+}
+
+func cachedSetterCount() int {
+	n := 0
+	structSetterCache.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func TestSetterCache(t *testing.T) {
+
+	type Uncached struct {
+		Alpha  string
+		Number float32
+	}
+
+	ClearSetterCache()
+	assert.Equal(t, 0, cachedSetterCount())
+
+	obtained := []Uncached{}
+	decoder := NewDecoder(bytes.NewReader(multiData))
+	decoder.DisableSetterCache = true
+	err := decoder.Decode(&obtained)
+	assert.Nil(t, err)
+	assert.Len(t, obtained, 2)
+	assert.Equal(t, 0, cachedSetterCount())
+
+	obtained = []Uncached{}
+	err = Unmarshal(multiData, &obtained)
+	assert.Nil(t, err)
+	assert.Len(t, obtained, 2)
+	assert.Equal(t, 1, cachedSetterCount())
+
+	ClearSetterCache()
+	assert.Equal(t, 0, cachedSetterCount())
+}
+
+func TestConverterCache(t *testing.T) {
+
+	type Converted struct {
+		Alpha  string
+		Number float32
+	}
+
+	exclaim := func(raw string) (interface{}, error) {
+		return raw + "!", nil
+	}
+	quoted := func(raw string) (interface{}, error) {
+		return strconv.Quote(raw), nil
+	}
+
+	decode := func(converter Converter) []Converted {
+		obtained := []Converted{}
+		decoder := NewDecoder(bytes.NewReader(multiData))
+		if converter != nil {
+			decoder.RegisterConverter(reflect.TypeOf(""), converter)
+		}
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		return obtained
+	}
+
+	assert.Equal(t, []Converted{{Alpha: "𝜶", Number: 0.9}, {Alpha: "Α", Number: -1.4}}, decode(nil))
+	assert.Equal(t, []Converted{{Alpha: "𝜶!", Number: 0.9}, {Alpha: "Α!", Number: -1.4}}, decode(exclaim))
+	assert.Equal(t, []Converted{{Alpha: `"𝜶"`, Number: 0.9}, {Alpha: `"Α"`, Number: -1.4}}, decode(quoted))
+	assert.Equal(t, []Converted{{Alpha: "𝜶", Number: 0.9}, {Alpha: "Α", Number: -1.4}}, decode(nil))
+
+	t.Run("bad converter", func(t *testing.T) {
+		obtained := []Converted{}
+		decoder := NewDecoder(bytes.NewReader(multiData))
+		decoder.RegisterConverter(reflect.TypeOf(float32(0)), func(raw string) (interface{}, error) {
+			return raw, nil
+		})
+		err := decoder.Decode(&obtained)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "converter returned string")
+	})
+
+	t.Run("pointer", func(t *testing.T) {
+		type P struct {
+			Alpha *string
+		}
+		obtained := P{}
+		decoder := NewDecoder(bytes.NewReader(multiData))
+		decoder.RegisterConverter(reflect.TypeOf(""), quoted)
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		if assert.NotNil(t, obtained.Alpha) {
+			assert.Equal(t, `"𝜶"`, *obtained.Alpha)
+		}
+	})
+}
+
+func TestSubRecord(t *testing.T) {
+
+	type Code struct {
+		Region string `width:"3"`
+		Branch int    `width:"4"`
+		Ignore string
+		Suffix string `width:"2"`
+	}
+
+	type Account struct {
+		Name    string
+		Code    Code
+		Pointer *Code `column:"Other"`
+	}
+
+	source := []byte("Name  Code      Other    \nPeter UK 0012XY EU 99  Z ")
+
+	obtained := Account{}
+	err := Unmarshal(source, &obtained)
+	assert.Nil(t, err)
+	assert.Equal(t, Account{
+		Name:    "Peter",
+		Code:    Code{Region: "UK", Branch: 12, Suffix: "XY"},
+		Pointer: &Code{Region: "EU", Branch: 99, Suffix: "Z"},
+	}, obtained)
+
+	t.Run("too wide", func(t *testing.T) {
+		type Narrow struct {
+			Name string
+			Code Code `column:"C"`
+		}
+		obtained := Narrow{}
+		err := Unmarshal([]byte("Name  C       \nPeter UK 00123"), &obtained)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), `invalid width annotation "2" for field "Suffix"`)
+	})
+}
+
+func TestMultiplePointers(t *testing.T) {
+
+	type M struct {
+		Int   **int
+		Time  ***time.Time `format:"2006-01-02"`
+		Size  **DataSize
+		Other **string
+	}
+
+	obtained := M{}
+	err := Unmarshal([]byte("Int Time       Size  \n42  2024-01-09 20.5mb"), &obtained)
+	assert.Nil(t, err)
+
+	if assert.NotNil(t, obtained.Int) && assert.NotNil(t, *obtained.Int) {
+		assert.Equal(t, 42, **obtained.Int)
+	}
+	if assert.NotNil(t, obtained.Time) && assert.NotNil(t, *obtained.Time) && assert.NotNil(t, **obtained.Time) {
+		assert.Equal(t, time.Date(2024, 1, 9, 0, 0, 0, 0, time.UTC), ***obtained.Time)
+	}
+	if assert.NotNil(t, obtained.Size) && assert.NotNil(t, *obtained.Size) {
+		assert.Equal(t, DataSize{Value: 20.5, Units: "mb"}, **obtained.Size)
+	}
+	assert.Nil(t, obtained.Other)
+
+	t.Run("existing", func(t *testing.T) {
+		var value int
+		outer := &value
+		obtained := M{Int: &outer}
+		err := Unmarshal([]byte("Int\n7  "), &obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, &outer, obtained.Int)
+		assert.Equal(t, 7, **obtained.Int)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		type U struct {
+			Values **[]int
+		}
+		obtained := U{}
+		err := Unmarshal([]byte("Values\n1     "), &obtained)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), `unable to create a converter for field "Values" for type "**[]int"`)
+	})
+}
+
+func TestRequireMappedFields(t *testing.T) {
+
+	type Unmapped struct {
+		Name string
+		Age  int
+	}
+
+	t.Run("default", func(t *testing.T) {
+		obtained := []Unmapped{}
+		err := Unmarshal(multiData, &obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, []Unmapped{{}, {}}, obtained)
+	})
+
+	t.Run("required", func(t *testing.T) {
+		obtained := []Unmapped{}
+		decoder := NewDecoder(bytes.NewReader(multiData))
+		decoder.RequireMappedFields = true
+		err := decoder.Decode(&obtained)
+		assert.ErrorIs(t, err, ErrNoMappedFields)
+		assert.Contains(t, err.Error(), "fw.Unmapped")
+		assert.Empty(t, obtained)
+	})
+
+	t.Run("mapped", func(t *testing.T) {
+		type Mapped struct {
+			Alpha string
+			Name  string
+		}
+		obtained := []Mapped{}
+		decoder := NewDecoder(bytes.NewReader(multiData))
+		decoder.RequireMappedFields = true
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Len(t, obtained, 2)
+	})
+}
+
+func TestDecodeRecords(t *testing.T) {
+
+	type C struct {
+		Alpha  string
+		Number float32
+	}
+
+	records := bytes.Split(bytes.TrimSpace(multiData), []byte("\n"))
+
+	t.Run("batches", func(t *testing.T) {
+		obtained := []C{}
+		decoder := NewDecoder(nil)
+
+		err := decoder.DecodeRecords(records[:2], &obtained)
+		assert.Nil(t, err)
+		err = decoder.DecodeRecords(records[2:], &obtained)
+		assert.Nil(t, err)
+		err = decoder.DecodeRecords(nil, &obtained)
+		assert.Nil(t, err)
+
+		assert.Equal(t, []C{{Alpha: "𝜶", Number: 0.9}, {Alpha: "Α", Number: -1.4}}, obtained)
+	})
+
+	t.Run("explicit headers", func(t *testing.T) {
+		obtained := C{}
+		decoder := NewDecoder(nil)
+		decoder.SetHeaders(map[string][]int{"Alpha": {0, 7}, "Number": {13, 26}, "Date": {26, 36}})
+
+		err := decoder.DecodeRecords(records[2:], &obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, C{Alpha: "Α", Number: -1.4}, obtained)
+
+		err = decoder.DecodeRecords(nil, &obtained)
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("length", func(t *testing.T) {
+		obtained := []C{}
+		decoder := NewDecoder(nil)
+
+		err := decoder.DecodeRecords([][]byte{records[0], []byte("too short")}, &obtained)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "wrong data length in line 2")
+	})
+}
+
+func TestWidthMode(t *testing.T) {
+
+	type W struct {
+		Name string
+		City string
+		Code int
+	}
+
+	expected := []W{{Name: "山田太郎", City: "東京", Code: 1}, {Name: "John", City: "London", Code: 2}}
+
+	t.Run("cells", func(t *testing.T) {
+		source := "Name     City   Code\n山田太郎 東京   1   \nJohn     London 2   "
+		obtained := []W{}
+		decoder := NewDecoder(strings.NewReader(source))
+		decoder.WidthMode = WidthCells
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, obtained)
+	})
+
+	t.Run("combining marks", func(t *testing.T) {
+		// The accent of a decomposed "é" at the end of a column stays with its letter.
+		source := "Name City  Code\nAndre\u0301Paris 3   \nJo   Nice  4   "
+		obtained := []W{}
+		decoder := NewDecoder(strings.NewReader(source))
+		decoder.WidthMode = WidthCells
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, []W{{Name: "Andre\u0301", City: "Paris", Code: 3}, {Name: "Jo", City: "Nice", Code: 4}}, obtained)
+	})
+
+	t.Run("bytes", func(t *testing.T) {
+		source := "Name         City   Code\n山田太郎 東京 1   \nJohn         London 2   "
+		obtained := []W{}
+		decoder := NewDecoder(strings.NewReader(source))
+		decoder.WidthMode = WidthBytes
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, obtained)
+	})
+
+	t.Run("runes", func(t *testing.T) {
+		source := "Name City   Code\n山田太郎 東京     1   \nJohn London 2   "
+		obtained := []W{}
+		err := Unmarshal([]byte(source), &obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, obtained)
+	})
+
+	t.Run("non-ascii header", func(t *testing.T) {
+		type N struct {
+			Name string `column:"名前"`
+			City string `column:"都市"`
+		}
+		obtained := []N{}
+		err := Unmarshal([]byte("名前   都市\n山田   東京"), &obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, []N{{Name: "山田", City: "東京"}}, obtained)
+	})
+}
+
+func TestCheckTypeLayout(t *testing.T) {
+
+	type Short struct {
+		Kind string `column:"K"`
+		Name string
+	}
+
+	type Long struct {
+		Kind   string `column:"K"`
+		Name   string
+		Amount int
+	}
+
+	source := []byte("K Name  Amount\nS Peter \nL Nicki 15    \nL Chuck ")
+
+	decode := func() ([]interface{}, error) {
+		obtained := []interface{}{}
+		decoder := NewDecoder(bytes.NewReader(source))
+		decoder.SkipLengthCheck = true
+		decoder.CheckTypeLayout = true
+		err := decoder.DecodeFunc(func(raw string) (interface{}, error) {
+			if raw[0] == 'S' {
+				return &Short{}, nil
+			}
+			return &Long{}, nil
+		}, func(v interface{}) error {
+			obtained = append(obtained, v)
+			return nil
+		})
+		return obtained, err
+	}
+
+	obtained, err := decode()
+	assert.Equal(t, []interface{}{&Short{Kind: "S", Name: "Peter"}, &Long{Kind: "L", Name: "Nicki", Amount: 15}}, obtained)
+
+	var layoutErr *LayoutMismatchError
+	if assert.ErrorAs(t, err, &layoutErr) {
+		assert.Equal(t, "L Chuck ", layoutErr.Line)
+		assert.Equal(t, reflect.TypeOf(Long{}), layoutErr.Type)
+		assert.Equal(t, 4, layoutErr.LineNum)
+		assert.Contains(t, err.Error(), "record in line 4 is too short for type fw.Long (8 < 14)")
+	}
+}
+
+func TestCountRecords(t *testing.T) {
+
+	count, err := CountRecords(bytes.NewReader(multiData))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	decoder := NewDecoder(bytes.NewReader(differentRecord))
+	decoder.RecordTerminator = []byte{'|'}
+	count, err = decoder.CountRecords()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	decoder = NewDecoder(bytes.NewReader(blankLines))
+	decoder.IgnoreEmptyRecords = true
+	count, err = decoder.CountRecords()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	count, err = CountRecords(bytes.NewReader(blankLines))
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, count)
+
+	count, err = CountRecords(bytes.NewReader(nil))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestColumnDelimiter(t *testing.T) {
+
+	type T struct {
+		Name  string
+		Count int
+		Note  string
+	}
+
+	expected := []T{{Name: "Peter", Count: 12, Note: "a|b"}, {Name: "Nicki", Count: -3}}
+
+	t.Run("header", func(t *testing.T) {
+		source := "| Name  | Count | Note |\n| Peter |    12 | a|b  |\n| Nicki |    -3 |      |"
+		obtained := []T{}
+		decoder := NewDecoder(strings.NewReader(source))
+		decoder.ColumnDelimiter = '|'
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, obtained)
+	})
+
+	t.Run("no outer delimiters", func(t *testing.T) {
+		source := "Name  | Count | Note\nPeter |    12 | a|b \nNicki |    -3 |     "
+		obtained := []T{}
+		decoder := NewDecoder(strings.NewReader(source))
+		decoder.ColumnDelimiter = '|'
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, obtained)
+	})
+
+	t.Run("explicit", func(t *testing.T) {
+		source := "| Peter |    12 | a|b  |\n| Nicki |    -3 |      |"
+		obtained := []T{}
+		decoder := NewDecoder(strings.NewReader(source))
+		decoder.ColumnDelimiter = '|'
+		decoder.SetHeaders(map[string][]int{"Name": {0, 8}, "Count": {8, 16}, "Note": {16, 24}})
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, obtained)
+	})
+}
+
+func BenchmarkDecodeSlice(b *testing.B) {
+
+	type C struct {
+		Alpha  string
+		Beta   string
+		Number float32
+		When   time.Time `column:"Date" format:"2006-01-02"`
+	}
+
+	lines := bytes.SplitN(multiData, []byte("\n"), 2)
+	source := bytes.Buffer{}
+	source.Write(lines[0])
+	for i := 0; i < 1000; i++ {
+		source.WriteByte('\n')
+		source.Write(bytes.TrimSpace(lines[1][:bytes.IndexByte(lines[1], '\n')]))
+	}
+	data := source.Bytes()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		obtained := make([]C, 0, 1000)
+		if err := Unmarshal(data, &obtained); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCreateStructSetter(b *testing.B) {
+
+	type W struct {
+		A, B, C, D, E, F, G, H, I, J, K, L string
+		N, O, P, Q                         int
+		R, S                               float64
+	}
+
+	headers := make(map[string][]int)
+	t := reflect.TypeOf(W{})
+	for i := 0; i < t.NumField(); i++ {
+		headers[t.Field(i).Name] = []int{i * 4, i*4 + 4}
+	}
+	config := setterConfig{headers: headers, fieldSeparator: " "}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := createStructSetter(t, config); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestKeyValueMap(t *testing.T) {
+
+	type Labels map[string]string
+
+	type K struct {
+		Name   string
+		Attrs  map[string]string
+		Labels Labels `kv:",:"`
+	}
+
+	source := "Name  Attrs               Labels       \n" +
+		"Peter k1=v1; k2 = v2;     a:b,c:       \n" +
+		"Nicki                                  "
+
+	obtained := []K{}
+	err := Unmarshal([]byte(source), &obtained)
+	assert.Nil(t, err)
+	assert.Equal(t, []K{
+		{Name: "Peter", Attrs: map[string]string{"k1": "v1", "k2": "v2"}, Labels: Labels{"a": "b", "c": ""}},
+		{Name: "Nicki", Attrs: map[string]string{}, Labels: Labels{}},
+	}, obtained)
+
+	t.Run("malformed", func(t *testing.T) {
+		obtained := []K{}
+		err := Unmarshal([]byte("Attrs    \nk1=v1;k2 "), &obtained)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), `failed casting "k1=v1;k2" to "Attrs:map[string]string"`)
+	})
+
+	t.Run("bad tag", func(t *testing.T) {
+		type B struct {
+			Attrs map[string]string `kv:";"`
+		}
+		obtained := []B{}
+		err := Unmarshal([]byte("Attrs\nk1=v1"), &obtained)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), `invalid kv annotation ";" for field "Attrs"`)
+	})
+}
+
+func TestTrailingTerminator(t *testing.T) {
+
+	type R struct {
+		Name string
+		Code int
+	}
+
+	expected := []R{{Name: "Peter", Code: 1}, {Name: "Nicki", Code: 2}}
+
+	tests := []struct {
+		name     string
+		source   string
+		trim     bool
+		expected []R
+		err      string
+	}{
+		{name: "terminated", source: "Name  Code\r\nPeter 1   \r\nNicki 2   \r\n", expected: expected},
+		{name: "unterminated", source: "Name  Code\r\nPeter 1   \r\nNicki 2   ", expected: expected},
+		{name: "terminated trim", source: "Name  Code\r\nPeter 1   \r\nNicki 2   \r\n", trim: true, expected: expected},
+		{name: "unterminated trim", source: "Name  Code\r\nPeter 1   \r\nNicki 2   ", trim: true, expected: expected},
+		{name: "partial", source: "Name  Code\r\nPeter 1   \r\nNicki 2   \r", err: "wrong data length in line 3"},
+		{name: "partial trim", source: "Name  Code\r\nPeter 1   \r\nNicki 2   \r", trim: true, expected: expected},
+		{name: "dangling", source: "Name  Code\r\nPeter 1   \r\nNicki 2   \r\n\r", err: "wrong data length in line 4"},
+		{name: "dangling trim", source: "Name  Code\r\nPeter 1   \r\nNicki 2   \r\n\r", trim: true, expected: expected},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			obtained := []R{}
+			decoder := NewDecoder(strings.NewReader(test.source))
+			decoder.RecordTerminator = []byte("\r\n")
+			decoder.TrimPartialTerminator = test.trim
+			err := decoder.Decode(&obtained)
+			if test.err != "" {
+				assert.NotNil(t, err)
+				assert.Contains(t, err.Error(), test.err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, test.expected, obtained)
+			}
+		})
+	}
+}
+
+func TestCharset(t *testing.T) {
+
+	type C struct {
+		Name string `column:"Name" charset:"Shift_JIS"`
+		City string
+		Code int
+	}
+
+	name, _ := japanese.ShiftJIS.NewEncoder().String("山田太郎")
+	source := "Name     City   Code\n" + name + " 東京 1   \nJohn     London 2   "
+	expected := []C{{Name: "山田太郎", City: "東京", Code: 1}, {Name: "John", City: "London", Code: 2}}
+
+	t.Run("bytes", func(t *testing.T) {
+		obtained := []C{}
+		decoder := NewDecoder(strings.NewReader(source))
+		decoder.WidthMode = WidthBytes
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, obtained)
+	})
+
+	t.Run("delimited", func(t *testing.T) {
+		obtained := []C{}
+		decoder := NewDecoder(strings.NewReader("Name,City,Code\n" + name + ",東京,1"))
+		decoder.Delimited = true
+		decoder.FieldSeparator = ","
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, expected[:1], obtained)
+	})
+
+	t.Run("runes", func(t *testing.T) {
+		obtained := []C{}
+		err := Unmarshal([]byte("Name     City   Code\nJohn     London 2   "), &obtained)
+		assert.IsType(t, &InvalidTagError{}, err)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		type U struct {
+			Name string `charset:"no-such-charset"`
+		}
+		obtained := []U{}
+		decoder := NewDecoder(strings.NewReader("Name\nJohn"))
+		decoder.WidthMode = WidthBytes
+		err := decoder.Decode(&obtained)
+		assert.IsType(t, &InvalidTagError{}, err)
+	})
+}
+
+func TestStrictFields(t *testing.T) {
+
+	type Tagged struct {
+		Name string `column:"Name"`
+		Age  int    `column:"Years"`
+	}
+
+	type Untagged struct {
+		Name string
+		Age  int
+	}
+
+	source := "Name  Code\nPeter 1   "
+
+	t.Run("tagged", func(t *testing.T) {
+		obtained := []Tagged{}
+		decoder := NewDecoder(strings.NewReader(source))
+		decoder.StrictFields = true
+		err := decoder.Decode(&obtained)
+		if assert.IsType(t, &MissingColumnError{}, err) {
+			assert.Equal(t, "Years", err.(*MissingColumnError).Column)
+			assert.Equal(t, "Age", err.(*MissingColumnError).Field.Name)
+		}
+	})
+
+	t.Run("untagged", func(t *testing.T) {
+		obtained := []Untagged{}
+		decoder := NewDecoder(strings.NewReader(source))
+		decoder.StrictFields = true
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, []Untagged{{Name: "Peter"}}, obtained)
+	})
+
+	t.Run("lenient", func(t *testing.T) {
+		obtained := []Tagged{}
+		err := Unmarshal([]byte(source), &obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, []Tagged{{Name: "Peter"}}, obtained)
+	})
+}
+
+func TestChecksum(t *testing.T) {
+
+	type C struct {
+		Name string
+		Code int
+		Sum  int
+	}
+
+	sum := func(s string) (string, bool) {
+		total := 0
+		for _, c := range s {
+			total += int(c)
+		}
+		return strconv.Itoa(total % 100), true
+	}
+	row := func(prefix string) string {
+		value, _ := sum(prefix)
+		return prefix + fmt.Sprintf("%-3s", value)
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		source := "Name  Code Sum\n" + row("Peter 1    ") + "\n" + row("Nicki 2    ")
+		obtained := []C{}
+		decoder := NewDecoder(strings.NewReader(source))
+		decoder.RegisterChecksum("Sum", sum)
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		if assert.Len(t, obtained, 2) {
+			assert.Equal(t, "Nicki", obtained[1].Name)
+			expected, _ := sum("Nicki 2    ")
+			assert.Equal(t, expected, strconv.Itoa(obtained[1].Sum))
+		}
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		line := row("Peter 1    ")
+		source := "Name  Code Sum\n" + row("Nicki 2    ") + "\nPetra 1    " + line[11:]
+		obtained := []C{}
+		decoder := NewDecoder(strings.NewReader(source))
+		decoder.RegisterChecksum("Sum", sum)
+		err := decoder.Decode(&obtained)
+		if assert.IsType(t, &ChecksumError{}, err) {
+			assert.Equal(t, 3, err.(*ChecksumError).LineNum)
+			assert.Equal(t, "Sum", err.(*ChecksumError).Column)
+		}
+		assert.Len(t, obtained, 1)
+	})
+
+	t.Run("delimited", func(t *testing.T) {
+		value, _ := sum("Peter,1")
+		obtained := []C{}
+		decoder := NewDecoder(strings.NewReader("Name,Code,Sum\nPeter,1," + value))
+		decoder.Delimited = true
+		decoder.FieldSeparator = ","
+		decoder.RegisterChecksum("Sum", sum)
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Len(t, obtained, 1)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		obtained := []C{}
+		decoder := NewDecoder(strings.NewReader("Name  Code Sum\n" + row("Peter 1    ")))
+		decoder.RegisterChecksum("Sum", func(string) (string, bool) { return "", false })
+		err := decoder.Decode(&obtained)
+		assert.IsType(t, &ChecksumError{}, err)
+	})
+
+	t.Run("missing column", func(t *testing.T) {
+		obtained := []C{}
+		decoder := NewDecoder(strings.NewReader("Name  Code\nPeter 1   \nNicki 2   "))
+		decoder.RegisterChecksum("Sum", sum)
+		err := decoder.Decode(&obtained)
+		assert.ErrorIs(t, err, ErrNoChecksumColumn)
+		assert.Contains(t, err.Error(), `"Sum"`)
+		assert.Empty(t, obtained)
+	})
+}
+
+func TestDecoderStats(t *testing.T) {
+
+	type S struct {
+		Name string
+		Code int
+	}
+
+	source := "Name  Code\nPeter 1   \n\nNicki 2   \nJohn  x   \n"
+	obtained := []S{}
+	decoder := NewDecoder(strings.NewReader(source))
+	decoder.IgnoreEmptyRecords = true
+	err := decoder.Decode(&obtained)
+	assert.IsType(t, &CastingError{}, err)
+	assert.Equal(t, DecoderStats{Records: 3, Skipped: 1, Errors: 1, Bytes: int64(len(source))}, decoder.Stats())
+
+	decoder.ResetStats()
+	assert.Equal(t, DecoderStats{}, decoder.Stats())
+
+	decoder = NewDecoder(strings.NewReader("Name  Code\nPeter 1   \nNicki 2"))
+	err = decoder.Decode(&obtained)
+	assert.IsType(t, &InvalidLengthError{}, err)
+	assert.Equal(t, DecoderStats{Records: 1, Errors: 1, Bytes: 29}, decoder.Stats())
+}
+
+func TestJoinedColumns(t *testing.T) {
+
+	type J struct {
+		Name   string  `column:"First+Last" join:" "`
+		Amount float64 `column:"Units+Cents" join:"."`
+		Code   string  `column:"First+Units"`
+	}
+
+	source := "First Last   Units Cents\nJohn  Smith  12    50   \nCher         7          "
+
+	t.Run("joined", func(t *testing.T) {
+		obtained := []J{}
+		err := Unmarshal([]byte(source), &obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, []J{
+			{Name: "John Smith", Amount: 12.5, Code: "John12"},
+			{Name: "Cher", Amount: 7, Code: "Cher7"},
+		}, obtained)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		type M struct {
+			Name string `column:"First+Middle"`
+		}
+		obtained := []M{}
+		decoder := NewDecoder(strings.NewReader(source))
+		decoder.StrictFields = true
+		err := decoder.Decode(&obtained)
+		assert.IsType(t, &MissingColumnError{}, err)
+	})
+
+	t.Run("setter", func(t *testing.T) {
+		type S struct {
+			Name string `column:"First+Last" setter:"SetName"`
+		}
+		obtained := []S{}
+		err := Unmarshal([]byte(source), &obtained)
+		assert.IsType(t, &InvalidTagError{}, err)
+	})
+}
+
+func TestColumnOrder(t *testing.T) {
+
+	type P struct {
+		Name string
+	}
+
+	decoder := NewDecoder(strings.NewReader("Zip   Name  Age\n12345 Peter 42 "))
+	assert.Nil(t, decoder.ColumnOrder())
+	obtained := []P{}
+	err := decoder.Decode(&obtained)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Zip", "Name", "Age"}, decoder.ColumnOrder())
+
+	decoder = NewDecoder(strings.NewReader("12345 Peter 42 "))
+	decoder.SetHeaders(map[string][]int{"Name": {6, 12}, "Age": {12, 15}, "Zip": {0, 6}, "Prefix": {0, 2}})
+	assert.Equal(t, []string{"Prefix", "Zip", "Name", "Age"}, decoder.ColumnOrder())
+}
+
+func TestISODates(t *testing.T) {
+
+	type D struct {
+		Week    time.Time  `format:"isoweek"`
+		Ordinal *time.Time `format:"ordinal"`
+	}
+
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		week, ordinal string
+		expected      time.Time
+	}{
+		{week: "2024-W05-3", ordinal: "2024-031", expected: date(2024, time.January, 31)},
+		{week: "2025-W01-1", ordinal: "2024-365", expected: date(2024, time.December, 30)},
+		{week: "2020-W53-5", ordinal: "2021-001", expected: date(2021, time.January, 1)},
+		{week: "2009-W53-7", ordinal: "2010-003", expected: date(2010, time.January, 3)},
+		{week: "2024W523  ", ordinal: "2024360 ", expected: date(2024, time.December, 25)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.week, func(t *testing.T) {
+			obtained := []D{}
+			source := fmt.Sprintf("Week       Ordinal \n%-10s %-8s", test.week, test.ordinal)
+			err := Unmarshal([]byte(source), &obtained)
+			if assert.Nil(t, err) && assert.Len(t, obtained, 1) {
+				assert.Equal(t, test.expected, obtained[0].Week)
+				assert.Equal(t, test.expected, *obtained[0].Ordinal)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"2021-W53-1", "2024-W00-1", "2024-W05-8", "2024-W5-3 ", "2024-05-03"} {
+		t.Run(invalid, func(t *testing.T) {
+			obtained := []D{}
+			err := Unmarshal([]byte(fmt.Sprintf("Week       Ordinal \n%-10s 2024-001", invalid)), &obtained)
+			assert.IsType(t, &CastingError{}, err)
+		})
+	}
+
+	for _, invalid := range []string{"2023-366", "2024-000", "2024-1-5", "24-045"} {
+		t.Run(invalid, func(t *testing.T) {
+			obtained := []D{}
+			err := Unmarshal([]byte(fmt.Sprintf("Week       Ordinal \n2024-W01-1 %-8s", invalid)), &obtained)
+			assert.IsType(t, &CastingError{}, err)
+		})
+	}
+
+	t.Run("leap", func(t *testing.T) {
+		obtained := []D{}
+		err := Unmarshal([]byte("Week       Ordinal \n2024-W01-1 2024-366"), &obtained)
+		if assert.Nil(t, err) {
+			assert.Equal(t, date(2024, time.December, 31), *obtained[0].Ordinal)
+		}
+	})
+}
+
+func TestTwoDigitYearPivot(t *testing.T) {
+
+	type P struct {
+		Date  time.Time  `format:"060102" pivot:"50"`
+		Other *time.Time `format:"060102"`
+	}
+
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		value    string
+		expected time.Time
+		other    time.Time
+	}{
+		{value: "271212", expected: date(2027, time.December, 12), other: date(2027, time.December, 12)},
+		{value: "491231", expected: date(2049, time.December, 31), other: date(2049, time.December, 31)},
+		{value: "500101", expected: date(1950, time.January, 1), other: date(2050, time.January, 1)},
+		{value: "700101", expected: date(1970, time.January, 1), other: date(1970, time.January, 1)},
+		{value: "000229", expected: date(2000, time.February, 29), other: date(2000, time.February, 29)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			obtained := []P{}
+			err := Unmarshal([]byte(fmt.Sprintf("Date   Other \n%s %s", test.value, test.value)), &obtained)
+			if assert.Nil(t, err) && assert.Len(t, obtained, 1) {
+				assert.Equal(t, test.expected, obtained[0].Date)
+				assert.Equal(t, test.other, *obtained[0].Other)
+			}
+		})
+	}
+
+	t.Run("no leap day", func(t *testing.T) {
+		type L struct {
+			Date time.Time `format:"060102" pivot:"0"`
+		}
+		obtained := []L{}
+		err := Unmarshal([]byte("Date  \n000229"), &obtained)
+		assert.IsType(t, &CastingError{}, err)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		type I struct {
+			Date time.Time `format:"060102" pivot:"x"`
+		}
+		obtained := []I{}
+		err := Unmarshal([]byte("Date  \n000229"), &obtained)
+		assert.IsType(t, &InvalidTagError{}, err)
+	})
+}
+
+func TestUnixTimes(t *testing.T) {
+
+	type U struct {
+		Seconds time.Time  `format:"unix"`
+		Millis  *time.Time `format:"unixmilli"`
+	}
+
+	source := "Seconds     Millis        \n1700000000  1700000000123 \n-86400      -1500         \n0           0             "
+
+	t.Run("utc", func(t *testing.T) {
+		obtained := []U{}
+		err := Unmarshal([]byte(source), &obtained)
+		if assert.Nil(t, err) && assert.Len(t, obtained, 3) {
+			assert.Equal(t, time.Date(2023, time.November, 14, 22, 13, 20, 0, time.UTC), obtained[0].Seconds)
+			assert.Equal(t, time.Date(2023, time.November, 14, 22, 13, 20, 123000000, time.UTC), *obtained[0].Millis)
+			assert.Equal(t, time.Date(1969, time.December, 31, 0, 0, 0, 0, time.UTC), obtained[1].Seconds)
+			assert.Equal(t, time.Date(1969, time.December, 31, 23, 59, 58, 500000000, time.UTC), *obtained[1].Millis)
+			assert.Equal(t, time.Unix(0, 0).UTC(), obtained[2].Seconds)
+		}
+	})
+
+	t.Run("location", func(t *testing.T) {
+		location := time.FixedZone("EST", -5*60*60)
+		obtained := []U{}
+		decoder := NewDecoder(strings.NewReader(source))
+		decoder.DefaultLocation = location
+		err := decoder.Decode(&obtained)
+		if assert.Nil(t, err) && assert.Len(t, obtained, 3) {
+			assert.Equal(t, location, obtained[1].Seconds.Location())
+			assert.Equal(t, time.Date(1969, time.December, 30, 19, 0, 0, 0, location), obtained[1].Seconds)
+			assert.True(t, obtained[1].Seconds.Equal(time.Unix(-86400, 0)))
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		obtained := []U{}
+		err := Unmarshal([]byte("Seconds     Millis        \n1.5         0             "), &obtained)
+		assert.IsType(t, &CastingError{}, err)
+	})
+}
+
+func TestRegisteredTimeLayouts(t *testing.T) {
+
+	type R struct {
+		Created time.Time  `format:"@iso"`
+		Week    *time.Time `format:"@week" default:"2024-W01-1"`
+		Literal time.Time  `format:"02/01/2006"`
+	}
+
+	source := "Created     Week       Literal   \n2024-02-29  2024-W05-3 31/12/2023\n2024-03-01             01/01/2024\n"
+
+	decoder := NewDecoder(strings.NewReader(source))
+	decoder.RegisterTimeLayout("iso", "2006-01-02")
+	decoder.RegisterTimeLayout("week", isoWeekFormat)
+	obtained := []R{}
+	if assert.Nil(t, decoder.Decode(&obtained)) && assert.Len(t, obtained, 2) {
+		assert.Equal(t, time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC), obtained[0].Created)
+		assert.Equal(t, time.Date(2024, time.January, 31, 0, 0, 0, 0, time.UTC), *obtained[0].Week)
+		assert.Equal(t, time.Date(2023, time.December, 31, 0, 0, 0, 0, time.UTC), obtained[0].Literal)
+		assert.Equal(t, time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC), *obtained[1].Week)
+	}
+
+	// The layouts are part of the configuration of the decoder.
+	copied := NewDecoderFromConfig(strings.NewReader(source), decoder.Config())
+	obtained = []R{}
+	assert.Nil(t, copied.Decode(&obtained))
+	assert.Len(t, obtained, 2)
+
+	// A layout which has not been registered is an error before any record is decoded.
+	decoder = NewDecoder(strings.NewReader(source))
+	decoder.RegisterTimeLayout("iso", "2006-01-02")
+	obtained = []R{}
+	err := decoder.Decode(&obtained)
+	if assert.IsType(t, &InvalidTagError{}, err) {
+		assert.Equal(t, "Week", err.(*InvalidTagError).Field.Name)
+	}
+	assert.Empty(t, obtained)
+}
+
+func TestUnmarshalSingleRecord(t *testing.T) {
+
+	type S struct {
+		Name string
+		Code int
+	}
+
+	source := []byte("Name  Code\nPeter 1   \nNicki 2   \nJohn  3   ")
+
+	first := S{}
+	assert.Nil(t, Unmarshal(source, &first))
+	assert.Equal(t, S{Name: "Peter", Code: 1}, first)
+
+	again := S{}
+	assert.Nil(t, Unmarshal(source, &again))
+	assert.Equal(t, first, again)
+
+	decoder := NewDecoder(bytes.NewReader(source))
+	single := S{}
+	assert.Nil(t, decoder.Decode(&single))
+	assert.Equal(t, first, single)
+
+	rest := []S{}
+	assert.Nil(t, decoder.Decode(&rest))
+	assert.Equal(t, []S{{Name: "Nicki", Code: 2}, {Name: "John", Code: 3}}, rest)
+	assert.Equal(t, "Name  Code\nPeter 1   \nNicki 2   \nJohn  3   ", string(source))
+}
+
+func TestWidthFrom(t *testing.T) {
+
+	type CDR struct {
+		Kind   string
+		Length uint8  `column:"Len"`
+		Data   string `widthFrom:"Length"`
+	}
+
+	source := "Kind Len Data      \nA    5   hello\nB    10  0123456789\nC    0   "
+
+	t.Run("dynamic", func(t *testing.T) {
+		obtained := []CDR{}
+		decoder := NewDecoder(strings.NewReader(source))
+		decoder.SkipLengthCheck = true
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, []CDR{
+			{Kind: "A", Length: 5, Data: "hello"},
+			{Kind: "B", Length: 10, Data: "0123456789"},
+			{Kind: "C", Length: 0, Data: ""},
+		}, obtained)
+	})
+
+	t.Run("too long", func(t *testing.T) {
+		obtained := []CDR{}
+		decoder := NewDecoder(strings.NewReader("Kind Len Data \nA    9   hello"))
+		err := decoder.Decode(&obtained)
+		if assert.IsType(t, &DynamicWidthError{}, err) {
+			assert.Equal(t, int64(9), err.(*DynamicWidthError).Width)
+			assert.Equal(t, 5, err.(*DynamicWidthError).Available)
+		}
+	})
+
+	t.Run("negative", func(t *testing.T) {
+		type N struct {
+			Length int    `column:"Len"`
+			Data   string `widthFrom:"Length"`
+		}
+		obtained := []N{}
+		err := Unmarshal([]byte("Len Data\n-1  abcd"), &obtained)
+		assert.IsType(t, &DynamicWidthError{}, err)
+	})
+
+	t.Run("order", func(t *testing.T) {
+		type O struct {
+			Data   string `widthFrom:"Length"`
+			Length int    `column:"Len"`
+		}
+		obtained := []O{}
+		err := Unmarshal([]byte("Data Len\nabcd 4  "), &obtained)
+		assert.IsType(t, &InvalidTagError{}, err)
+	})
+
+	t.Run("not integer", func(t *testing.T) {
+		type I struct {
+			Kind string
+			Data string `widthFrom:"Kind"`
+		}
+		obtained := []I{}
+		err := Unmarshal([]byte("Kind Data\nA    abcd"), &obtained)
+		assert.IsType(t, &InvalidTagError{}, err)
+	})
+}
+
+func TestDecodeHeaderOnly(t *testing.T) {
+
+	type S struct {
+		Name string
+		Code int
+	}
+
+	t.Run("parsed", func(t *testing.T) {
+		decoder := NewDecoder(strings.NewReader("Name  Code\nPeter 1   \nNicki 2   "))
+		headers, err := decoder.DecodeHeaderOnly()
+		assert.Nil(t, err)
+		assert.Equal(t, map[string][]int{"Name": {0, 6}, "Code": {6, 10}}, headers)
+
+		headers["Name"][1] = 3
+		again, err := decoder.DecodeHeaderOnly()
+		assert.Nil(t, err)
+		assert.Equal(t, []int{0, 6}, again["Name"])
+
+		obtained := []S{}
+		assert.Nil(t, decoder.Decode(&obtained))
+		assert.Equal(t, []S{{Name: "Peter", Code: 1}, {Name: "Nicki", Code: 2}}, obtained)
+	})
+
+	t.Run("explicit", func(t *testing.T) {
+		decoder := NewDecoder(strings.NewReader("Peter 1   "))
+		decoder.SetHeaders(map[string][]int{"Name": {0, 6}, "Code": {6, 10}})
+		headers, err := decoder.DecodeHeaderOnly()
+		assert.Nil(t, err)
+		assert.Equal(t, map[string][]int{"Name": {0, 6}, "Code": {6, 10}}, headers)
+
+		obtained := []S{}
+		assert.Nil(t, decoder.Decode(&obtained))
+		assert.Equal(t, []S{{Name: "Peter", Code: 1}}, obtained)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		decoder := NewDecoder(strings.NewReader(""))
+		headers, err := decoder.DecodeHeaderOnly()
+		assert.Equal(t, io.EOF, err)
+		assert.Nil(t, headers)
+	})
+}
+
+func TestDuplicateHeaders(t *testing.T) {
+
+	type A struct {
+		Name     string
+		Amount   int `column:"amount"`
+		Amount2  int `column:"amount_2"`
+		Currency string
+	}
+
+	source := "Name  amount amount Currency\nPeter 10     20     GBP     "
+
+	tests := []struct {
+		policy   DuplicateHeaderPolicy
+		expected A
+	}{
+		{policy: DuplicateHeaderLast, expected: A{Name: "Peter", Amount: 20, Currency: "GBP"}},
+		{policy: DuplicateHeaderFirst, expected: A{Name: "Peter", Amount: 10, Currency: "GBP"}},
+		{policy: DuplicateHeaderSuffix, expected: A{Name: "Peter", Amount: 10, Amount2: 20, Currency: "GBP"}},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.policy), func(t *testing.T) {
+			obtained := []A{}
+			decoder := NewDecoder(strings.NewReader(source))
+			decoder.OnDuplicateHeader = test.policy
+			err := decoder.Decode(&obtained)
+			assert.Nil(t, err)
+			assert.Equal(t, []A{test.expected}, obtained)
+		})
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		obtained := []A{}
+		decoder := NewDecoder(strings.NewReader(source))
+		decoder.OnDuplicateHeader = DuplicateHeaderFail
+		err := decoder.Decode(&obtained)
+		if assert.IsType(t, &DuplicateHeaderError{}, err) {
+			assert.Equal(t, "amount", err.(*DuplicateHeaderError).Header)
+		}
+		assert.Empty(t, obtained)
+	})
+
+	t.Run("suffix taken", func(t *testing.T) {
+		decoder := NewDecoder(strings.NewReader("a   a_2 a   \n1   2   3   "))
+		decoder.OnDuplicateHeader = DuplicateHeaderSuffix
+		headers, err := decoder.DecodeHeaderOnly()
+		assert.Nil(t, err)
+		assert.Equal(t, map[string][]int{"a": {0, 4}, "a_2": {4, 8}, "a_3": {8, 12}}, headers)
+	})
+}
+
+func TestStrictPad(t *testing.T) {
+
+	type S struct {
+		Name   string
+		Amount int      `strictPad:"true"`
+		Rate   *float64 `strictPad:"true"`
+		Loose  int      `strictPad:"false"`
+	}
+
+	header := "Name  Amount Rate  Loose\n"
+
+	t.Run("valid", func(t *testing.T) {
+		obtained := []S{}
+		err := Unmarshal([]byte(header+"Peter    1234  1.5 12   "), &obtained)
+		assert.Nil(t, err)
+		if assert.Len(t, obtained, 1) {
+			assert.Equal(t, 1234, obtained[0].Amount)
+			assert.Equal(t, 1.5, *obtained[0].Rate)
+		}
+	})
+
+	t.Run("interior padding", func(t *testing.T) {
+		obtained := []S{}
+		err := Unmarshal([]byte(header+"Peter 12 34    1.5 12   "), &obtained)
+		if assert.IsType(t, &CastingError{}, err) {
+			assert.Equal(t, "Amount", err.(*CastingError).Field.Name)
+			assert.Contains(t, err.Error(), "padding")
+		}
+	})
+
+	t.Run("pointer", func(t *testing.T) {
+		obtained := []S{}
+		err := Unmarshal([]byte(header+"Peter 1234   1 5   12   "), &obtained)
+		if assert.IsType(t, &CastingError{}, err) {
+			assert.Equal(t, "Rate", err.(*CastingError).Field.Name)
+		}
+	})
+
+	t.Run("string", func(t *testing.T) {
+		type N struct {
+			Name string `strictPad:"true"`
+		}
+		obtained := []N{}
+		err := Unmarshal([]byte("Name \nPeter"), &obtained)
+		assert.IsType(t, &InvalidTagError{}, err)
+	})
+}
+
+func TestKeepOne(t *testing.T) {
+
+	type K struct {
+		Left  string `keepOne:"left"`
+		Right string `keepOne:"right"`
+		Both  string `keepOne:"both"`
+		Plain string
+	}
+
+	source := "Left       Right      Both       Plain  \n   O'Brien Smith      a b        x      \nJones      Jones      Jones      Jones  "
+	obtained := []K{}
+	err := Unmarshal([]byte(source), &obtained)
+	assert.Nil(t, err)
+	assert.Equal(t, []K{
+		{Left: " O'Brien", Right: "Smith ", Both: "a b ", Plain: "x"},
+		{Left: "Jones", Right: "Jones ", Both: "Jones ", Plain: "Jones"},
+	}, obtained)
+
+	type I struct {
+		Name string `keepOne:"middle"`
+	}
+	invalid := []I{}
+	err = Unmarshal([]byte("Name \nPeter"), &invalid)
+	assert.IsType(t, &InvalidTagError{}, err)
+}
+
+func TestSetterPoolNoAliasing(t *testing.T) {
+
+	type A struct {
+		Name string
+		Code int
+	}
+	type B struct {
+		Code int
+		Name string `column:"Other"`
+	}
+
+	headers := map[string][]int{"Name": {0, 6}, "Code": {6, 10}, "Other": {10, 14}}
+	config := setterConfig{headers: headers, fieldSeparator: " "}
+	line := "Peter 1   Bob "
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			setter, err := createStructSetter(reflect.TypeOf(A{}), config)
+			assert.Nil(t, err)
+			_, _ = createStructSetter(reflect.TypeOf(B{}), config)
+			a := A{}
+			assert.Nil(t, setter(reflect.ValueOf(&a).Elem(), line))
+			assert.Equal(t, A{Name: "Peter", Code: 1}, a)
+		}()
+		go func() {
+			defer wg.Done()
+			setter, err := createStructSetter(reflect.TypeOf(B{}), config)
+			assert.Nil(t, err)
+			_, _ = createStructSetter(reflect.TypeOf(A{}), config)
+			b := B{}
+			assert.Nil(t, setter(reflect.ValueOf(&b).Elem(), line))
+			assert.Equal(t, B{Name: "Bob", Code: 1}, b)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestLabelledValues(t *testing.T) {
+
+	type L struct {
+		ID    int
+		Name  string `column:"Info" label:"NM"`
+		Born  *int   `column:"Info" label:"DOB"`
+		Grade string `column:"Info" label:"GR" labelSep:"="`
+	}
+
+	source := "ID Info                  \n1  NM:Smith DOB:1990 GR=A\n2  XNM:Jones XDOB:1 GR=  \n3                        "
+	obtained := []L{}
+	err := Unmarshal([]byte(source), &obtained)
+	assert.Nil(t, err)
+	born := 1990
+	assert.Equal(t, []L{
+		{ID: 1, Name: "Smith", Born: &born, Grade: "A"},
+		{ID: 2},
+		{ID: 3},
+	}, obtained)
+}
+
+func TestOverflowBounds(t *testing.T) {
+
+	tests := []struct {
+		data  string
+		kind  reflect.Kind
+		limit interface{}
+		msg   string
+	}{
+		{data: "Uint8\n5123 ", kind: reflect.Uint8, limit: uint64(255), msg: `value 5123 is too big for field Uint8:uint8 (max 255)`},
+		{data: "Int8\n5123", kind: reflect.Int8, limit: int64(127), msg: `value 5123 is too big for field Int8:int8 (max 127)`},
+		{data: "Int8\n-200", kind: reflect.Int8, limit: int64(-128), msg: `value -200 is too big for field Int8:int8 (min -128)`},
+		{data: "PUint8\n300   ", kind: reflect.Uint8, limit: uint64(255), msg: `(max 255)`},
+		{data: "PInt8 \n-129  ", kind: reflect.Int8, limit: int64(-128), msg: `(min -128)`},
+		{data: "Float32\n1e39   ", kind: reflect.Float32, limit: float64(math.MaxFloat32), msg: `(max 3.4028234663852886e+38)`},
+		{data: "PFloat32\n-1e39   ", kind: reflect.Float32, limit: -float64(math.MaxFloat32), msg: `(min -3.4028234663852886e+38)`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.data, func(t *testing.T) {
+			var obtained []TestStruct
+			err := Unmarshal([]byte(test.data), &obtained)
+			if assert.IsType(t, &OverflowError{}, err) {
+				assert.Equal(t, test.kind, err.(*OverflowError).Kind)
+				assert.Equal(t, test.limit, err.(*OverflowError).Limit)
+				assert.Contains(t, err.Error(), test.msg)
+			}
+		})
+	}
+}
+
+func TestIndexTag(t *testing.T) {
+
+	type P struct {
+		User string `index:"0"`
+		PID  int    `index:"1"`
+		Cmd  string `index:"3"`
+		Rest string `index:"7"`
+	}
+
+	t.Run("no header", func(t *testing.T) {
+		obtained := []P{}
+		decoder := NewDecoder(strings.NewReader("root 1 0.0 /sbin/init\nwww  42   1.5   nginx"))
+		decoder.Delimited = true
+		decoder.FieldSeparator = `\s+`
+		decoder.SkipLengthCheck = true
+		decoder.SetHeaders(map[string][]int{})
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, []P{{User: "root", PID: 1, Cmd: "/sbin/init"}, {User: "www", PID: 42, Cmd: "nginx"}}, obtained)
+	})
+
+	t.Run("header ignored", func(t *testing.T) {
+		type H struct {
+			Name string `column:"Cmd" index:"0"`
+		}
+		obtained := []H{}
+		decoder := NewDecoder(strings.NewReader("USER,CMD\nroot,init"))
+		decoder.Delimited = true
+		decoder.FieldSeparator = ","
+		err := decoder.Decode(&obtained)
+		assert.Nil(t, err)
+		assert.Equal(t, []H{{Name: "root"}}, obtained)
+	})
+
+	t.Run("positional", func(t *testing.T) {
+		obtained := []P{}
+		err := Unmarshal([]byte("User\nroot"), &obtained)
+		assert.IsType(t, &InvalidTagError{}, err)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		type N struct {
+			Name string `index:"-1"`
+		}
+		obtained := []N{}
+		decoder := NewDecoder(strings.NewReader("a,b\n1,2"))
+		decoder.Delimited = true
+		decoder.FieldSeparator = ","
+		err := decoder.Decode(&obtained)
+		assert.IsType(t, &InvalidTagError{}, err)
+	})
+}
+
+func TestFlagBool(t *testing.T) {
+
+	type F struct {
+		Name    string
+		Active  bool  `flag:"X"`
+		Checked *bool `column:"Chk" flag:"X"`
+		Any     bool  `flag:""`
+	}
+
+	yes := true
+	source := "Name  Active Chk Any\nPeter X      X   *  \nNicki               "
+	obtained := []F{}
+	err := Unmarshal([]byte(source), &obtained)
+	assert.Nil(t, err)
+	assert.Equal(t, []F{
+		{Name: "Peter", Active: true, Checked: &yes, Any: true},
+		{Name: "Nicki"},
+	}, obtained)
+
+	obtained = []F{}
+	err = Unmarshal([]byte("Name  Active Chk Any\nPeter Y      X   *  "), &obtained)
+	if assert.IsType(t, &CastingError{}, err) {
+		assert.Equal(t, "Active", err.(*CastingError).Field.Name)
+		assert.Contains(t, err.Error(), `expected "X" or blank`)
+	}
+}
+
+func TestIntegerBool(t *testing.T) {
+
+	type B struct {
+		Name    string
+		Flag    bool
+		Pointer *bool
+	}
+
+	yes, no := true, false
+	tests := []struct {
+		value    string
+		expected B
+	}{
+		{value: "0   ", expected: B{Name: "A", Flag: false, Pointer: &no}},
+		{value: "1   ", expected: B{Name: "A", Flag: true, Pointer: &yes}},
+		{value: "   1", expected: B{Name: "A", Flag: true, Pointer: &yes}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			source := "Name Flag Pointer\nA    " + test.value + " " + test.value + "   "
+			obtained := B{}
+			assert.Nil(t, Unmarshal([]byte(source), &obtained))
+			assert.Equal(t, test.expected, obtained)
+		})
+	}
+
+	// strconv.ParseBool only accepts a single digit.
+	obtained := B{}
+	err := Unmarshal([]byte("Name Flag Pointer\nA    01   1      "), &obtained)
+	if assert.IsType(t, &CastingError{}, err) {
+		assert.Equal(t, "Flag", err.(*CastingError).Field.Name)
+		assert.Equal(t, "01", err.(*CastingError).Value)
+	}
+
+	// A blank value is an error, as it is for other numeric fields, unless there's a default.
+	obtained = B{}
+	err = Unmarshal([]byte("Name Flag Pointer\nA         1      "), &obtained)
+	if assert.IsType(t, &CastingError{}, err) {
+		assert.Equal(t, "Flag", err.(*CastingError).Field.Name)
+		assert.Equal(t, "", err.(*CastingError).Value)
+	}
+
+	type D struct {
+		Flag    bool  `default:"0"`
+		Pointer *bool `default:"0"`
+	}
+	defaulted := D{}
+	assert.Nil(t, Unmarshal([]byte("Flag Pointer\n            "), &defaulted))
+	assert.Equal(t, D{Flag: false, Pointer: &no}, defaulted)
+
+	// Values are trimmed of FieldSeparator.
+	obtained = B{}
+	source := "Name_Flag_Pointer\nA____1____0______"
+	decoder := NewDecoder(strings.NewReader(source))
+	decoder.FieldSeparator = "_"
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, B{Name: "A", Flag: true, Pointer: &no}, obtained)
+}
+
+func TestFilter(t *testing.T) {
+
+	type R struct {
+		ID     int
+		Status string
+	}
+
+	source := "ID  Status\n1   open  \n2   closed\nbad\n3   open  \nTOTAL 3   "
+	decoder := NewDecoder(strings.NewReader(source))
+	decoder.Filter = func(line string) bool {
+		return len(line) > 4 && strings.HasPrefix(line[4:], "open")
+	}
+	decoder.TrailerPredicate = func(line string) bool {
+		return strings.HasPrefix(line, "TOTAL")
+	}
+	obtained := []R{}
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, []R{{ID: 1, Status: "open"}, {ID: 3, Status: "open"}}, obtained)
+	assert.Equal(t, int64(2), decoder.Stats().Records)
+	assert.Equal(t, int64(2), decoder.Stats().Skipped)
+
+	// Records which are filtered out don't count towards the limit of DecodeN.
+	decoder = NewDecoder(strings.NewReader("ID  Status\n1   closed\n2   open  \n3   closed\n4   closed\n5   open  \n6   open  \n"))
+	decoder.Filter = func(line string) bool {
+		return strings.HasSuffix(line, "open  ")
+	}
+	obtained = []R{}
+	assert.Nil(t, decoder.DecodeN(&obtained, 2))
+	assert.Equal(t, []R{{ID: 2, Status: "open"}, {ID: 5, Status: "open"}}, obtained)
+	assert.Nil(t, decoder.DecodeN(&obtained, 2))
+	assert.Equal(t, []R{{ID: 2, Status: "open"}, {ID: 5, Status: "open"}, {ID: 6, Status: "open"}}, obtained)
+	assert.Equal(t, io.EOF, decoder.DecodeN(&obtained, 2))
+	assert.Equal(t, int64(3), decoder.Stats().Skipped)
+}
+
+func TestCastingErrorOffsets(t *testing.T) {
+
+	type R struct {
+		Name  string
+		Count int
+		Rest  int `from:"12"`
+	}
+
+	obtained := []R{}
+	err := Unmarshal([]byte("Name  Count\nPeter 1x   "), &obtained)
+	if assert.IsType(t, &CastingError{}, err) {
+		castingErr := err.(*CastingError)
+		assert.Equal(t, "1x", castingErr.Value)
+		assert.Equal(t, "1x   ", castingErr.Raw)
+		assert.Equal(t, 6, castingErr.From)
+		assert.Equal(t, 11, castingErr.To)
+	}
+
+	// A column which runs to the end of the record is limited to its length.
+	obtained = []R{}
+	decoder := NewDecoder(strings.NewReader("Name  Count\nPeter 1     9z"))
+	decoder.SkipLengthCheck = true
+	err = decoder.Decode(&obtained)
+	if assert.IsType(t, &CastingError{}, err) {
+		castingErr := err.(*CastingError)
+		assert.Equal(t, "Rest", castingErr.Field.Name)
+		assert.Equal(t, "9z", castingErr.Raw)
+		assert.Equal(t, 12, castingErr.From)
+		assert.Equal(t, 14, castingErr.To)
+	}
+}
+
+func TestFortranFloat(t *testing.T) {
+
+	type F struct {
+		Value   float64  `float:"fortran"`
+		Pointer *float32 `float:"fortran"`
+	}
+
+	tests := []struct {
+		value    string
+		expected float64
+	}{
+		{value: "1.5D3", expected: 1500},
+		{value: "-2.0d-4", expected: -2e-4},
+		{value: "1.5E3", expected: 1500},
+		{value: "12.25", expected: 12.25},
+		{value: "-7", expected: -7},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			source := fmt.Sprintf("Value    Pointer \n%-8s %-8s", test.value, test.value)
+			obtained := F{}
+			assert.Nil(t, Unmarshal([]byte(source), &obtained))
+			assert.Equal(t, test.expected, obtained.Value)
+			if assert.NotNil(t, obtained.Pointer) {
+				assert.Equal(t, float32(test.expected), *obtained.Pointer)
+			}
+		})
+	}
+
+	// The exponent is replaced after a currency symbol is removed.
+	obtained := F{}
+	decoder := NewDecoder(strings.NewReader("Value    Pointer \n$ 1.5D3  2.5d0£  "))
+	decoder.StripCurrency = true
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, 1500.0, obtained.Value)
+	assert.Equal(t, float32(2.5), *obtained.Pointer)
+
+	// Without the annotation a D exponent is an error.
+	type P struct {
+		Value float64
+	}
+	plain := P{}
+	assert.IsType(t, &CastingError{}, Unmarshal([]byte("Value\n1.5D3"), &plain))
+
+	type B struct {
+		Count int `float:"fortran"`
+	}
+	assert.IsType(t, &InvalidTagError{}, Unmarshal([]byte("Count\n1    "), &B{}))
+
+	type U struct {
+		Value float64 `float:"fixed"`
+	}
+	assert.IsType(t, &InvalidTagError{}, Unmarshal([]byte("Value\n1    "), &U{}))
+}
+
+func TestTrimGreedy(t *testing.T) {
+
+	type R struct {
+		Code string `pad:"0"`
+		Name string `pad:"*" keepOne:"right"`
+	}
+
+	source := "Code  Name    \n  007 Bond****\n00007 *Bond   \n00000 Q       "
+
+	obtained := []R{}
+	assert.Nil(t, Unmarshal([]byte(source), &obtained))
+	assert.Equal(t, []R{{Code: "7", Name: "Bond*"}, {Code: "7", Name: "Bond "}, {Code: "0", Name: "Q "}}, obtained)
+
+	// Only the run of the character found at each end is removed, so zeros inside the spaces are kept.
+	obtained = []R{}
+	decoder := NewDecoder(strings.NewReader(source))
+	decoder.TrimGreedy = false
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, []R{{Code: "007", Name: "Bond*"}, {Code: "7", Name: "Bond "}, {Code: "0", Name: "Q "}}, obtained)
+}
+
+func TestSetWidths(t *testing.T) {
+
+	type R struct {
+		Name string
+		DOB  string
+		Code int
+	}
+
+	decoder := NewDecoder(strings.NewReader("Peter     2008-10-11  12\nNicki     1987-01-28  7 "))
+	assert.Nil(t, decoder.SetWidths([]string{"Name", "DOB", "", "Code"}, []int{10, 10, 2, 2}))
+	headers, err := decoder.DecodeHeaderOnly()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]int{"Name": {0, 10}, "DOB": {10, 20}, "Code": {22, 24}}, headers)
+
+	obtained := []R{}
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, []R{{"Peter", "2008-10-11", 12}, {"Nicki", "1987-01-28", 7}}, obtained)
+
+	decoder = NewDecoder(strings.NewReader(""))
+	err = decoder.SetWidths([]string{"Name", "DOB"}, []int{10})
+	assert.Equal(t, &InvalidWidthError{Column: "DOB", Missing: true}, err)
+	assert.EqualError(t, err, `column "DOB" has no width`)
+	err = decoder.SetWidths([]string{"Name"}, []int{10, 8})
+	assert.Equal(t, &InvalidWidthError{Width: 8, Missing: true}, err)
+	assert.EqualError(t, err, "width 8 has no column name")
+	err = decoder.SetWidths([]string{"Name", "DOB"}, []int{10, -1})
+	assert.Equal(t, &InvalidWidthError{Column: "DOB", Width: -1}, err)
+	assert.EqualError(t, err, `column "DOB" has a negative width -1`)
+	err = decoder.SetWidths([]string{"Name", "Name"}, []int{10, 10})
+	assert.Equal(t, &InvalidWidthError{Column: "Name", Width: 10, Duplicate: true}, err)
+	assert.EqualError(t, err, `column "Name" is given more than once`)
+	assert.Nil(t, decoder.Config().Headers)
+}
+
+func TestOptionalColumns(t *testing.T) {
+
+	type R struct {
+		Name  string
+		Code  int
+		Email *string `optional:"true"`
+		Score int     `optional:"true"`
+	}
+
+	email := "p@x.com"
+	source := "Name  Code Email    Score\nPeter 1    p@x.com  9    \nPaul  2    \nNicki 3    n@x"
+	obtained := []R{}
+	assert.Nil(t, Unmarshal([]byte(source), &obtained))
+	nicki := "n@x"
+	assert.Equal(t, []R{{"Peter", 1, &email, 9}, {"Paul", 2, nil, 0}, {"Nicki", 3, &nicki, 0}}, obtained)
+
+	// Records must still reach the first optional column.
+	obtained = []R{}
+	err := Unmarshal([]byte("Name  Code Email    Score\nPaul  2"), &obtained)
+	assert.IsType(t, &InvalidLengthError{}, err)
+
+	// Records are read one at a time in the same way.
+	decoder := NewDecoder(strings.NewReader(source))
+	record := R{}
+	for _, expected := range []R{{"Peter", 1, &email, 9}, {"Paul", 2, nil, 0}} {
+		record = R{}
+		assert.Nil(t, decoder.Decode(&record))
+		assert.Equal(t, expected, record)
+	}
+
+	// Columns which are not optional can't follow an optional column.
+	type B struct {
+		Name  string `optional:"true"`
+		Score int
+	}
+	err = Unmarshal([]byte("Name  Score\nPeter 9    "), &[]B{})
+	assert.Equal(t, `column "Score" of field "Score" is not optional but ends after optional column "Name" starts`, err.Error())
+
+	type T struct {
+		Name string `optional:"maybe"`
+	}
+	assert.IsType(t, &InvalidTagError{}, Unmarshal([]byte("Name\nPete"), &[]T{}))
+}
+
+func TestMarkers(t *testing.T) {
+
+	type R struct {
+		Name string
+		Code int
+	}
+
+	source := "Report\nBEGIN\nName  Code\nPeter 1   \nPaul  x   \nEND\nTotal 2\n"
+
+	decoder := NewDecoder(strings.NewReader(source))
+	decoder.StartMarker = []byte("BEGIN")
+	decoder.EndMarker = []byte("END")
+	obtained := []R{}
+	err := decoder.Decode(&obtained)
+	if assert.IsType(t, &CastingError{}, err) {
+		// Line numbers count the lines before the start marker.
+		assert.Equal(t, 5, decoder.lineNum)
+	}
+
+	source = strings.Replace(source, "x   ", "2   ", 1)
+	decoder = NewDecoder(strings.NewReader(source))
+	decoder.StartMarker = []byte("BEGIN")
+	decoder.EndMarker = []byte("END")
+	record := R{}
+	assert.Nil(t, decoder.Decode(&record))
+	assert.Nil(t, decoder.Decode(&record))
+	assert.Equal(t, R{"Paul", 2}, record)
+	assert.Equal(t, io.EOF, decoder.Decode(&record))
+
+	// Without an end marker the data runs to the end of the input.
+	obtained = []R{}
+	decoder = NewDecoder(strings.NewReader("BEGIN\nName  Code\nPeter 1   \n"))
+	decoder.StartMarker = []byte("BEGIN")
+	decoder.EndMarker = []byte("END")
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, []R{{"Peter", 1}}, obtained)
+
+	// Without the start marker there is no data.
+	decoder = NewDecoder(strings.NewReader("Name  Code\nPeter 1   \n"))
+	decoder.StartMarker = []byte("BEGIN")
+	assert.Equal(t, io.EOF, decoder.Decode(&record))
+}
+
+func TestNextSection(t *testing.T) {
+
+	type Customer struct {
+		Name string
+		Code int
+	}
+	type Order struct {
+		ID     int
+		Amount float64
+	}
+
+	source := "Consolidated report\n" +
+		"TABLE customers\nName  Code\nPeter 1   \nPaul  2   \n" +
+		"TABLE orders\nID  Amount\n10  2.5   \nEND\nnotes\n" +
+		"TABLE\nName  Code\nNicki x   \n"
+
+	decoder := NewDecoder(strings.NewReader(source))
+	decoder.StartMarker = []byte("TABLE")
+	decoder.EndMarker = []byte("END")
+
+	name, err := decoder.NextSection()
+	assert.Nil(t, err)
+	assert.Equal(t, "customers", name)
+	customer := Customer{}
+	assert.Nil(t, decoder.Decode(&customer))
+	assert.Equal(t, Customer{"Peter", 1}, customer)
+
+	// The rest of the section is discarded.
+	name, err = decoder.NextSection()
+	assert.Nil(t, err)
+	assert.Equal(t, "orders", name)
+	orders := []Order{}
+	assert.Nil(t, decoder.Decode(&orders))
+	assert.Equal(t, []Order{{10, 2.5}}, orders)
+	assert.Equal(t, io.EOF, decoder.Decode(&orders))
+
+	name, err = decoder.NextSection()
+	assert.Nil(t, err)
+	assert.Equal(t, "", name)
+	customers := []Customer{}
+	err = decoder.Decode(&customers)
+	assert.IsType(t, &CastingError{}, err)
+	assert.Equal(t, 13, decoder.lineNum)
+
+	name, err = decoder.NextSection()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "", name)
+
+	_, err = NewDecoder(strings.NewReader(source)).NextSection()
+	assert.Equal(t, ErrNoStartMarker, err)
+}
+
+func TestRuled(t *testing.T) {
+
+	type Person struct {
+		Name    string `column:"Full Name"`
+		Age     int
+		Country string
+	}
+	expected := []Person{{"Peter Smith", 42, "UK"}, {"Al", 7, ""}}
+
+	boxed := "+-------------+-----+---------+\n" +
+		"| Full Name   | Age | Country |\n" +
+		"+=============+=====+=========+\n" +
+		"| Peter Smith |  42 | UK      |\n" +
+		"| Al          |   7 |         |\n" +
+		"+-------------+-----+---------+\n"
+
+	decoder := NewDecoder(strings.NewReader(boxed))
+	decoder.Ruled = true
+	people := []Person{}
+	assert.Nil(t, decoder.Decode(&people))
+	assert.Equal(t, expected, people)
+	assert.Equal(t, map[string][]int{"Full Name": {1, 14}, "Age": {15, 20}, "Country": {21, 30}}, decoder.headers)
+	assert.Equal(t, int64(2), decoder.Stats().Records)
+
+	// The ruler can follow the header line, as in the output of psql.
+	underlined := " Full Name   | Age | Country\n" +
+		"-------------+-----+--------\n" +
+		" Peter Smith |  42 | UK     \n" +
+		" Al          |   7 |        \n"
+	decoder = NewDecoder(strings.NewReader(underlined))
+	decoder.Ruled = true
+	decoder.ColumnDelimiter = '|'
+	people = []Person{}
+	assert.Nil(t, decoder.Decode(&people))
+	assert.Equal(t, expected, people)
+
+	decoder = NewDecoder(strings.NewReader("Name Age\nPeter 42\n"))
+	decoder.Ruled = true
+	err := decoder.Decode(&people)
+	assert.Equal(t, &MissingRulerError{LineNum: 2}, err)
+
+	// Ruled and Delimited can't both be set.
+	decoder = NewDecoder(strings.NewReader(boxed))
+	decoder.Ruled = true
+	decoder.Delimited = true
+	assert.Equal(t, ErrRuledDelimited, decoder.Decode(&people))
+
+	// A HeaderSeparator which isn't a valid expression is an error rather than a panic.
+	decoder = NewDecoder(strings.NewReader(boxed))
+	decoder.Ruled = true
+	decoder.HeaderSeparator = "("
+	assert.NotNil(t, decoder.Decode(&people))
+}
+
+func TestRecordReader(t *testing.T) {
+
+	type R struct {
+		ID   int
+		Name string
+	}
+
+	data := []byte("0001Peter 0002Nicki 0003John  00")
+	rr := NewRecordReader(bytes.NewReader(data), 10)
+
+	record := R{}
+	assert.Equal(t, ErrNoHeaders, rr.ReadRecord(0, &record))
+
+	rr.SetHeaders(map[string][]int{"ID": {0, 4}, "Name": {4, 10}})
+
+	assert.Nil(t, rr.ReadRecord(2, &record))
+	assert.Equal(t, R{ID: 3, Name: "John"}, record)
+
+	assert.Nil(t, rr.ReadRecord(0, &record))
+	assert.Equal(t, R{ID: 1, Name: "Peter"}, record)
+
+	assert.Equal(t, io.ErrUnexpectedEOF, rr.ReadRecord(3, &record))
+	assert.Equal(t, io.EOF, rr.ReadRecord(4, &record))
+	assert.ErrorIs(t, rr.ReadRecord(-1, &record), ErrInvalidRecordNumber)
+	assert.IsType(t, &InvalidInputError{}, rr.ReadRecord(0, record))
+
+	// A record length which can't be used is an error for every record.
+	empty := NewRecordReader(bytes.NewReader(data), 0)
+	empty.SetHeaders(map[string][]int{"ID": {0, 4}, "Name": {4, 10}})
+	assert.Equal(t, ErrInvalidRecordLength, empty.ReadRecord(0, &record))
+	assert.Equal(t, ErrInvalidRecordLength, NewRecordReader(bytes.NewReader(data), -10).ReadRecord(1, &record))
+
+	// Offsets which can't be used are an error rather than a panic.
+	for _, offsets := range [][]int{{-1, 4}, {0}} {
+		rr.SetHeaders(map[string][]int{"ID": offsets, "Name": {4, 10}})
+		assert.Equal(t, &InvalidOffsetsError{Column: "ID", Offsets: offsets}, rr.ReadRecord(0, &record))
+	}
+}
+
+func TestFixedRecordLength(t *testing.T) {
+
+	type R struct {
+		ID   int
+		Name string
+	}
+
+	headers := map[string][]int{"ID": {0, 4}, "Name": {4, 10}}
+
+	tests := []struct {
+		name     string
+		source   string
+		mode     WidthMode
+		skip     bool
+		expected []R
+		err      string
+	}{
+		{name: "bytes", source: "0001Peter 0002Nicki ", mode: WidthBytes, expected: []R{{1, "Peter"}, {2, "Nicki"}}},
+		{name: "runes", source: "0001Zoë   0002Nicki ", expected: []R{{1, "Zoë"}, {2, "Nicki"}}},
+		{name: "terminator ignored", source: "0001Pe\nter0002Nicki ", expected: []R{{1, "Pe\nter"}, {2, "Nicki"}}},
+		{name: "partial", source: "0001Peter 0002Ni", err: "wrong data length in line 2"},
+		{name: "partial skip", source: "0001Peter 0002Ni", skip: true, expected: []R{{1, "Peter"}, {2, "Ni"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			obtained := []R{}
+			decoder := NewDecoder(strings.NewReader(test.source))
+			decoder.FixedRecordLength = 10
+			decoder.WidthMode = test.mode
+			decoder.SkipLengthCheck = test.skip
+			decoder.SetHeaders(headers)
+			err := decoder.Decode(&obtained)
+			if test.err != "" {
+				assert.NotNil(t, err)
+				assert.Contains(t, err.Error(), test.err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, test.expected, obtained)
+			}
+		})
+	}
+}
+
+func TestNormalizeForm(t *testing.T) {
+
+	type N struct {
+		Name  string `column:"Name"`
+		Place string `column:"café"`
+	}
+
+	// The header line uses a decomposed é and the values a composed one.
+	source := "Name  cafe\u0301\nPeter Caf\u00e9 \n"
+
+	obtained := []N{}
+	err := Unmarshal([]byte(source), &obtained)
+	assert.Nil(t, err)
+	assert.Equal(t, []N{{Name: "Peter"}}, obtained)
+
+	for _, form := range []NormalizeForm{NormalizeNFC, NormalizeNFD} {
+		obtained = []N{}
+		decoder := NewDecoder(strings.NewReader(source))
+		decoder.NormalizeForm = form
+		decoder.SkipLengthCheck = true
+		assert.Nil(t, decoder.Decode(&obtained))
+		assert.Equal(t, []N{{Name: "Peter", Place: "Café"}}, obtained)
+
+		obtained = []N{}
+		decoder = NewDecoder(strings.NewReader(source))
+		decoder.NormalizeForm = form
+		decoder.NormalizeValues = true
+		decoder.SkipLengthCheck = true
+		assert.Nil(t, decoder.Decode(&obtained))
+		assert.Equal(t, []N{{Name: "Peter", Place: form.string("Café")}}, obtained)
+	}
+}
+
+type unmarshalledRecord struct {
+	Name string `column:"Code"`
+	Code int
+}
+
+func (u *unmarshalledRecord) UnmarshalRecord(line string, headers map[string][]int) error {
+	name := headers["Name"]
+	u.Name = strings.ToUpper(strings.TrimSpace(line[name[0]:name[1]]))
+	if u.Name == "" {
+		return fmt.Errorf("no name")
+	}
+	u.Code = len(line)
+	return nil
+}
+
+func TestRecordUnmarshaler(t *testing.T) {
+
+	source := "Name  Code\nPeter 1   \nNicki 2   \n"
+
+	obtained := []unmarshalledRecord{}
+	assert.Nil(t, Unmarshal([]byte(source), &obtained))
+	assert.Equal(t, []unmarshalledRecord{{Name: "PETER", Code: 10}, {Name: "NICKI", Code: 10}}, obtained)
+
+	pointers := []*unmarshalledRecord{}
+	assert.Nil(t, Unmarshal([]byte(source), &pointers))
+	assert.Equal(t, &unmarshalledRecord{Name: "NICKI", Code: 10}, pointers[1])
+
+	single := unmarshalledRecord{}
+	decoder := NewDecoder(strings.NewReader(source))
+	assert.Nil(t, decoder.Decode(&single))
+	assert.Equal(t, unmarshalledRecord{Name: "PETER", Code: 10}, single)
+
+	err := Unmarshal([]byte("Name  Code\n      1   \n"), &obtained)
+	assert.EqualError(t, err, "no name")
+}
+
+func TestValidateLayout(t *testing.T) {
+
+	type Valid struct {
+		ID      int       `width:"4"`
+		Name    string    `width:"10"`
+		Joined  time.Time `width:"8" format:"20060102"`
+		Comment string
+	}
+
+	type BadWidth struct {
+		ID   int    `width:"4"`
+		Name string `width:"-2"`
+	}
+
+	type Overlap struct {
+		ID   int    `width:"4" column:"Key"`
+		Name string `width:"10" column:"Key"`
+	}
+
+	type BadType struct {
+		ID   int         `width:"4"`
+		Data chan string `width:"10"`
+	}
+
+	type BadTag struct {
+		Amount float64 `width:"6" numeric:"packed" scale:"x"`
+	}
+
+	assert.Nil(t, ValidateLayout(Valid{}))
+	assert.Nil(t, ValidateLayout(&Valid{}))
+
+	err := ValidateLayout(BadWidth{})
+	if assert.IsType(t, &InvalidTagError{}, err) {
+		assert.Equal(t, "Name", err.(*InvalidTagError).Field.Name)
+		assert.Equal(t, "width", err.(*InvalidTagError).Tag)
+	}
+
+	err = ValidateLayout(Overlap{})
+	if assert.IsType(t, &InvalidTagError{}, err) {
+		assert.Equal(t, "Name", err.(*InvalidTagError).Field.Name)
+	}
+
+	err = ValidateLayout(BadType{})
+	if assert.IsType(t, &InvalidTypeError{}, err) {
+		assert.Equal(t, "Data", err.(*InvalidTypeError).Field.Name)
+	}
+
+	assert.NotNil(t, ValidateLayout(BadTag{}))
+	assert.IsType(t, &InvalidInputError{}, ValidateLayout(struct{ Name string }{}))
+	assert.IsType(t, &InvalidInputError{}, ValidateLayout(nil))
+	assert.IsType(t, &InvalidInputError{}, ValidateLayout([]Valid{}))
+}
+
+func TestHeaderSeparatorAndPadding(t *testing.T) {
+
+	type R struct {
+		Name string
+		Code string
+	}
+
+	// The header line is padded with dashes but the values with spaces.
+	source := "Name--Code--\nPeter A-1   \n*Ann* B-2   \n"
+
+	obtained := []R{}
+	decoder := NewDecoder(strings.NewReader(source))
+	decoder.HeaderSeparator = "-"
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, []R{{Name: "Peter", Code: "A-1"}, {Name: "*Ann*", Code: "B-2"}}, obtained)
+
+	obtained = []R{}
+	decoder = NewDecoder(strings.NewReader(source))
+	decoder.HeaderSeparator = "-"
+	decoder.FieldPadding = `[ *]`
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, []R{{Name: "Peter", Code: "A-1"}, {Name: "Ann", Code: "B-2"}}, obtained)
+
+	// FieldSeparator alone sets both so the values lose their dashes too.
+	obtained = []R{}
+	decoder = NewDecoder(strings.NewReader("Name--Code--\nPeter-A-1---\n"))
+	decoder.FieldSeparator = "-"
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, []R{{Name: "Peter", Code: "A-1"}}, obtained)
+
+	type D struct {
+		Name string
+		Code int
+	}
+
+	delimited := []D{}
+	decoder = NewDecoder(strings.NewReader("Name;Code\n Peter ; 12\n"))
+	decoder.Delimited = true
+	decoder.HeaderSeparator = ";"
+	decoder.FieldPadding = `\.`
+	assert.Nil(t, decoder.Decode(&delimited))
+	assert.Equal(t, []D{{Name: "Peter", Code: 12}}, delimited)
+
+	// Padding which is not a valid regular expression is an error wherever the trimmers are built.
+	decoder = NewDecoder(strings.NewReader(source))
+	decoder.FieldPadding = `[ *`
+	assert.NotNil(t, decoder.Decode(&obtained))
+	_, err := decoder.setterConfig().fieldTrimmer()
+	assert.NotNil(t, err)
+	_, err = decoder.structSetter(reflect.TypeOf(R{}))
+	assert.NotNil(t, err)
+}
+
+func TestSlowReader(t *testing.T) {
+
+	type R struct {
+		Name string
+		City string
+		Code int
+	}
+
+	// Records use a multi-byte terminator and are padded with a multi-byte separator.
+	source := "Name··City···Code\r\nZoë···Köln···1···\r\nPeter·Zürich·22··\r\n"
+	expected := []R{{Name: "Zoë", City: "Köln", Code: 1}, {Name: "Peter", City: "Zürich", Code: 22}}
+
+	readers := map[string]func(io.Reader) io.Reader{
+		"one byte": iotest.OneByteReader,
+		"half":     iotest.HalfReader,
+		"data err": iotest.DataErrReader,
+	}
+
+	for name, wrap := range readers {
+		t.Run(name, func(t *testing.T) {
+			obtained := []R{}
+			decoder := NewDecoder(wrap(strings.NewReader(source)))
+			decoder.RecordTerminator = []byte("\r\n")
+			decoder.FieldSeparator = "·"
+			assert.Nil(t, decoder.Decode(&obtained))
+			assert.Equal(t, expected, obtained)
+			assert.Equal(t, int64(len(source)), decoder.Stats().Bytes)
+
+			decoder = NewDecoder(wrap(strings.NewReader(source)))
+			decoder.RecordTerminator = []byte("\r\n")
+			decoder.FieldSeparator = "·"
+			record := R{}
+			for _, want := range expected {
+				assert.Nil(t, decoder.Decode(&record))
+				assert.Equal(t, want, record)
+			}
+			assert.Equal(t, io.EOF, decoder.Decode(&record))
+
+			obtained = []R{}
+			decoder = NewDecoder(wrap(strings.NewReader(strings.ReplaceAll(source, "\r\n", ""))))
+			decoder.FixedRecordLength = 17
+			decoder.FieldSeparator = "·"
+			assert.Nil(t, decoder.Decode(&obtained))
+			assert.Equal(t, expected, obtained)
+		})
+	}
+}
+
+func TestDecodeN(t *testing.T) {
+
+	type R struct {
+		Name string
+		Code int
+	}
+
+	source := "Name  Code\nPeter 1   \nNicki 2   \nJohn  3   \nAnn   4   \nZoe   5   \n"
+	all := []R{{"Peter", 1}, {"Nicki", 2}, {"John", 3}, {"Ann", 4}, {"Zoe", 5}}
+
+	obtained := []R{}
+	decoder := NewDecoder(strings.NewReader(source))
+	assert.Nil(t, decoder.DecodeN(&obtained, 2))
+	assert.Equal(t, all[:2], obtained)
+	assert.Nil(t, decoder.DecodeN(&obtained, 0))
+	assert.Nil(t, decoder.DecodeN(&obtained, 2))
+	assert.Equal(t, all[:4], obtained)
+	assert.Nil(t, decoder.DecodeN(&obtained, 2))
+	assert.Equal(t, all, obtained)
+	assert.Equal(t, io.EOF, decoder.DecodeN(&obtained, 2))
+	assert.Equal(t, io.EOF, decoder.DecodeN(&obtained, 2))
+	assert.Equal(t, all, obtained)
+
+	// Batches can be taken into a fresh slice each time.
+	decoder = NewDecoder(strings.NewReader(source))
+	batches := [][]*R{}
+	for {
+		batch := []*R{}
+		if err := decoder.DecodeN(&batch, 3); err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		batches = append(batches, batch)
+	}
+	if assert.Len(t, batches, 2) {
+		assert.Len(t, batches[0], 3)
+		assert.Equal(t, &all[4], batches[1][1])
+	}
+
+	// Decode into a slice reads everything that remains.
+	obtained = []R{}
+	decoder = NewDecoder(strings.NewReader(source))
+	assert.Nil(t, decoder.DecodeN(&obtained, 1))
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, all, obtained)
+
+	record := R{}
+	assert.IsType(t, &InvalidInputError{}, NewDecoder(strings.NewReader(source)).DecodeN(&record, 1))
+	assert.IsType(t, &InvalidInputError{}, NewDecoder(strings.NewReader(source)).DecodeN(obtained, 1))
+	assert.IsType(t, &InvalidInputError{}, NewDecoder(strings.NewReader(source)).DecodeN(&obtained, -1))
+}
+
+func TestDetectMisalignment(t *testing.T) {
+
+	type R struct {
+		Name   string
+		Code   int
+		Amount float64
+	}
+
+	source := "Name  Code Amount\n" +
+		"Peter 1    2.50  \n" +
+		"Nicki 123455.00  \n" +
+		"Johnat12   1.00  \n"
+
+	warnings := []error{}
+	obtained := []R{}
+	decoder := NewDecoder(strings.NewReader(source))
+	decoder.DetectMisalignment = true
+	decoder.OnWarning = func(warning error) {
+		warnings = append(warnings, warning)
+	}
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Len(t, obtained, 3)
+
+	if assert.Len(t, warnings, 2) {
+		assert.Equal(t, &MisalignmentWarning{Column: "Code", Next: "Amount", Line: "Nicki 123455.00  ", LineNum: 3}, warnings[0])
+		assert.Equal(t, &MisalignmentWarning{Column: "Name", Next: "Code", Line: "Johnat12   1.00  ", LineNum: 4}, warnings[1])
+		assert.Contains(t, warnings[0].Error(), `line 3: column "Code" runs into column "Amount"`)
+	}
+
+	// Nothing is reported unless DetectMisalignment is set.
+	warnings = warnings[:0]
+	decoder = NewDecoder(strings.NewReader(source))
+	decoder.OnWarning = func(warning error) {
+		warnings = append(warnings, warning)
+	}
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Empty(t, warnings)
+
+	// The padding is taken again for each section, so a change to FieldPadding applies to the next one even
+	// when the headers are kept.
+	type C struct {
+		Name string
+		Code int
+	}
+	source = "# one\nName  Code\nPeter 1   \n# two\nName  Code\nPeter-1---\nJohnat12--\n"
+	warnings = warnings[:0]
+	decoder = NewDecoder(strings.NewReader(source))
+	decoder.StartMarker = []byte("#")
+	decoder.SetHeaders(map[string][]int{"Name": {0, 6}, "Code": {6, 10}})
+	decoder.SkipFirstRecord = true
+	decoder.DetectMisalignment = true
+	decoder.OnWarning = func(warning error) {
+		warnings = append(warnings, warning)
+	}
+	sections := []C{}
+	_, err := decoder.NextSection()
+	assert.Nil(t, err)
+	assert.Nil(t, decoder.Decode(&sections))
+	_, err = decoder.NextSection()
+	assert.Nil(t, err)
+	decoder.FieldPadding = "-"
+	assert.Nil(t, decoder.Decode(&sections))
+	assert.Equal(t, []C{{"Peter", 1}, {"Peter", 1}, {"Johnat", 12}}, sections)
+	if assert.Len(t, warnings, 1) {
+		assert.Equal(t, &MisalignmentWarning{Column: "Name", Next: "Code", Line: "Johnat12--", LineNum: 7}, warnings[0])
+	}
+}
+
+func TestDecodeToChannel(t *testing.T) {
+
+	type R struct {
+		Name string
+		Code int
+	}
+
+	source := "Name  Code\nPeter 1   \nNicki 2   \nJohn  3   \n"
+	expected := []R{{"Peter", 1}, {"Nicki", 2}, {"John", 3}}
+
+	ch := make(chan interface{})
+	errs := make(chan error, 1)
+	go func() {
+		errs <- NewDecoder(strings.NewReader(source)).DecodeToChannel(ch, R{})
+	}()
+	obtained := []R{}
+	for v := range ch {
+		obtained = append(obtained, v.(R))
+	}
+	assert.Nil(t, <-errs)
+	assert.Equal(t, expected, obtained)
+
+	pointers := make(chan interface{}, 10)
+	assert.Nil(t, NewDecoder(strings.NewReader(source)).DecodeToChannel(pointers, &R{}))
+	assert.Len(t, pointers, 3)
+	assert.Equal(t, &expected[0], <-pointers)
+
+	// An error stops decoding and closes the channel.
+	bad := make(chan interface{}, 10)
+	err := NewDecoder(strings.NewReader("Name  Code\nPeter 1   \nNicki x   \nJohn  3   \n")).DecodeToChannel(bad, R{})
+	assert.IsType(t, &CastingError{}, err)
+	assert.Len(t, bad, 1)
+
+	// Cancelling stops a decoder blocked by a receiver which has gone away.
+	ctx, cancel := context.WithCancel(context.Background())
+	blocked := make(chan interface{})
+	go func() {
+		errs <- NewDecoder(strings.NewReader(source)).DecodeToChannelContext(ctx, blocked, R{})
+	}()
+	assert.Equal(t, R{"Peter", 1}, <-blocked)
+	cancel()
+	assert.Equal(t, context.Canceled, <-errs)
+	_, open := <-blocked
+	assert.False(t, open)
+
+	invalid := make(chan interface{})
+	assert.IsType(t, &InvalidInputError{}, NewDecoder(strings.NewReader(source)).DecodeToChannel(invalid, []R{}))
+	_, open = <-invalid
+	assert.False(t, open)
+}
+
+func TestNormalizeTag(t *testing.T) {
+
+	type N struct {
+		Lower *string `column:"Name" normalize:"lower"`
+		Upper string  `column:"Name" normalize:"upper"`
+		Title string  `column:"City" normalize:"title"`
+		Key   string  `column:"Key" normalize:"trimfold"`
+	}
+
+	source := "Name      City           Key          \n" +
+		"ÉMILE     são paulo      Straße   GmbH\n"
+
+	obtained := []N{}
+	assert.Nil(t, Unmarshal([]byte(source), &obtained))
+	if assert.Len(t, obtained, 1) {
+		assert.Equal(t, "émile", *obtained[0].Lower)
+		assert.Equal(t, "ÉMILE", obtained[0].Upper)
+		assert.Equal(t, "São Paulo", obtained[0].Title)
+		assert.Equal(t, "strasse gmbh", obtained[0].Key)
+	}
+
+	// The Unicode rules for changing case go beyond mapping each character on its own.
+	obtained = []N{}
+	assert.Nil(t, Unmarshal([]byte("Name  \nΟΔΟΣ  \nStraße"), &obtained))
+	if assert.Len(t, obtained, 2) {
+		assert.Equal(t, "οδος", *obtained[0].Lower)
+		assert.Equal(t, "STRASSE", obtained[1].Upper)
+	}
+
+	type Bad struct {
+		Name string `normalize:"camel"`
+	}
+	type BadKind struct {
+		Name int `normalize:"lower"`
+	}
+	assert.IsType(t, &InvalidTagError{}, Unmarshal([]byte(source), &[]Bad{}))
+	assert.IsType(t, &InvalidTagError{}, Unmarshal([]byte(source), &[]BadKind{}))
+}
+
+func TestEncodedBytes(t *testing.T) {
+
+	type B struct {
+		Raw    []byte
+		Hex    []byte `encoding:"hex"`
+		Base64 []byte `encoding:"base64"`
+	}
+
+	obtained := []B{}
+	err := Unmarshal([]byte("Raw  Hex      Base64  \nab c 00ff10aB aGk/Pz4=\n"), &obtained)
+	assert.Nil(t, err)
+	assert.Equal(t, []B{{Raw: []byte("ab c"), Hex: []byte{0x00, 0xff, 0x10, 0xab}, Base64: []byte("hi??>")}}, obtained)
+
+	err = Unmarshal([]byte("Raw  Hex      Base64  \nab c 00fg10aB aGk/Pz4=\n"), &obtained)
+	if assert.IsType(t, &CastingError{}, err) {
+		assert.Equal(t, "Hex", err.(*CastingError).Field.Name)
+	}
+
+	err = Unmarshal([]byte("Raw  Hex      Base64  \nab c 00f      aGk/Pz4=\n"), &obtained)
+	if assert.IsType(t, &CastingError{}, err) {
+		assert.Equal(t, "Hex", err.(*CastingError).Field.Name)
+	}
+
+	err = Unmarshal([]byte("Raw  Hex      Base64  \nab c 00ff10aB aGk_Pz4 \n"), &obtained)
+	if assert.IsType(t, &CastingError{}, err) {
+		assert.Equal(t, "Base64", err.(*CastingError).Field.Name)
+	}
+
+	type BadEncoding struct {
+		Hex []byte `encoding:"base32"`
+	}
+	type BadType struct {
+		Hex []int
+	}
+	assert.IsType(t, &InvalidTagError{}, Unmarshal([]byte("Hex\n00 \n"), &[]BadEncoding{}))
+	assert.IsType(t, &InvalidTypeError{}, Unmarshal([]byte("Hex\n00 \n"), &[]BadType{}))
+}
+
+func TestRegisterFastDecoder(t *testing.T) {
+
+	type R struct {
+		Name string
+		Code int
+	}
+
+	source := "Name  Code\nPeter 1   \nNicki 2   \n"
+
+	calls := 0
+	fast := func(line string, dst interface{}) error {
+		calls++
+		r := dst.(*R)
+		r.Name = strings.TrimSpace(line[:6])
+		code, err := strconv.Atoi(strings.TrimSpace(line[6:]))
+		r.Code = code * 10
+		return err
+	}
+
+	obtained := []R{}
+	decoder := NewDecoder(strings.NewReader(source))
+	decoder.RegisterFastDecoder(R{}, fast)
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, []R{{"Peter", 10}, {"Nicki", 20}}, obtained)
+	assert.Equal(t, 2, calls)
+
+	pointers := []*R{}
+	decoder = NewDecoder(strings.NewReader(source))
+	decoder.RegisterFastDecoder(&R{}, fast)
+	record := R{}
+	assert.Nil(t, decoder.Decode(&record))
+	assert.Equal(t, R{"Peter", 10}, record)
+
+	// Removing the fast decoder goes back to the annotations.
+	decoder.RegisterFastDecoder(R{}, nil)
+	assert.Nil(t, decoder.Decode(&pointers))
+	assert.Equal(t, []*R{{"Nicki", 2}}, pointers)
+	assert.Equal(t, 3, calls)
+
+	// Other decoders are not affected.
+	obtained = []R{}
+	assert.Nil(t, Unmarshal([]byte(source), &obtained))
+	assert.Equal(t, []R{{"Peter", 1}, {"Nicki", 2}}, obtained)
+
+	decoder = NewDecoder(strings.NewReader("Name  Code\nPeter x   \n"))
+	decoder.RegisterFastDecoder(R{}, fast)
+	assert.IsType(t, &strconv.NumError{}, decoder.Decode(&obtained))
+	assert.Equal(t, int64(1), decoder.Stats().Errors)
+}
+
+func TestInferPadding(t *testing.T) {
+
+	type R struct {
+		Name   string
+		Amount int
+		Ref    string `pad:"*" padSide:"right"`
+		Code   string
+		Zip    string
+		Total  *float64
+	}
+
+	source := "Name   Amount Ref    Code Zip   Total \n" +
+		"Peter  000120 AB**** 1000 00123 ***1.5\n" +
+		"Nicki  000000 C***** 2000 00456 ****2.\n" +
+		"Zoë    004500 DEF*** 3000 01234 *12.25\n"
+
+	totals := []float64{1.5, 2, 12.25}
+	expected := []R{
+		{"Peter", 120, "AB", "1000", "00123", &totals[0]},
+		{"Nicki", 0, "C", "2000", "00456", &totals[1]},
+		{"Zoë", 4500, "DEF", "3000", "01234", &totals[2]},
+	}
+
+	obtained := []R{}
+	decoder := NewDecoder(strings.NewReader(source))
+	decoder.InferPadding = 2
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, expected, obtained)
+
+	// Padding found in text columns is not removed, so leading zeros are kept.
+	type T struct {
+		Ref string
+		Zip string
+	}
+	text := []T{}
+	decoder = NewDecoder(strings.NewReader(source))
+	decoder.InferPadding = 3
+	assert.Nil(t, decoder.Decode(&text))
+	assert.Equal(t, []T{{"AB****", "00123"}, {"C*****", "00456"}, {"DEF***", "01234"}}, text)
+
+	// Without inference the padding is left in place.
+	type U struct {
+		Amount int
+		Total  string
+	}
+	unpadded := []U{}
+	assert.Nil(t, Unmarshal([]byte(source), &unpadded))
+	assert.Equal(t, U{120, "***1.5"}, unpadded[0])
+
+	// Fields can override the padding found, or set their own.
+	type P struct {
+		Name   string
+		Amount string `pad:""`
+		Ref    string `pad:"*" padSide:"left"`
+		Code   string `pad:"0" padSide:"right"`
+	}
+	padded := []P{}
+	decoder = NewDecoder(strings.NewReader(source))
+	decoder.InferPadding = 10
+	assert.Nil(t, decoder.Decode(&padded))
+	assert.Equal(t, P{"Peter", "000120", "AB****", "1"}, padded[0])
+
+	// Peeked records are still decoded once padding has been inferred.
+	obtained = []R{}
+	decoder = NewDecoder(strings.NewReader(source))
+	decoder.InferPadding = 1
+	line, err := decoder.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, "Peter  000120 AB**** 1000 00123 ***1.5", line)
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, expected, obtained)
+
+	type Bad struct {
+		Name string `pad:"*" padSide:"middle"`
+	}
+	assert.IsType(t, &InvalidTagError{}, Unmarshal([]byte(source), &[]Bad{}))
+}
+
+func TestSkippedField(t *testing.T) {
+
+	type S struct {
+		Name  string
+		Code  int    `column:"-"`
+		Dash  string `column:"-,"`
+		Notes string
+	}
+
+	obtained := []S{}
+	err := Unmarshal([]byte("Name  Code - Notes\nPeter 1    x hi   \n"), &obtained)
+	assert.Nil(t, err)
+	assert.Equal(t, []S{{Name: "Peter", Dash: "x", Notes: "hi"}}, obtained)
+
+	output, err := Marshal(S{Name: "Peter", Code: 1, Dash: "x"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Name  - Notes\nPeter x      \n", string(output))
+
+	// A skipped field still takes up its width in a nested layout.
+	type Inner struct {
+		A string `width:"2"`
+		B string `width:"2" column:"-"`
+		C string `width:"2"`
+	}
+	type Outer struct {
+		Inner Inner `column:"Inner"`
+	}
+	outer := []Outer{}
+	assert.Nil(t, Unmarshal([]byte("Inner \naabbcc\n"), &outer))
+	assert.Equal(t, Inner{A: "aa", C: "cc"}, outer[0].Inner)
+}
+
+func TestDecoderConfig(t *testing.T) {
+
+	type S struct {
+		Name string
+		Age  int
+	}
+
+	decoder := NewDecoder(strings.NewReader(""))
+	decoder.SetHeaders(map[string][]int{"Name": {0, 5}, "Age": {5, 8}})
+	decoder.SkipFirstRecord = true
+	decoder.IgnoreEmptyRecords = true
+	decoder.SetAliases(map[string]string{"Years": "Age"})
+	decoder.RegisterConverter(reflect.TypeOf(0), func(s string) (interface{}, error) {
+		n, err := strconv.Atoi(s)
+		return n * 2, err
+	})
+
+	config := decoder.Config()
+	assert.True(t, config.SkipFirstRecord)
+	assert.True(t, config.IgnoreEmptyRecords)
+	assert.Equal(t, map[string][]int{"Name": {0, 5}, "Age": {5, 8}}, config.Headers)
+	assert.Equal(t, map[string]string{"Years": "Age"}, config.Aliases)
+
+	obtained := []S{}
+	err := NewDecoderFromConfig(strings.NewReader("xxxxxxxx\nPeter 21\n\nPaul  30\n"), config).Decode(&obtained)
+	assert.Nil(t, err)
+	assert.Equal(t, []S{{"Peter", 42}, {"Paul", 60}}, obtained)
+
+	// Changing the configuration doesn't change the decoders it came from or was applied to.
+	other := NewDecoderFromConfig(strings.NewReader(""), config)
+	config.Headers["Name"][1] = 2
+	config.Aliases["Years"] = "Name"
+	assert.Equal(t, []int{0, 5}, decoder.Config().Headers["Name"])
+	assert.Equal(t, []int{0, 5}, other.Config().Headers["Name"])
+	assert.Equal(t, "Age", other.Config().Aliases["Years"])
+
+	// Headers read from the input are not part of the configuration.
+	decoder = NewDecoder(strings.NewReader("Name Age\nPeter 21\n"))
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Nil(t, decoder.Config().Headers)
+
+	// Every decoder option has a field in the configuration.
+	decoderType, configType := reflect.TypeOf(Decoder{}), reflect.TypeOf(DecoderConfig{})
+	for i := 0; i < decoderType.NumField(); i++ {
+		field := decoderType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		configField, ok := configType.FieldByName(field.Name)
+		if assert.True(t, ok, field.Name) {
+			assert.Equal(t, field.Type, configField.Type, field.Name)
+		}
+	}
+}
+
+func TestPartialDecoderConfig(t *testing.T) {
+
+	type S struct {
+		Name string
+		Age  int
+	}
+
+	// A configuration with no RecordTerminator can't split the input, so decoding stops with an error.
+	config := DecoderConfig{FieldSeparator: " "}
+	obtained := []S{}
+	err := NewDecoderFromConfig(strings.NewReader("Name  Age\nPeter 21\n"), config).Decode(&obtained)
+	assert.ErrorIs(t, err, ErrNoRecordTerminator)
+	assert.Empty(t, obtained)
+	err = NewDecoderFromConfig(strings.NewReader("Name  Age\nPeter 21\n"), config).DecodeN(&obtained, 5)
+	assert.ErrorIs(t, err, ErrNoRecordTerminator)
+	assert.Empty(t, obtained)
+
+	// Starting from the defaults only the options which are changed need to be given.
+	config = DefaultDecoderConfig()
+	config.IgnoreEmptyRecords = true
+	err = NewDecoderFromConfig(strings.NewReader("Name  Age\nPeter 21 \n\nPaul  30 \n"), config).Decode(&obtained)
+	assert.Nil(t, err)
+	assert.Equal(t, []S{{"Peter", 21}, {"Paul", 30}}, obtained)
+}
+
+func TestDefaultTime(t *testing.T) {
+
+	type S struct {
+		Name    string
+		Joined  time.Time  `format:"2006-01-02" default:"2000-01-01"`
+		Left    *time.Time `format:"2006-01-02"`
+		Retired *time.Time `format:"2006-01-02" default:"1999-12-31"`
+		Count   int        `default:"7"`
+	}
+
+	input := "Name  Joined     Left       Retired    Count\n" +
+		"Peter 2021-03-04 2022-05-06 2023-07-08 1    \n" +
+		"Paul                                        \n"
+
+	obtained := []S{}
+	assert.Nil(t, Unmarshal([]byte(input), &obtained))
+	assert.Equal(t, 2, len(obtained))
+
+	assert.Equal(t, time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC), obtained[0].Joined)
+	assert.Equal(t, time.Date(2022, 5, 6, 0, 0, 0, 0, time.UTC), *obtained[0].Left)
+	assert.Equal(t, 1, obtained[0].Count)
+
+	assert.Equal(t, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), obtained[1].Joined)
+	assert.Nil(t, obtained[1].Left)
+	assert.Equal(t, time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC), *obtained[1].Retired)
+	assert.Equal(t, 7, obtained[1].Count)
+
+	// A blank time without a default still fails to parse.
+	type Plain struct {
+		Joined time.Time `format:"2006-01-02"`
+	}
+	plain := []Plain{}
+	err := Unmarshal([]byte("Joined    \n          \n"), &plain)
+	assert.IsType(t, &CastingError{}, err)
+
+	// The default must be valid for the format.
+	type Bad struct {
+		Joined time.Time `format:"2006-01-02" default:"01/01/2000"`
+	}
+	bad := []Bad{}
+	err = Unmarshal([]byte("Joined    \n2021-03-04\n"), &bad)
+	assert.IsType(t, &InvalidTagError{}, err)
+}
+
+func TestSetHeadersSkipFirstRecord(t *testing.T) {
+
+	type S struct {
+		Name string
+		Age  int
+	}
+
+	// The header line has labels at positions which don't match the data and is longer than it.
+	input := "   Name of person     Age in years\n" +
+		"Peter  21\n" +
+		"Paul   30\n" +
+		"Mary   45\n"
+
+	decoder := NewDecoder(strings.NewReader(input))
+	decoder.SetHeaders(map[string][]int{"Name": {0, 7}, "Age": {7, 9}})
+	decoder.SkipFirstRecord = true
+
+	// Only the first line is discarded, however many calls are made.
+	var s S
+	assert.Nil(t, decoder.Decode(&s))
+	assert.Equal(t, S{"Peter", 21}, s)
+	assert.Nil(t, decoder.Decode(&s))
+	assert.Equal(t, S{"Paul", 30}, s)
+
+	rest := []S{}
+	assert.Nil(t, decoder.Decode(&rest))
+	assert.Equal(t, []S{{"Mary", 45}}, rest)
+
+	// The record length comes from the offsets given, not the discarded line.
+	decoder = NewDecoder(strings.NewReader(input + "Jo     5\n"))
+	decoder.SetHeaders(map[string][]int{"Name": {0, 7}, "Age": {7, 9}})
+	decoder.SkipFirstRecord = true
+	all := []S{}
+	err := decoder.Decode(&all)
+	var lengthErr *InvalidLengthError
+	if assert.ErrorAs(t, err, &lengthErr) {
+		assert.Equal(t, 9, lengthErr.HeadersLength)
+		assert.Equal(t, "Jo     5", lengthErr.Line)
+	}
+
+	// Headers set after others are replaced entirely, including the record length.
+	decoder = NewDecoder(strings.NewReader("Peter  21\n"))
+	decoder.SetHeaders(map[string][]int{"Name": {0, 7}, "Age": {7, 20}})
+	decoder.SetHeaders(map[string][]int{"Name": {0, 7}, "Age": {7, 9}})
+	assert.Nil(t, decoder.Decode(&s))
+	assert.Equal(t, S{"Peter", 21}, s)
+
+	// Offsets which can't be used are an error rather than a panic.
+	for _, offsets := range [][]int{{-1, 7}, {7, 3}, {7}} {
+		decoder = NewDecoder(strings.NewReader("Peter  21\n"))
+		decoder.SetHeaders(map[string][]int{"Name": {0, 7}, "Age": offsets})
+		assert.Equal(t, &InvalidOffsetsError{Column: "Age", Offsets: offsets}, decoder.Decode(&s))
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+
+	type S struct {
+		Name    string
+		Age     int
+		Balance *float64
+		Joined  time.Time `format:"2006-01-02"`
+		Tags    []string  `join:","`
+		Rest    string    `column:"Rest"`
+	}
+
+	f.Add([]byte("Name  Age Balance Joined\nPeter 21  1.5     2021-03-04\n"), "", " ", false, uint8(0), 0, 5, 5, 8)
+	f.Add([]byte("Name,Age\nPeter,21\n"), "", ",", true, uint8(0), 0, 1, 1, 2)
+	f.Add([]byte("Nämé  Agé\nPétér 21\n\n"), "\r\n", " ", false, uint8(1), 0, 6, 6, 100)
+	f.Add([]byte("|Name|Age|\n|Peter|21|\n"), "", " ", false, uint8(4), 0, 6, 6, 100)
+	f.Add([]byte("世界 Age\n世界 21\n"), "", " ", false, uint8(2), -2, 1, 4, 6)
+	f.Add([]byte("x"), "x", "", false, uint8(8), -1, 3, 9, 2)
+	f.Add([]byte("0"), "0", "\xa0", false, uint8(0), -68, 6, 6, 87)
+	f.Add([]byte("0"), "0", "0", false, uint8(0), -68, -3, 6, 134)
+	f.Add([]byte("0"), "0", "0", false, uint8(31), 33, 5, 5, -66)
+
+	f.Fuzz(func(t *testing.T, input []byte, terminator, separator string, delimited bool, options uint8, nameFrom, nameTo, ageFrom, ageTo int) {
+
+		decoder := NewDecoder(bytes.NewReader(input))
+		if terminator != "" {
+			decoder.RecordTerminator = []byte(terminator)
+		}
+		if separator != "" {
+			decoder.FieldSeparator = regexp.QuoteMeta(separator)
+		}
+		decoder.Delimited = delimited
+		decoder.WidthMode = WidthMode(options % 3)
+		if options&4 != 0 {
+			decoder.ColumnDelimiter = '|'
+		}
+		if options&8 != 0 {
+			decoder.FixedRecordLength = int(options >> 4)
+		}
+		decoder.IgnoreEmptyRecords = options&16 != 0
+		decoder.SkipLengthCheck = nameFrom%2 == 0
+		if nameFrom < 0 {
+			decoder.SetHeaders(map[string][]int{"Name": {-nameFrom, nameTo}, "Age": {ageFrom, ageTo}})
+		}
+
+		obtained := []S{}
+		_ = decoder.Decode(&obtained)
+
+		// The other entry points which take offsets must not panic on them either. Their output is as long
+		// as the largest offset so only those which could be a real record length are tried.
+		if nameTo > 1<<12 || ageTo > 1<<12 {
+			return
+		}
+		headers := map[string][]int{"Name": {-nameFrom, nameTo}, "Age": {ageFrom, ageTo}}
+		rr := NewRecordReader(bytes.NewReader(input), ageTo)
+		rr.SetHeaders(headers)
+		_ = rr.ReadRecord(0, &S{})
+		_ = Reflow(bytes.NewReader(input), io.Discard, headers, map[string][]int{"Name": {nameFrom, nameTo}, "Age": {ageFrom, ageTo}})
+		_ = Reflow(bytes.NewReader(input), io.Discard, nil, headers)
+	})
+}
+
+func TestTrailerPredicate(t *testing.T) {
+
+	type S struct {
+		Code string
+		Name string
+		Age  int
+	}
+	type Summary struct {
+		Code  string
+		Count int `column:"Name"`
+	}
+
+	input := "Code Name  Age\n" +
+		"001  Peter 21 \n" +
+		"002  Paul  30 \n" +
+		"999  2\n" +
+		"003  Mary  45 \n"
+
+	trailer := func(line string) bool { return strings.HasPrefix(line, "999") }
+
+	decoder := NewDecoder(strings.NewReader(input))
+	decoder.TrailerPredicate = trailer
+	_, found := decoder.Trailer()
+	assert.False(t, found)
+
+	obtained := []S{}
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, []S{{"001", "Peter", 21}, {"002", "Paul", 30}}, obtained)
+	assert.Equal(t, int64(2), decoder.Stats().Records)
+
+	line, found := decoder.Trailer()
+	assert.True(t, found)
+	assert.Equal(t, "999  2", line)
+
+	var summary Summary
+	assert.Nil(t, decoder.DecodeTrailer(&summary))
+	assert.Equal(t, Summary{"999", 2}, summary)
+
+	// Decoding one record at a time ends with io.EOF at the trailer.
+	decoder = NewDecoder(strings.NewReader(input))
+	decoder.TrailerPredicate = trailer
+	var s S
+	assert.Nil(t, decoder.Decode(&s))
+	assert.Nil(t, decoder.Decode(&s))
+	assert.Equal(t, io.EOF, decoder.Decode(&s))
+	assert.Equal(t, S{"002", "Paul", 30}, s)
+
+	// Without the predicate the trailer is read as a data record.
+	obtained = []S{}
+	err := NewDecoder(strings.NewReader(input)).Decode(&obtained)
+	assert.IsType(t, &InvalidLengthError{}, err)
+
+	// Without a trailer there is nothing to decode.
+	decoder = NewDecoder(strings.NewReader("Code\n001 \n"))
+	decoder.TrailerPredicate = trailer
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, io.EOF, decoder.DecodeTrailer(&summary))
+	assert.IsType(t, &InvalidInputError{}, decoder.DecodeTrailer(summary))
+}
+
+func TestHeaderLines(t *testing.T) {
+
+	type S struct {
+		Account string
+		Q1Sales int `column:"2023.Sales.Q1"`
+		Q2Sales int `column:"2023.Sales.Q2"`
+		Q1Costs int `column:"2023.Costs.Q1"`
+		Prior   int `column:"2022.All.Total"`
+	}
+
+	input := "        2023                    2022         \n" +
+		"        Sales          Costs    All          \n" +
+		"Account Q1    Q2       Q1       Total        \n" +
+		"Widgets 100   200      50       900          \n"
+
+	decoder := NewDecoder(strings.NewReader(input))
+	decoder.HeaderLines = 3
+	obtained := []S{}
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, []S{{"Widgets", 100, 200, 50, 900}}, obtained)
+	assert.Equal(t, []string{"Account", "2023.Sales.Q1", "2023.Sales.Q2", "2023.Costs.Q1", "2022.All.Total"}, decoder.ColumnOrder())
+
+	// A group covers every column up to the next label on its line.
+	decoder = NewDecoder(strings.NewReader("     Sales\nName Q1 Q2\nPaul 1  2 \n"))
+	decoder.HeaderLines = 2
+	headers, err := decoder.DecodeHeaderOnly()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]int{"Name": {0, 5}, "Sales.Q1": {5, 8}, "Sales.Q2": {8, 10}}, headers)
+
+	// All of the header lines are discarded when the headers are given.
+	decoder = NewDecoder(strings.NewReader(input))
+	decoder.SetHeaders(map[string][]int{"Account": {0, 8}})
+	decoder.SkipFirstRecord = true
+	decoder.HeaderLines = 3
+	decoder.SkipLengthCheck = true
+	var s S
+	assert.Nil(t, decoder.Decode(&s))
+	assert.Equal(t, "Widgets", s.Account)
+
+	// Input which ends within the header has no records.
+	decoder = NewDecoder(strings.NewReader("     Sales\n"))
+	decoder.HeaderLines = 2
+	assert.Equal(t, io.EOF, decoder.Decode(&s))
+}
+
+func TestStripCurrency(t *testing.T) {
+
+	type S struct {
+		Amount float64
+		Count  *int
+		Name   string
+	}
+
+	input := "Amount     Count Name \n" +
+		"$ 1234.56  $3    $x   \n" +
+		"-£12       4     £    \n" +
+		"12.50 €    €-5   €    \n" +
+		"¥100       +¥6   y    \n" +
+		"$-7.25     7 $   z    \n"
+
+	decoder := NewDecoder(strings.NewReader(input))
+	decoder.StripCurrency = true
+	obtained := []S{}
+	assert.Nil(t, decoder.Decode(&obtained))
+	ints := []int{3, 4, -5, 6, 7}
+	expected := []S{{1234.56, &ints[0], "$x"}, {-12, &ints[1], "£"}, {12.5, &ints[2], "€"}, {100, &ints[3], "y"}, {-7.25, &ints[4], "z"}}
+	assert.Equal(t, expected, obtained)
+
+	// Commas grouping the digits are removed along with the symbol.
+	input = "Amount      Count  \n" +
+		"$ 1,234.56  $1,000 \n" +
+		"-€1,234,567 -¥2,345\n" +
+		"1,000.5 £   12     \n"
+	decoder = NewDecoder(strings.NewReader(input))
+	decoder.StripCurrency = true
+	obtained = []S{}
+	assert.Nil(t, decoder.Decode(&obtained))
+	ints = []int{1000, -2345, 12}
+	expected = []S{{Amount: 1234.56, Count: &ints[0]}, {Amount: -1234567, Count: &ints[1]}, {Amount: 1000.5, Count: &ints[2]}}
+	assert.Equal(t, expected, obtained)
+
+	// Other symbols, symbols which aren't at either end, commas without a symbol and commas which don't
+	// group the digits in threes are not removed.
+	for _, amount := range []string{"₹12  ", "1$2  ", "$$1  ", "1,234", "$1,23", "$12,3456", "$,123", "$1,2a4"} {
+		decoder = NewDecoder(strings.NewReader("Amount  \n" + fmt.Sprintf("%-8s", amount) + "\n"))
+		decoder.StripCurrency = true
+		var s S
+		err := decoder.Decode(&s)
+		assert.IsType(t, &CastingError{}, err, amount)
+	}
+
+	// The symbols can be replaced.
+	decoder = NewDecoder(strings.NewReader("Amount\n12 ₹  \n$12   \n"))
+	decoder.StripCurrency = true
+	decoder.CurrencySymbols = "₹"
+	var s S
+	assert.Nil(t, decoder.Decode(&s))
+	assert.Equal(t, 12.0, s.Amount)
+	assert.IsType(t, &CastingError{}, decoder.Decode(&s))
+
+	// Without StripCurrency the symbol is an error.
+	assert.IsType(t, &CastingError{}, Unmarshal([]byte("Amount\n$12   \n"), &obtained))
+}
+
+func TestDecodeOverlay(t *testing.T) {
+
+	type Address struct {
+		Street string `width:"6"`
+		Town   string `width:"6"`
+	}
+	type S struct {
+		Name     string
+		Age      int
+		Country  string
+		Limit    *float64
+		Address  *Address `column:"Address"`
+		internal string
+	}
+
+	limit := 10.0
+	address := &Address{Street: "old", Town: "old"}
+	s := S{Name: "default", Age: 99, Country: "UK", Limit: &limit, Address: address, internal: "kept"}
+
+	decoder := NewDecoder(strings.NewReader("Name  Age Address     \nPeter 21  High  Leeds \n"))
+	assert.Nil(t, decoder.Decode(&s))
+
+	// Columns in the input replace the values of their fields; other fields are left as they were.
+	assert.Equal(t, "Peter", s.Name)
+	assert.Equal(t, 21, s.Age)
+	assert.Equal(t, "UK", s.Country)
+	assert.Equal(t, &limit, s.Limit)
+	assert.Equal(t, "kept", s.internal)
+	// A nested struct is decoded into the existing allocation.
+	assert.Same(t, address, s.Address)
+	assert.Equal(t, Address{"High", "Leeds"}, *s.Address)
+
+	// Records appended to a slice start from the zero value.
+	obtained := []*S{{Name: "existing", Country: "UK"}}
+	assert.Nil(t, Unmarshal([]byte("Name \nPaul \n"), &obtained))
+	assert.Equal(t, 2, len(obtained))
+	assert.Equal(t, "existing", obtained[0].Name)
+	assert.Equal(t, &S{Name: "Paul"}, obtained[1])
+}
+
+func TestRecordLengthErrors(t *testing.T) {
+
+	type S struct {
+		Name string
+		Code int
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		length int
+		target error
+	}{
+		{name: "short", source: "Name  Code\nPeter 1\n", length: 7, target: ErrRecordTooShort},
+		{name: "empty", source: "Name  Code\n\n", length: 0, target: ErrRecordTooShort},
+		{name: "long", source: "Name  Code\nPeter 1   Paul  2   \n", length: 20, target: ErrRecordTooLong},
+		{name: "runes", source: "Name  Code\nPétér 1    \n", length: 11, target: ErrRecordTooLong},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			obtained := []S{}
+			err := Unmarshal([]byte(test.source), &obtained)
+			assert.ErrorIs(t, err, test.target)
+			var lengthErr *InvalidLengthError
+			if assert.ErrorAs(t, err, &lengthErr) {
+				assert.Equal(t, test.length, lengthErr.Length)
+				assert.Equal(t, 10, lengthErr.HeadersLength)
+				assert.Equal(t, fmt.Sprintf("wrong data length in line 2 (%d != 10)", test.length), err.Error())
+			}
+		})
+	}
+}
+
+func TestFromTag(t *testing.T) {
+
+	type S struct {
+		Name    string
+		Code    int
+		Remarks string `from:"11"`
+		All     string `from:"0"`
+	}
+
+	input := "Name  Code Remarks\n" +
+		"Peter 1    late, see note   \n" +
+		"Paul  2    \n" +
+		"Mary  3\n" +
+		"Jo    4    x\n"
+
+	decoder := NewDecoder(strings.NewReader(input))
+	decoder.SkipLengthCheck = true
+	obtained := []S{}
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, []S{
+		{"Peter", 1, "late, see note", "Peter 1    late, see note"},
+		{"Paul", 2, "", "Paul  2"},
+		{"Mary", 3, "", "Mary  3"},
+		{"Jo", 4, "x", "Jo    4    x"},
+	}, obtained)
+
+	type Bad struct {
+		Remarks string `from:"-1"`
+	}
+	assert.IsType(t, &InvalidTagError{}, Unmarshal([]byte("Remarks\nx      \n"), &[]Bad{}))
+
+	decoder = NewDecoder(strings.NewReader("Name,Remarks\nPeter,x\n"))
+	decoder.Delimited = true
+	decoder.FieldSeparator = ","
+	assert.IsType(t, &InvalidTagError{}, decoder.Decode(&[]S{}))
+}
+
+func TestMapsToStructs(t *testing.T) {
+
+	type S struct {
+		Name    string
+		Age     int
+		Joined  time.Time `format:"2006-01-02"`
+		Active  bool      `true:"Y" false:"N"`
+		Limit   *float64  `column:"Credit Limit"`
+		Country string    `default:"UK"`
+		Ignored string    `column:"-"`
+	}
+
+	rows := []map[string]string{
+		{"Name": "Peter", "Age": "21", "Joined": "2021-03-04", "Active": "Y", "Credit Limit": "10.5", "Country": "", "Ignored": "x"},
+		{"Name": "Paul", "Extra": "unused"},
+	}
+
+	obtained, err := MapsToStructs[S](rows)
+	assert.Nil(t, err)
+	limit := 10.5
+	assert.Equal(t, []S{
+		{Name: "Peter", Age: 21, Joined: time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC), Active: true, Limit: &limit, Country: "UK"},
+		{Name: "Paul"},
+	}, obtained)
+
+	_, err = MapsToStructs[S]([]map[string]string{{"Age": "1"}, {"Age": "old"}})
+	var mapErr *MapValueError
+	if assert.ErrorAs(t, err, &mapErr) {
+		assert.Equal(t, 1, mapErr.Row)
+		assert.Equal(t, "Age", mapErr.Key)
+	}
+	var castErr *CastingError
+	if assert.ErrorAs(t, err, &castErr) {
+		assert.Equal(t, "Age", castErr.Field.Name)
+	}
+
+	_, err = MapsToStructs[int](rows)
+	assert.IsType(t, &InvalidInputError{}, err)
+}
+
+func TestTabWidth(t *testing.T) {
+
+	type S struct {
+		Name string
+		Code int
+		Town string
+	}
+
+	// Tabs move to the next multiple of 8, whatever precedes them.
+	input := "Name\tCode\tTown    \n" +
+		"Peter\t1\tLeeds   \n" +
+		"Jo  \t22 \tYork\t\n" +
+		"Pétér\t3\t\tx\n"
+
+	decoder := NewDecoder(strings.NewReader(input))
+	decoder.TabWidth = 8
+	decoder.SkipLengthCheck = true
+	obtained := []S{}
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, []S{{"Peter", 1, "Leeds"}, {"Jo", 22, "York"}, {"Pétér", 3, ""}}, obtained)
+	assert.Equal(t, map[string][]int{"Name": {0, 8}, "Code": {8, 16}, "Town": {16, 24}}, decoder.headers)
+
+	// Positions are measured in the units of WidthMode.
+	assert.Equal(t, "é  x", WidthBytes.expandTabs("é\tx", 4))
+	assert.Equal(t, "世  x", WidthCells.expandTabs("世\tx", 4))
+	assert.Equal(t, "é   x", WidthRunes.expandTabs("é\tx", 4))
+	assert.Equal(t, "a\tb", WidthRunes.expandTabs("a\tb", 0))
+
+	// Without TabWidth a tab is a single character.
+	assert.NotNil(t, Unmarshal([]byte(input), &obtained))
+}
+
+func TestPlusSign(t *testing.T) {
+
+	type S struct {
+		I   int     `column:"N"`
+		I8  int8    `column:"N"`
+		I64 int64   `column:"N"`
+		U   uint    `column:"N"`
+		U8  uint8   `column:"N"`
+		U64 *uint64 `column:"N"`
+		F32 float32 `column:"N"`
+		F64 float64 `column:"N"`
+	}
+
+	obtained := []S{}
+	assert.Nil(t, Unmarshal([]byte("N     \n+00123\n+0    \n"), &obtained))
+	u64, zero := uint64(123), uint64(0)
+	assert.Equal(t, []S{{123, 123, 123, 123, 123, &u64, 123, 123}, {U64: &zero}}, obtained)
+
+	type U struct {
+		U uint `column:"N"`
+	}
+	for _, value := range []string{"+", "++1", "+-1", "1+"} {
+		err := Unmarshal([]byte("N  \n"+fmt.Sprintf("%-3s", value)+"\n"), &[]U{})
+		assert.IsType(t, &CastingError{}, err, value)
+	}
+}
+
+func TestUseJSONTags(t *testing.T) {
+
+	type S struct {
+		Name     string `json:"name"`
+		Age      int    `json:"age,omitempty"`
+		Town     string `json:"town" column:"City"`
+		Country  string `json:",omitempty"`
+		Password string `json:"-"`
+		Dash     string `json:"-,"`
+		Code     int
+	}
+
+	input := "name  age City  Country Password - Code\n" +
+		"Peter 21  Leeds UK      secret   x 7   \n"
+
+	decoder := NewDecoder(strings.NewReader(input))
+	decoder.UseJSONTags = true
+	obtained := []S{}
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, []S{{Name: "Peter", Age: 21, Town: "Leeds", Country: "UK", Dash: "x", Code: 7}}, obtained)
+
+	// json annotations are ignored by default.
+	obtained = []S{}
+	assert.Nil(t, Unmarshal([]byte(input), &obtained))
+	assert.Equal(t, []S{{Town: "Leeds", Country: "UK", Password: "secret", Code: 7}}, obtained)
+
+	// Nested layouts use the json names too.
+	type Inner struct {
+		A string `width:"2" json:"a"`
+		B string `width:"2" json:"-"`
+		C string `width:"2"`
+	}
+	type Outer struct {
+		Inner Inner `json:"inner"`
+	}
+	decoder = NewDecoder(strings.NewReader("inner \naabbcc\n"))
+	decoder.UseJSONTags = true
+	outer := []Outer{}
+	assert.Nil(t, decoder.Decode(&outer))
+	assert.Equal(t, []Outer{{Inner{A: "aa", C: "cc"}}}, outer)
+}
+
+func TestReparseHeaders(t *testing.T) {
+
+	type Person struct {
+		Name string
+		Age  int
+	}
+	type Order struct {
+		Order int
+		Item  string
+		Qty   int
+	}
+
+	input := "Name  Age\n" +
+		"Peter 21 \n" +
+		"Paul  30 \n" +
+		"Order Item   Qty\n" +
+		"1     Widget 5  \n" +
+		"2     Bolt   10 \n"
+
+	// A trailer predicate stops each section at the next header line.
+	decoder := NewDecoder(strings.NewReader(input))
+	decoder.TrailerPredicate = func(line string) bool { return strings.HasPrefix(line, "Order ") }
+	people := []Person{}
+	assert.Nil(t, decoder.Decode(&people))
+	assert.Equal(t, []Person{{"Peter", 21}, {"Paul", 30}}, people)
+
+	assert.Nil(t, decoder.ReparseHeaders())
+	orders := []Order{}
+	assert.Nil(t, decoder.Decode(&orders))
+	assert.Equal(t, []Order{{1, "Widget", 5}, {2, "Bolt", 10}}, orders)
+	assert.Equal(t, []string{"Order", "Item", "Qty"}, decoder.ColumnOrder())
+	assert.Equal(t, io.EOF, decoder.ReparseHeaders())
+
+	// Peek finds the header line before it is decoded.
+	decoder = NewDecoder(strings.NewReader(input))
+	people = []Person{}
+	for {
+		line, err := decoder.Peek()
+		if err != nil || strings.HasPrefix(line, "Order ") {
+			break
+		}
+		var person Person
+		assert.Nil(t, decoder.Decode(&person))
+		people = append(people, person)
+	}
+	assert.Equal(t, []Person{{"Peter", 21}, {"Paul", 30}}, people)
+	assert.Nil(t, decoder.ReparseHeaders())
+	orders = []Order{}
+	assert.Nil(t, decoder.Decode(&orders))
+	assert.Equal(t, []Order{{1, "Widget", 5}, {2, "Bolt", 10}}, orders)
+
+	// Line numbers carry on across sections.
+	decoder = NewDecoder(strings.NewReader(input + "3     Nut    x  \n"))
+	decoder.TrailerPredicate = func(line string) bool { return strings.HasPrefix(line, "Order ") }
+	assert.Nil(t, decoder.Decode(&people))
+	assert.Nil(t, decoder.ReparseHeaders())
+	err := decoder.Decode(&orders)
+	assert.IsType(t, &CastingError{}, err)
+	assert.Equal(t, 7, decoder.lineNum)
+}
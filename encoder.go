@@ -0,0 +1,493 @@
+package fw
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"reflect"
+	"sort"
+	"unicode/utf8"
+)
+
+// An Encoder writes fixed width data to an output stream.
+//
+// Every exported field of the structs passed to [Encoder.Encode] becomes a column, in the order
+// the fields are declared, and columns are named in the same way as for the [Decoder]. All basic
+// go data types are supported, as are types implementing [encoding.TextMarshaler]. Nil pointers
+// are written as [Encoder.NilFieldValue]. A field annotated with format:"stringer" is written using its String
+// method if its type implements [fmt.Stringer]; otherwise the annotation is ignored. Booleans are written as
+// "true" and "false" unless the true and false annotations give other values, such as true:"Y" false:"N".
+// []byte fields are written as they are, or as hex or base64 when annotated with encoding:"hex" or
+// encoding:"base64", so that they are read back by the Decoder.
+//
+// [time.Time] fields are written using the format annotation in the same way as they are read by the [Decoder],
+// with a default of [time.RFC3339]. The zero time is written as NilFieldValue, like a nil pointer.
+//
+// Numeric fields can be formatted with a [fmt] verb given in the format annotation, for example
+// format:"%07.2f" or format:"%05d". The formatted value is then placed in its column like any other
+// value: it is padded if it is shorter than the column and a [ValueTooLongError] is returned if it
+// is longer. Numbers are never truncated, even when TruncateValues is set.
+//
+// # Column widths
+//
+// The width of each column is computed from the records passed to the first call to [Encoder.Encode]
+// as the longest of the column name and all of its values, producing an aligned report which
+// the [Decoder] can read back. The minwidth annotation sets a minimum width for a column. Columns are
+// separated by a single Padding character. The layout is kept for subsequent calls to Encode and
+// a [ValueTooLongError] is returned if a later value does not fit its column, unless TruncateValues is set.
+//
+// [Encoder.SetLayout] replaces the computed layout with offsets dictated elsewhere, in the same way as
+// [Decoder.SetHeaders] does for the decoder.
+//
+// # Alignment
+//
+// Values which are narrower than their column are aligned by type: numbers to the right, booleans in the
+// middle and everything else, including numbers written by a stringer format, to the left. The defaults for
+// numbers and other values are set by DefaultNumericAlign and DefaultStringAlign and the align annotation,
+// align:"left", align:"right" or align:"center", sets the alignment of a single field. As the decoder trims
+// padding from both ends of a value, the output can be read back whatever the alignment. Computed layouts leave
+// a Padding character between columns. Columns set with [Encoder.SetLayout] may follow one another without a gap
+// and a value may fill its column, so it can run into the value of the next column.
+type Encoder struct {
+	w                io.Writer
+	RecordTerminator []byte // RecordTerminator is written after every record, other than the last when TrailingTerminator is false (default is "\n")
+	Padding          rune   // Padding is used to pad values to the width of their column and to separate columns (default is a space)
+	WriteHeaders     bool   // WriteHeaders defines whether a line of column names is written before the first record (default is true)
+	NilFieldValue    string // NilFieldValue is written for nil pointer fields, padded to the width of the column like any other value (default is empty, giving a column of padding)
+	// TrailingTerminator defines whether RecordTerminator is written after the last record (default is true). When
+	// it is false the terminator is written before every record except the first, so that output written by several
+	// calls to Encode does not end with a terminator.
+	TrailingTerminator bool
+	TruncateValues     bool // TruncateValues cuts values which are wider than their column to fit instead of returning a [ValueTooLongError], apart from numbers
+	// AppendMode can be set to true when the output is added to the end of existing output, such as a file which
+	// already has a header line. The header line is never written, whatever the value of WriteHeaders, and when
+	// TrailingTerminator is false the terminator is written before the first record too. Column widths are computed
+	// in the same way as usual, so [Encoder.SetLayout] should be used to make the records match the existing ones.
+	AppendMode bool
+	// PreserveRaw can be set to true so that each record written by [Decoder.Transform] starts as the record it was
+	// decoded from rather than as Padding. The columns of the fields are cleared and rewritten, so the content of
+	// columns without a field is kept. It has no effect on the header line or on records written by Encode.
+	PreserveRaw bool
+	// DefaultNumericAlign is the alignment of integer and floating point values in fields without an align
+	// annotation (default is AlignRight). DefaultStringAlign is the alignment of the values of other fields, apart
+	// from booleans, which are centered. Column names in the header line are always at the start of their column.
+	DefaultNumericAlign Alignment
+	DefaultStringAlign  Alignment
+	headersWritten      bool
+	recordsWritten      bool
+	columns             []encoderColumn
+	lineLength          int
+	layoutErr           error
+	raw                 string // raw is the record being transformed by Decoder.Transform
+	timeLayouts         map[string]string
+	zw                  *gzip.Writer
+	plain               io.Writer // plain is the writer given to NewEncoder when the output is compressed
+}
+
+// An Alignment places a value which is narrower than its column.
+type Alignment int
+
+const (
+	AlignLeft   Alignment = iota // AlignLeft puts the value at the start of the column
+	AlignRight                   // AlignRight puts the value at the end of the column
+	AlignCenter                  // AlignCenter puts the value in the middle of the column, with any odd padding after it
+)
+
+// encoderColumn is the position of a column in the output, measured in runes.
+type encoderColumn struct {
+	name string
+	from int
+	to   int
+}
+
+// encodedValue is the text of a single field ready to be written.
+type encodedValue struct {
+	value   string
+	field   reflect.StructField
+	align   Alignment
+	numeric bool // numeric is set for numbers, which are never truncated
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w:                   w,
+		RecordTerminator:    []byte("\n"),
+		Padding:             ' ',
+		WriteHeaders:        true,
+		TrailingTerminator:  true,
+		DefaultNumericAlign: AlignRight,
+	}
+}
+
+// RegisterTimeLayout registers layout under name so that time fields with a format annotation of "@" followed
+// by the name, such as format:"@iso", are written with it, in the same way as [Decoder.RegisterTimeLayout]. A
+// format annotation naming a layout which has not been registered causes an InvalidTagError.
+func (encoder *Encoder) RegisterTimeLayout(name, layout string) {
+	if encoder.timeLayouts == nil {
+		encoder.timeLayouts = make(map[string]string)
+	}
+	encoder.timeLayouts[name] = layout
+}
+
+// SetGzip sets whether the output is gzip compressed. When the output is compressed, [Encoder.Close] must be
+// called once everything has been written to complete the gzip stream. [ErrEncodingStarted] is returned if
+// anything has already been written, leaving the setting unchanged.
+func (encoder *Encoder) SetGzip(compressed bool) error {
+	if encoder.started() {
+		return ErrEncodingStarted
+	}
+	if compressed && encoder.zw == nil {
+		encoder.plain = encoder.w
+		encoder.zw = gzip.NewWriter(encoder.w)
+		encoder.w = encoder.zw
+	} else if !compressed && encoder.zw != nil {
+		encoder.w = encoder.plain
+		encoder.zw = nil
+		encoder.plain = nil
+	}
+	return nil
+}
+
+// started reports whether anything has been written, after which the output can't be changed.
+func (encoder *Encoder) started() bool {
+	return encoder.headersWritten || encoder.recordsWritten
+}
+
+// Flush writes any compressed data which is pending to the underlying writer, without ending the gzip
+// stream. It does nothing unless the output is compressed.
+func (encoder *Encoder) Flush() error {
+	if encoder.zw == nil {
+		return nil
+	}
+	return encoder.zw.Flush()
+}
+
+// Close ends the gzip stream when the output is compressed, writing any pending data. The underlying
+// writer is not closed. Nothing more can be encoded once the stream has ended.
+func (encoder *Encoder) Close() error {
+	if encoder.zw == nil {
+		return nil
+	}
+	return encoder.zw.Close()
+}
+
+// Marshal returns the fixed width encoding of v, which must be a struct, a slice of structs or
+// pointers to structs, or a pointer to one of these.
+func Marshal(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode writes the fixed width encoding of v to the stream. v must be a struct, a slice of structs
+// or pointers to structs, or a pointer to one of these. The header line is written before the first
+// record if WriteHeaders is set.
+func (encoder *Encoder) Encode(v interface{}) error {
+
+	if v == nil {
+		return &InvalidInputError{Type: nil}
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return &InvalidInputError{Type: rv.Type()}
+		}
+		rv = rv.Elem()
+	}
+
+	var (
+		structType reflect.Type
+		items      []reflect.Value
+	)
+
+	switch rv.Kind() {
+	case reflect.Slice:
+		structType = rv.Type().Elem()
+		if structType.Kind() == reflect.Ptr {
+			structType = structType.Elem()
+		}
+		for i := 0; i < rv.Len(); i++ {
+			item := rv.Index(i)
+			if item.Kind() == reflect.Ptr {
+				if item.IsNil() {
+					return &InvalidInputError{Type: item.Type()}
+				}
+				item = item.Elem()
+			}
+			items = append(items, item)
+		}
+	case reflect.Struct:
+		structType = rv.Type()
+		items = append(items, rv)
+	}
+
+	if structType == nil || structType.Kind() != reflect.Struct {
+		return &InvalidInputError{Type: rv.Type()}
+	}
+
+	getters, err := cachedStructGetter(structType, encoder.timeLayouts)
+	if err != nil {
+		return err
+	}
+
+	records := make([]map[string]encodedValue, 0, len(items))
+	for _, item := range items {
+		record, err := encoder.encodeRecord(item, getters)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+
+	if encoder.layoutErr != nil {
+		return encoder.layoutErr
+	}
+	if encoder.columns == nil {
+		encoder.computeColumns(getters, records)
+	}
+
+	if encoder.WriteHeaders && !encoder.AppendMode && !encoder.headersWritten {
+		headers, err := encoder.headerValues()
+		if err != nil {
+			return err
+		}
+		if err := encoder.writeRecord(headers, ""); err != nil {
+			return err
+		}
+		encoder.headersWritten = true
+	}
+
+	for _, record := range records {
+		if err := encoder.writeRecord(record, encoder.raw); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeRecord converts each field of item into text, keyed by column name.
+func (encoder *Encoder) encodeRecord(item reflect.Value, getters []fieldGetter) (map[string]encodedValue, error) {
+	record := make(map[string]encodedValue, len(getters))
+	for _, getter := range getters {
+		field := item.Field(getter.index)
+		if (field.Kind() == reflect.Ptr && field.IsNil()) || (getter.zeroNil && reflect.Indirect(field).IsZero()) {
+			record[getter.name] = encodedValue{value: encoder.NilFieldValue, field: getter.field, align: encoder.alignment(getter)}
+			continue
+		}
+		value, err := getter.getter(field, getter.field)
+		if err != nil {
+			return nil, err
+		}
+		record[getter.name] = encodedValue{value: value, field: getter.field, align: encoder.alignment(getter), numeric: getter.class == numberValue}
+	}
+	return record, nil
+}
+
+// alignment returns the alignment of the values of a field: that given by its align annotation or the
+// default for its type.
+func (encoder *Encoder) alignment(getter fieldGetter) Alignment {
+	switch {
+	case getter.aligned:
+		return getter.align
+	case getter.class == numberValue:
+		return encoder.DefaultNumericAlign
+	case getter.class == boolValue:
+		return AlignCenter
+	}
+	return encoder.DefaultStringAlign
+}
+
+// headerValues returns the names of the columns to be written as the header line. Each name must be followed
+// by at least one Padding character before the next column starts, so that the [Decoder] finds the columns at
+// the same offsets, unless it's the last. Names which don't fit are truncated if TruncateValues is set.
+func (encoder *Encoder) headerValues() (map[string]encodedValue, error) {
+	headers := make(map[string]encodedValue, len(encoder.columns))
+	for i, column := range encoder.columns {
+		width := column.to - column.from
+		if i < len(encoder.columns)-1 && encoder.columns[i+1].from-column.from-1 < width {
+			width = encoder.columns[i+1].from - column.from - 1
+		}
+		if width < 0 {
+			width = 0
+		}
+		name := []rune(column.name)
+		if len(name) > width {
+			if !encoder.TruncateValues {
+				return nil, &HeaderTooLongError{Column: column.name, Width: width}
+			}
+			name = name[:width]
+		}
+		headers[column.name] = encodedValue{value: string(name)}
+	}
+	return headers, nil
+}
+
+// computeColumns sets the layout so that each column is wide enough for its name and
+// every value in records.
+func (encoder *Encoder) computeColumns(getters []fieldGetter, records []map[string]encodedValue) {
+	encoder.columns = make([]encoderColumn, 0, len(getters))
+	from := 0
+	for i, getter := range getters {
+		width := utf8.RuneCountInString(getter.name)
+		if getter.minWidth > width {
+			width = getter.minWidth
+		}
+		for _, record := range records {
+			if n := utf8.RuneCountInString(record[getter.name].value); n > width {
+				width = n
+			}
+		}
+		encoder.columns = append(encoder.columns, encoderColumn{name: getter.name, from: from, to: from + width})
+		encoder.lineLength = from + width
+		if i < len(getters)-1 {
+			from += width + 1
+		}
+	}
+}
+
+// SetLayout sets the offsets, measured in runes, at which the columns are written, replacing the layout
+// computed from the first records. Each column is given as {from, to} and the fields are placed by name
+// regardless of the order in which they are declared. Every line is as long as the largest end offset and is
+// made up of Padding where no value is written. Fields without a column are not written, columns without a field
+// are left blank and where columns overlap the one starting later is written over the other. It must be called
+// before anything is written: once it has, the layout in use is kept and every later call to [Encoder.Encode]
+// returns [ErrEncodingStarted].
+//
+// The header line places each name at the start of its column. The [Decoder] finds the same offsets in it when
+// the columns follow one another without gaps, as a gap becomes part of the column before it, and the names don't
+// contain Padding. A column starting after the start of the line is preceded by a column with an empty name. Each
+// name other than the last must be followed by at least one Padding character before the next column starts, so
+// a [HeaderTooLongError] is returned for a name which is too long unless TruncateValues is set.
+func (encoder *Encoder) SetLayout(layout map[string][]int) {
+
+	if encoder.started() {
+		encoder.layoutErr = ErrEncodingStarted
+		return
+	}
+
+	encoder.columns = make([]encoderColumn, 0, len(layout))
+	encoder.lineLength = 0
+	if encoder.layoutErr = checkOffsets(layout); encoder.layoutErr != nil {
+		return
+	}
+
+	for name, offsets := range layout {
+		encoder.columns = append(encoder.columns, encoderColumn{name: name, from: offsets[0], to: offsets[1]})
+		if offsets[1] > encoder.lineLength {
+			encoder.lineLength = offsets[1]
+		}
+	}
+
+	sort.Slice(encoder.columns, func(i, j int) bool {
+		a, b := encoder.columns[i], encoder.columns[j]
+		if a.from != b.from {
+			return a.from < b.from
+		}
+		return a.name < b.name
+	})
+}
+
+// writeRecord places each value at the position of its column and writes the line. When PreserveRaw is
+// set the line starts as raw, if it isn't empty, and the columns with values are cleared first.
+func (encoder *Encoder) writeRecord(record map[string]encodedValue, raw string) error {
+
+	line := make([]rune, encoder.lineLength)
+	for i := range line {
+		line[i] = encoder.Padding
+	}
+	preserve := encoder.PreserveRaw && raw != ""
+	if preserve {
+		if runes := []rune(raw); len(runes) > len(line) {
+			line = runes
+		} else {
+			copy(line, runes)
+		}
+	}
+
+	for _, column := range encoder.columns {
+		value, ok := record[column.name]
+		if !ok {
+			continue
+		}
+		width := column.to - column.from
+		runes := []rune(value.value)
+		if len(runes) > width {
+			if !encoder.TruncateValues || value.numeric {
+				return &ValueTooLongError{Value: value.value, Field: value.field, Width: width}
+			}
+			runes = runes[:width]
+		}
+		if preserve {
+			for i := column.from; i < column.to; i++ {
+				line[i] = encoder.Padding
+			}
+		}
+		offset := 0
+		switch value.align {
+		case AlignRight:
+			offset = width - len(runes)
+		case AlignCenter:
+			offset = (width - len(runes)) / 2
+		}
+		copy(line[column.from+offset:], runes)
+	}
+
+	if !encoder.TrailingTerminator && (encoder.recordsWritten || encoder.AppendMode) {
+		if _, err := encoder.w.Write(encoder.RecordTerminator); err != nil {
+			return err
+		}
+	}
+	encoder.recordsWritten = true
+
+	if _, err := io.WriteString(encoder.w, string(line)); err != nil {
+		return err
+	}
+	if !encoder.TrailingTerminator {
+		return nil
+	}
+	_, err := encoder.w.Write(encoder.RecordTerminator)
+	return err
+}
+
+// A RecordSet holds records which are written in fixed width form by [RecordSet.WriteTo], so that
+// it can be used wherever an [io.WriterTo] is accepted.
+type RecordSet struct {
+	v interface{}
+}
+
+// NewRecordSet returns a RecordSet for v, which can be anything accepted by [Marshal].
+func NewRecordSet(v interface{}) *RecordSet {
+	return &RecordSet{v: v}
+}
+
+// WriteTo writes the header line and the records to w as [Marshal] would and returns the number
+// of bytes written. Every value is converted before anything is written, so an error converting a
+// value means that nothing is written. If w returns an error the output written so far is left in
+// place and the number of bytes it accepted is returned with the error.
+func (rs *RecordSet) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	if err := NewEncoder(bw).Encode(rs.v); err != nil {
+		return cw.n, err
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,784 @@
+package fw
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type EncodePerson struct {
+	Name     string
+	Postcode int
+	Limit    *float64 `column:"CreditLimit"`
+	Active   bool     `minwidth:"8"`
+	Size     DataSize
+	internal string
+}
+
+func (datasize DataSize) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%g%s", datasize.Value, datasize.Units)), nil
+}
+
+func TestMarshal(t *testing.T) {
+
+	limit := 1000000.5
+	people := []EncodePerson{
+		{Name: "Evan Whitehouse", Postcode: 3122, Limit: &limit, Active: true, Size: DataSize{Value: 20.5, Units: "mb"}},
+		{Name: "Chuck Norris", Postcode: 77868, internal: "hidden"},
+	}
+
+	expected := "Name            Postcode CreditLimit Active   Size  \n" +
+		"Evan Whitehouse     3122   1000000.5   true   20.5mb\n" +
+		"Chuck Norris       77868              false   0     \n"
+
+	obtained, err := Marshal(people)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, string(obtained))
+
+	t.Run("round trip", func(t *testing.T) {
+		decoded := EncodePerson{}
+		err := Unmarshal(obtained, &decoded)
+		assert.Nil(t, err)
+		assert.Equal(t, people[0], decoded)
+	})
+
+	t.Run("struct", func(t *testing.T) {
+		obtained, err := Marshal(&people[1])
+		assert.Nil(t, err)
+		assert.Equal(t, "Name         Postcode CreditLimit Active   Size\nChuck Norris    77868              false   0   \n", string(obtained))
+	})
+}
+
+func TestEncoderLayoutKept(t *testing.T) {
+
+	type S struct {
+		Name string
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := NewEncoder(buf)
+
+	err := encoder.Encode([]S{{Name: "Peter"}})
+	assert.Nil(t, err)
+
+	err = encoder.Encode(S{Name: "Nicki"})
+	assert.Nil(t, err)
+
+	err = encoder.Encode(S{Name: "Christopher"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), `value "Christopher" for field "Name" is longer than the column width 5`)
+
+	assert.Equal(t, "Name \nPeter\nNicki\n", buf.String())
+}
+
+func TestEncoderBadInputs(t *testing.T) {
+
+	type B struct {
+		Values []int
+	}
+
+	_, err := Marshal(nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "input value is not a non-nil pointer to slice of structs or a pointer to a struct")
+
+	_, err = Marshal([]int{1})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "input value is not a non-nil pointer to slice of structs or a pointer to a struct")
+
+	_, err = Marshal([]*EncodePerson{nil})
+	assert.NotNil(t, err)
+
+	_, err = Marshal([]B{{}})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), `unable to create a converter for field "Values"`)
+}
+
+type Status int
+
+func (status Status) String() string {
+	switch status {
+	case 1:
+		return "active"
+	case 2:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+type PointerStatus int
+
+func (status *PointerStatus) String() string {
+	return fmt.Sprintf("status-%d", int(*status))
+}
+
+func TestMarshalStringer(t *testing.T) {
+
+	type S struct {
+		Status  Status         `format:"stringer"`
+		Numeric Status         // no annotation so the number is written
+		PStatus *Status        `format:"stringer"`
+		Pointer PointerStatus  `format:"stringer"`
+		Plain   int            `format:"stringer"`
+		Nil     *PointerStatus `format:"stringer"`
+	}
+
+	closed := Status(2)
+	obtained, err := Marshal(S{Status: 1, Numeric: 1, PStatus: &closed, Pointer: 7, Plain: 3})
+	assert.Nil(t, err)
+	assert.Equal(t, "Status Numeric PStatus Pointer  Plain Nil\nactive       1 closed  status-7     3    \n", string(obtained))
+}
+
+func TestMarshalNilFields(t *testing.T) {
+
+	type N struct {
+		Name  *string
+		Count *int
+	}
+
+	name := "Peter"
+	records := []N{{Name: &name}, {}}
+
+	t.Run("default", func(t *testing.T) {
+		obtained, err := Marshal(records)
+		assert.Nil(t, err)
+		assert.Equal(t, "Name  Count\nPeter      \n           \n", string(obtained))
+	})
+
+	t.Run("token", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		encoder := NewEncoder(buf)
+		encoder.NilFieldValue = "NULL"
+		err := encoder.Encode(records)
+		assert.Nil(t, err)
+		assert.Equal(t, "Name  Count\nPeter  NULL\nNULL   NULL\n", buf.String())
+	})
+}
+
+func TestMarshalNumberFormat(t *testing.T) {
+
+	type F struct {
+		Amount  float64  `format:"%09.2f"`
+		Count   int      `format:"%05d"`
+		Hex     uint16   `format:"%04X"`
+		PAmount *float32 `format:"%.1f"`
+		Status  Status   `format:"%03d"`
+	}
+
+	amount := float32(2.25)
+	obtained, err := Marshal(F{Amount: -12.345, Count: 42, Hex: 255, PAmount: &amount, Status: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, "Amount    Count Hex  PAmount Status\n-00012.35 00042 00FF     2.2    002\n", string(obtained))
+
+	t.Run("invalid", func(t *testing.T) {
+		type B struct {
+			Count int `format:"%s"`
+		}
+		_, err := Marshal(B{})
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), `invalid format annotation "%s" for field "Count"`)
+	})
+
+	t.Run("too long", func(t *testing.T) {
+		type S struct {
+			Count int `format:"%05d"`
+		}
+		buf := &bytes.Buffer{}
+		encoder := NewEncoder(buf)
+		assert.Nil(t, encoder.Encode(S{Count: 1}))
+		err := encoder.Encode(S{Count: 123456})
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), `value "123456" for field "Count" is longer than the column width 5`)
+	})
+}
+
+func TestEncoderTrailingTerminator(t *testing.T) {
+
+	type S struct {
+		Name string
+		Code int
+	}
+
+	records := []S{{Name: "Peter", Code: 1}, {Name: "Nicki", Code: 22}}
+
+	tests := []struct {
+		trailing bool
+		expected string
+	}{
+		{trailing: true, expected: "Name  Code\r\nPeter    1\r\nNicki   22\r\n"},
+		{trailing: false, expected: "Name  Code\r\nPeter    1\r\nNicki   22"},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.trailing), func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			encoder := NewEncoder(buf)
+			encoder.RecordTerminator = []byte("\r\n")
+			encoder.TrailingTerminator = test.trailing
+			assert.Nil(t, encoder.Encode(records[:1]))
+			assert.Nil(t, encoder.Encode(records[1]))
+			assert.Equal(t, test.expected, buf.String())
+
+			decoded := []S{}
+			decoder := NewDecoder(buf)
+			decoder.RecordTerminator = []byte("\r\n")
+			assert.Nil(t, decoder.Decode(&decoded))
+			assert.Equal(t, records, decoded)
+		})
+	}
+}
+
+// failingWriter accepts limit bytes and then fails.
+type failingWriter struct {
+	limit int
+}
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > fw.limit {
+		n := fw.limit
+		fw.limit = 0
+		return n, io.ErrShortWrite
+	}
+	fw.limit -= len(p)
+	return len(p), nil
+}
+
+func TestRecordSetWriteTo(t *testing.T) {
+
+	type S struct {
+		Name string
+		Code int
+	}
+
+	records := []S{{Name: "Peter", Code: 1}, {Name: "Nicki", Code: 22}}
+	expected, err := Marshal(records)
+	assert.Nil(t, err)
+
+	var writerTo io.WriterTo = NewRecordSet(records)
+	buf := &bytes.Buffer{}
+	n, err := writerTo.WriteTo(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(expected)), n)
+	assert.Equal(t, string(expected), buf.String())
+
+	n, err = NewRecordSet(records).WriteTo(&failingWriter{limit: 7})
+	assert.Equal(t, io.ErrShortWrite, err)
+	assert.Equal(t, int64(7), n)
+
+	buf.Reset()
+	n, err = NewRecordSet([]int{1}).WriteTo(buf)
+	assert.IsType(t, &InvalidInputError{}, err)
+	assert.Equal(t, int64(0), n)
+	assert.Empty(t, buf.String())
+}
+
+func TestReflow(t *testing.T) {
+
+	t.Run("header", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		in := strings.NewReader("Name  Code Extra\nPeter 1    x    \nNicki 22   y    ")
+		err := Reflow(in, out, nil, map[string][]int{"Name": {0, 10}, "Code": {10, 14}, "New": {15, 18}})
+		assert.Nil(t, err)
+		assert.Equal(t, "Name      Code New\nPeter     1       \nNicki     22      \n", out.String())
+
+		decoded := []struct {
+			Name string
+			Code int
+		}{}
+		assert.Nil(t, Unmarshal(out.Bytes(), &decoded))
+		assert.Len(t, decoded, 2)
+	})
+
+	t.Run("explicit", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		in := strings.NewReader("Peter 1   \nNicki 22  ")
+		err := Reflow(in, out, map[string][]int{"Name": {0, 6}, "Code": {6, 10}}, map[string][]int{"Code": {0, 3}, "Name": {3, 9}})
+		assert.Nil(t, err)
+		assert.Equal(t, "1  Peter \n22 Nicki \n", out.String())
+	})
+
+	t.Run("too narrow", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		in := strings.NewReader("Peter      \nChristopher")
+		err := Reflow(in, out, map[string][]int{"Name": {0, 11}}, map[string][]int{"Name": {0, 5}})
+		if assert.IsType(t, &ValueTooLongError{}, err) {
+			assert.Equal(t, "Name", err.(*ValueTooLongError).Field.Name)
+		}
+		assert.Equal(t, "Peter\n", out.String())
+	})
+
+	t.Run("invalid layout", func(t *testing.T) {
+		for _, offsets := range [][]int{{-1, 5}, {5}} {
+			out := &bytes.Buffer{}
+			in := strings.NewReader("Name  \nPeter \n")
+			err := Reflow(in, out, nil, map[string][]int{"Name": offsets})
+			assert.Equal(t, &InvalidOffsetsError{Column: "Name", Offsets: offsets}, err)
+			assert.Equal(t, 14, in.Len())
+			assert.Empty(t, out.String())
+		}
+	})
+}
+
+func TestEncoderGzip(t *testing.T) {
+
+	type P struct {
+		Name string
+		Code int
+	}
+	records := []P{{"Peter", 1}, {"Nicki", 22}}
+	plain, err := Marshal(records)
+	assert.Nil(t, err)
+
+	buf := &bytes.Buffer{}
+	encoder := NewEncoder(buf)
+	assert.Nil(t, encoder.SetGzip(true))
+	assert.Nil(t, encoder.Encode(records))
+	assert.Nil(t, encoder.Flush())
+	assert.Nil(t, encoder.Close())
+	assert.NotEqual(t, plain, buf.Bytes())
+
+	decoder := NewDecoder(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, decoder.SetGzip(true))
+	decoded := []P{}
+	assert.Nil(t, decoder.Decode(&decoded))
+	assert.Equal(t, records, decoded)
+
+	// Turning compression off again writes to the original writer.
+	buf.Reset()
+	encoder = NewEncoder(buf)
+	assert.Nil(t, encoder.SetGzip(true))
+	assert.Nil(t, encoder.SetGzip(false))
+	assert.Nil(t, encoder.Encode(records))
+	assert.Nil(t, encoder.Close())
+	assert.Equal(t, plain, buf.Bytes())
+
+	// Compression can't be changed once something has been written.
+	buf.Reset()
+	encoder = NewEncoder(buf)
+	assert.Nil(t, encoder.SetGzip(true))
+	assert.Nil(t, encoder.Encode(records[:1]))
+	assert.Equal(t, ErrEncodingStarted, encoder.SetGzip(false))
+	assert.Nil(t, encoder.Encode(records[1:]))
+	assert.Nil(t, encoder.Close())
+	decoder = NewDecoder(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, decoder.SetGzip(true))
+	decoded = []P{}
+	assert.Nil(t, decoder.Decode(&decoded))
+	assert.Equal(t, records, decoded)
+
+	buf.Reset()
+	encoder = NewEncoder(buf)
+	assert.Nil(t, encoder.Encode(records))
+	assert.Equal(t, ErrEncodingStarted, encoder.SetGzip(true))
+	assert.Equal(t, plain, buf.Bytes())
+}
+
+func TestEncoderTimeLayouts(t *testing.T) {
+
+	type R struct {
+		Created time.Time `format:"@iso"`
+		Week    time.Time `format:"@week"`
+	}
+	records := []R{{time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC), time.Date(2024, time.January, 31, 0, 0, 0, 0, time.UTC)}}
+
+	// A layout which has not been registered is an error rather than being written as it is.
+	_, err := Marshal(records)
+	if assert.IsType(t, &InvalidTagError{}, err) {
+		assert.Equal(t, "Created", err.(*InvalidTagError).Field.Name)
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := NewEncoder(buf)
+	encoder.RegisterTimeLayout("iso", "2006-01-02")
+	encoder.RegisterTimeLayout("week", "isoweek")
+	assert.Nil(t, encoder.Encode(records))
+	assert.Equal(t, "Created    Week      \n2024-02-29 2024-W05-3\n", buf.String())
+
+	decoder := NewDecoder(bytes.NewReader(buf.Bytes()))
+	decoder.RegisterTimeLayout("iso", "2006-01-02")
+	decoder.RegisterTimeLayout("week", "isoweek")
+	decoded := []R{}
+	assert.Nil(t, decoder.Decode(&decoded))
+	assert.Equal(t, records, decoded)
+
+	// Encoders with different layouts don't share the conversion of the type.
+	buf.Reset()
+	encoder = NewEncoder(buf)
+	encoder.RegisterTimeLayout("iso", "02/01/2006")
+	encoder.RegisterTimeLayout("week", "ordinal")
+	assert.Nil(t, encoder.Encode(records))
+	assert.Equal(t, "Created    Week    \n29/02/2024 2024-031\n", buf.String())
+}
+
+func TestMarshalTime(t *testing.T) {
+
+	type Person struct {
+		Name        string
+		Address     string
+		Postcode    int
+		Phone       string
+		CreditLimit float64   `column:"CreditLimit"`
+		Bday        time.Time `column:"Birthday" format:"20060102"`
+	}
+
+	people := []Person{
+		{Name: "Evan Whitehouse", Address: "V4560 Camel Back Road", Postcode: 3122, Phone: "(918) 605-5383", CreditLimit: 1000000.5, Bday: time.Date(1987, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "Chuck Norris", Address: "P.O. Box 872", Postcode: 77868, Phone: "(713) 868-6003", CreditLimit: 10909300, Bday: time.Date(1965, 12, 3, 0, 0, 0, 0, time.UTC)},
+	}
+
+	expected := "Name            Address               Postcode Phone          CreditLimit Birthday\n" +
+		"Evan Whitehouse V4560 Camel Back Road     3122 (918) 605-5383   1000000.5 19870101\n" +
+		"Chuck Norris    P.O. Box 872             77868 (713) 868-6003    10909300 19651203\n"
+
+	obtained, err := Marshal(people)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, string(obtained))
+
+	decoded := []Person{}
+	assert.Nil(t, Unmarshal(obtained, &decoded))
+	assert.Equal(t, people, decoded)
+
+	type T struct {
+		Default time.Time
+		Week    time.Time  `format:"isoweek"`
+		Day     *time.Time `format:"ordinal"`
+		Epoch   time.Time  `format:"unix"`
+	}
+
+	when := time.Date(2024, 2, 14, 10, 30, 0, 0, time.UTC)
+	buf := &bytes.Buffer{}
+	encoder := NewEncoder(buf)
+	encoder.NilFieldValue = "-"
+	assert.Nil(t, encoder.Encode([]T{{Default: when, Week: when, Day: &when, Epoch: when}, {Day: &time.Time{}}}))
+	assert.Equal(t, "Default              Week       Day      Epoch     \n"+
+		"2024-02-14T10:30:00Z 2024-W07-3 2024-045 1707906600\n"+
+		"-                    -          -        -         \n", buf.String())
+}
+
+func TestEncoderAlignment(t *testing.T) {
+
+	type A struct {
+		Name   string
+		Count  int
+		Amount *float64
+		Active bool
+		Code   int    `align:"left"`
+		Label  string `align:"right"`
+		Flag   bool   `align:"right"`
+	}
+
+	amount := 2.5
+	records := []A{
+		{Name: "Peter", Count: 1, Amount: &amount, Active: true, Code: 7, Label: "x", Flag: true},
+		{Name: "Al", Count: 123456},
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := NewEncoder(buf)
+	encoder.NilFieldValue = "-"
+	assert.Nil(t, encoder.Encode(records))
+	assert.Equal(t, "Name  Count  Amount Active Code Label Flag \n"+
+		"Peter      1    2.5  true  7        x  true\n"+
+		"Al    123456      - false  0          false\n", buf.String())
+
+	// The output reads back whatever the alignment.
+	records[1].Amount = &amount
+	obtained, err := Marshal(records)
+	assert.Nil(t, err)
+	decoded := []A{}
+	assert.Nil(t, Unmarshal(obtained, &decoded))
+	assert.Equal(t, records, decoded)
+
+	buf.Reset()
+	encoder = NewEncoder(buf)
+	encoder.DefaultNumericAlign = AlignLeft
+	encoder.DefaultStringAlign = AlignCenter
+	records[1].Amount = nil
+	assert.Nil(t, encoder.Encode(records[1]))
+	assert.Equal(t, "Name Count  Amount Active Code Label Flag \n Al  123456        false  0          false\n", buf.String())
+
+	type B struct {
+		Name string `align:"middle"`
+	}
+	_, err = Marshal(B{})
+	assert.IsType(t, &InvalidTagError{}, err)
+}
+
+func TestMarshalBytes(t *testing.T) {
+
+	type B struct {
+		Raw    []byte
+		Hex    []byte `encoding:"hex"`
+		Base64 []byte `encoding:"base64"`
+	}
+
+	records := []B{{Raw: []byte("ab c"), Hex: []byte{0x00, 0xff, 0x10, 0xab}, Base64: []byte("hi??>")}, {Raw: []byte("x")}}
+	expected := "Raw  Hex      Base64  \n" +
+		"ab c 00ff10ab aGk/Pz4=\n" +
+		"x                     \n"
+
+	obtained, err := Marshal(records)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, string(obtained))
+
+	decoded := []B{}
+	assert.Nil(t, Unmarshal(obtained, &decoded))
+	assert.Equal(t, []B{records[0], {Raw: []byte("x"), Hex: []byte{}, Base64: []byte{}}}, decoded)
+
+	type BadEncoding struct {
+		Hex []byte `encoding:"base32"`
+	}
+	_, err = Marshal([]BadEncoding{{}})
+	assert.IsType(t, &InvalidTagError{}, err)
+	_, err = Marshal([]struct{ Raw *[]byte }{{}})
+	assert.IsType(t, &InvalidTypeError{}, err)
+}
+
+func TestMarshalBoolText(t *testing.T) {
+
+	type B struct {
+		Name    string
+		Plain   bool
+		Letter  bool  `true:"Y" false:"N"`
+		Word    bool  `true:"Yes" false:"No"`
+		Digit   *bool `true:"1" false:"0"`
+		Checked bool  `true:"X" false:""`
+	}
+
+	yes, no := true, false
+	records := []B{
+		{Name: "Peter", Plain: true, Letter: true, Word: true, Digit: &yes, Checked: true},
+		{Name: "Nicki", Digit: &no},
+	}
+
+	expected := "Name  Plain Letter Word Digit Checked\n" +
+		"Peter true    Y    Yes    1      X   \n" +
+		"Nicki false   N     No    0          \n"
+
+	obtained, err := Marshal(records)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, string(obtained))
+
+	decoded := []B{}
+	assert.Nil(t, Unmarshal(obtained, &decoded))
+	assert.Equal(t, records, decoded)
+
+	again, err := Marshal(decoded)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, string(again))
+
+	// The values accepted by default are still decoded.
+	decoded = []B{}
+	assert.Nil(t, Unmarshal([]byte("Name  Plain Letter Word Digit Checked\nPeter yes   true   1    true  X      "), &decoded))
+	assert.Equal(t, B{Name: "Peter", Plain: true, Letter: true, Word: true, Digit: &yes, Checked: true}, decoded[0])
+}
+
+func TestEncoderSetLayout(t *testing.T) {
+
+	type S struct {
+		Name   string
+		Code   int
+		Notes  string
+		Hidden string
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := NewEncoder(buf)
+	encoder.SetLayout(map[string][]int{"Code": {0, 4}, "Name": {6, 12}, "Notes": {14, 20}, "Spare": {22, 28}})
+	err := encoder.Encode([]S{{Name: "Peter", Code: 12, Notes: "hi", Hidden: "x"}, {Name: "Paul", Code: 7}})
+	assert.Nil(t, err)
+	assert.Equal(t, "Code  Name    Notes   Spare \n  12  Peter   hi            \n   7  Paul                  \n", buf.String())
+
+	// The output can be read back with the same offsets.
+	decoder := NewDecoder(strings.NewReader(buf.String()))
+	decoder.SetHeaders(map[string][]int{"Code": {0, 4}, "Name": {6, 12}, "Notes": {14, 20}, "Spare": {22, 28}})
+	decoder.SkipFirstRecord = true
+	obtained := []S{}
+	assert.Nil(t, decoder.Decode(&obtained))
+	assert.Equal(t, []S{{Name: "Peter", Code: 12, Notes: "hi"}, {Name: "Paul", Code: 7}}, obtained)
+
+	// The layout can't be changed once the header line has been written.
+	written := buf.String()
+	encoder.SetLayout(map[string][]int{"Name": {0, 6}})
+	assert.Equal(t, ErrEncodingStarted, encoder.Encode(S{Name: "Nicki"}))
+	assert.Equal(t, written, buf.String())
+
+	// Values which don't fit are an error unless TruncateValues is set.
+	buf.Reset()
+	encoder = NewEncoder(buf)
+	encoder.WriteHeaders = false
+	encoder.SetLayout(map[string][]int{"Name": {0, 3}})
+	err = encoder.Encode(S{Name: "Peter"})
+	assert.IsType(t, &ValueTooLongError{}, err)
+
+	encoder.TruncateValues = true
+	assert.Nil(t, encoder.Encode(S{Name: "Peter"}))
+	assert.Equal(t, "Pet\n", buf.String())
+
+	// Numbers are never truncated.
+	buf.Reset()
+	encoder = NewEncoder(buf)
+	encoder.WriteHeaders = false
+	encoder.TruncateValues = true
+	encoder.SetLayout(map[string][]int{"Code": {0, 3}})
+	err = encoder.Encode(S{Code: 12345})
+	if assert.IsType(t, &ValueTooLongError{}, err) {
+		assert.Equal(t, "12345", err.(*ValueTooLongError).Value)
+		assert.Equal(t, 3, err.(*ValueTooLongError).Width)
+	}
+	assert.Empty(t, buf.String())
+
+	// Overlapping columns are written in order of their start.
+	buf.Reset()
+	encoder = NewEncoder(buf)
+	encoder.WriteHeaders = false
+	encoder.SetLayout(map[string][]int{"Name": {0, 5}, "Code": {3, 5}})
+	assert.Nil(t, encoder.Encode(S{Name: "Peter", Code: 42}))
+	assert.Equal(t, "Pet42\n", buf.String())
+
+	encoder = NewEncoder(buf)
+	encoder.SetLayout(map[string][]int{"Name": {5, 2}})
+	assert.NotNil(t, encoder.Encode(S{Name: "Peter"}))
+}
+
+func TestEncoderLayoutHeaders(t *testing.T) {
+
+	type S struct {
+		ID     int
+		Name   string
+		Amount float64
+	}
+	layout := map[string][]int{"ID": {0, 4}, "Name": {4, 12}, "Amount": {12, 20}}
+
+	buf := &bytes.Buffer{}
+	encoder := NewEncoder(buf)
+	encoder.SetLayout(layout)
+	assert.Nil(t, encoder.Encode(S{ID: 1, Name: "Peter", Amount: 2.5}))
+	assert.Equal(t, "ID  Name    Amount  \n   1Peter        2.5\n", buf.String())
+
+	// The header line gives back the offsets it was written with.
+	headers, err := NewDecoder(strings.NewReader(buf.String())).DecodeHeaderOnly()
+	assert.Nil(t, err)
+	assert.Equal(t, layout, headers)
+
+	// A value which fills its column exactly is written in full, even next to a column which starts where
+	// it ends.
+	buf.Reset()
+	encoder = NewEncoder(buf)
+	encoder.SetLayout(layout)
+	assert.Nil(t, encoder.Encode(S{ID: 1234, Name: "Peter", Amount: 2.5}))
+	assert.Equal(t, "ID  Name    Amount  \n1234Peter        2.5\n", buf.String())
+
+	type T struct {
+		A int
+		B string
+	}
+	buf.Reset()
+	encoder = NewEncoder(buf)
+	encoder.WriteHeaders = false
+	encoder.SetLayout(map[string][]int{"A": {0, 3}, "B": {3, 6}})
+	assert.Nil(t, encoder.Encode(T{A: 123, B: "xyz"}))
+	encoder.TruncateValues = true
+	assert.Nil(t, encoder.Encode(T{A: 123, B: "xyz"}))
+	assert.Equal(t, "123xyz\n123xyz\n", buf.String())
+
+	// A name must leave a padding character before the next column.
+	buf.Reset()
+	encoder = NewEncoder(buf)
+	encoder.SetLayout(map[string][]int{"ID": {0, 2}, "Name": {2, 8}})
+	err = encoder.Encode(S{ID: 1, Name: "Peter"})
+	assert.Equal(t, &HeaderTooLongError{Column: "ID", Width: 1}, err)
+
+	encoder.TruncateValues = true
+	assert.Nil(t, encoder.Encode(S{ID: 1, Name: "Peter"}))
+	assert.Equal(t, "I Name  \n 1Peter \n", buf.String())
+}
+
+func TestEncoderAppendMode(t *testing.T) {
+
+	type S struct {
+		Name string
+		Code int
+	}
+	layout := map[string][]int{"Name": {0, 6}, "Code": {6, 10}}
+
+	buf := &bytes.Buffer{}
+	encoder := NewEncoder(buf)
+	encoder.SetLayout(layout)
+	assert.Nil(t, encoder.Encode([]S{{"Peter", 1}, {"Paul", 2}}))
+
+	// A second encoder adds to the same output without another header line.
+	encoder = NewEncoder(buf)
+	encoder.SetLayout(layout)
+	encoder.AppendMode = true
+	assert.Nil(t, encoder.Encode(S{"Mary", 3}))
+	assert.Equal(t, "Name  Code\nPeter    1\nPaul     2\nMary     3\n", buf.String())
+
+	obtained := []S{}
+	assert.Nil(t, Unmarshal(buf.Bytes(), &obtained))
+	assert.Equal(t, []S{{"Peter", 1}, {"Paul", 2}, {"Mary", 3}}, obtained)
+
+	// Output without a trailing terminator is continued on a new line.
+	buf.Reset()
+	encoder = NewEncoder(buf)
+	encoder.SetLayout(layout)
+	encoder.TrailingTerminator = false
+	assert.Nil(t, encoder.Encode(S{"Peter", 1}))
+
+	encoder = NewEncoder(buf)
+	encoder.SetLayout(layout)
+	encoder.TrailingTerminator = false
+	encoder.AppendMode = true
+	assert.Nil(t, encoder.Encode([]S{{"Paul", 2}, {"Mary", 3}}))
+	assert.Equal(t, "Name  Code\nPeter    1\nPaul     2\nMary     3", buf.String())
+}
+
+func TestTransform(t *testing.T) {
+
+	type S struct {
+		Name string
+		Code int
+	}
+
+	input := "Name  Code Notes\nPeter 1    hi   \nPaul  2    yo   \n"
+	double := func(record interface{}) error {
+		record.(*S).Code *= 10
+		return nil
+	}
+
+	out := &bytes.Buffer{}
+	assert.Nil(t, Transform(strings.NewReader(input), out, S{}, double))
+	assert.Equal(t, "Name  Code Notes\nPeter    10     \nPaul     20     \n", out.String())
+
+	// Columns without a field keep their content with PreserveRaw.
+	out.Reset()
+	encoder := NewEncoder(out)
+	encoder.PreserveRaw = true
+	assert.Nil(t, NewDecoder(strings.NewReader(input)).Transform(encoder, &S{}, double))
+	assert.Equal(t, "Name  Code Notes\nPeter    10hi   \nPaul     20yo   \n", out.String())
+
+	// Errors from the callback stop the transform after the records already written.
+	out.Reset()
+	stop := fmt.Errorf("stop")
+	err := Transform(strings.NewReader(input), out, S{}, func(record interface{}) error {
+		if record.(*S).Name == "Paul" {
+			return stop
+		}
+		return nil
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, "Name  Code Notes\nPeter     1     \n", out.String())
+
+	assert.IsType(t, &InvalidInputError{}, Transform(strings.NewReader(input), out, 1, double))
+	assert.Nil(t, Transform(strings.NewReader(""), out, S{}, double))
+
+	decoder := NewDecoder(strings.NewReader("Name,Code\nPeter,1\n"))
+	decoder.Delimited = true
+	decoder.FieldSeparator = ","
+	assert.Equal(t, ErrDelimitedTransform, decoder.Transform(NewEncoder(out), S{}, double))
+}
@@ -1,10 +1,61 @@
 package fw
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"reflect"
 )
 
+// ErrNoMappedFields is returned when [Decoder.RequireMappedFields] is set and none of the
+// fields of a struct match a column.
+var ErrNoMappedFields = errors.New("no fields are mapped to columns")
+
+// ErrRecordTooShort and ErrRecordTooLong are wrapped by an [InvalidLengthError] to tell a record which is
+// shorter than the headers, such as a truncated one, from one which is longer, such as two records run
+// together. Use [errors.Is] to check for them.
+var (
+	ErrRecordTooShort = errors.New("record is too short")
+	ErrRecordTooLong  = errors.New("record is too long")
+)
+
+// ErrDelimitedTransform is returned by [Decoder.Transform] when [Decoder.Delimited] is set, as the records
+// can't be written back at the offsets they were read from.
+var ErrDelimitedTransform = errors.New("transform can't be used with delimited records")
+
+// ErrNoHeaders is returned by [RecordReader.ReadRecord] when the column offsets have not been given
+// with [RecordReader.SetHeaders].
+var ErrNoHeaders = errors.New("headers have not been set")
+
+// ErrInvalidRecordLength is returned by [RecordReader.ReadRecord] when the record length given to
+// [NewRecordReader] is not positive.
+var ErrInvalidRecordLength = errors.New("record length must be positive")
+
+// ErrInvalidRecordNumber is wrapped by the error returned by [RecordReader.ReadRecord] for a negative
+// record number.
+var ErrInvalidRecordNumber = errors.New("invalid record number")
+
+// ErrNoStartMarker is returned by [Decoder.NextSection] when [Decoder.StartMarker] is not set.
+var ErrNoStartMarker = errors.New("NextSection requires a StartMarker")
+
+// ErrNoRecordTerminator is returned when [Decoder.RecordTerminator] is empty and [Decoder.FixedRecordLength] is
+// not set, as there is then no way to split the input into records.
+var ErrNoRecordTerminator = errors.New("RecordTerminator is empty")
+
+// ErrDecodingStarted is returned by [Decoder.SetMaxRecordSize] and [Decoder.SetGzip] once the decoder has read
+// from its input.
+var ErrDecodingStarted = errors.New("decoding has already started")
+
+// ErrEncodingStarted is returned by [Encoder.SetGzip], and by [Encoder.Encode] after [Encoder.SetLayout], once
+// the encoder has written to its output.
+var ErrEncodingStarted = errors.New("encoding has already started")
+
+// ErrNoChecksumColumn is returned when the column given to [Decoder.RegisterChecksum] is not in the headers.
+var ErrNoChecksumColumn = errors.New("checksum column is not in the headers")
+
+// ErrRuledDelimited is returned when both [Decoder.Ruled] and [Decoder.Delimited] are set.
+var ErrRuledDelimited = errors.New("Ruled can't be combined with Delimited")
+
 // An InvalidUnmarshalError describes an invalid argument passed to Unmarshal.
 // (The argument to Unmarshal must be a non-nil pointer.)
 type InvalidUnmarshalError struct {
@@ -23,20 +74,29 @@ func (err *InvalidUnmarshalError) Error() string {
 }
 
 // An InvalidLengthError describes the state of decoding when a data record
-// does not have the same length as the headers indicated.
+// does not have the same length as the headers indicated. Length is measured in
+// the same way as HeadersLength. It wraps [ErrRecordTooShort] or [ErrRecordTooLong].
 type InvalidLengthError struct {
 	Headers       map[string][]int
 	Line          string
 	LineNum       int
+	Length        int
 	HeadersLength int
 }
 
 func (err *InvalidLengthError) Error() string {
 	return fmt.Sprintf("wrong data length in line %d (%d != %d)",
-		err.LineNum, len(err.Line), err.HeadersLength)
+		err.LineNum, err.Length, err.HeadersLength)
 
 }
 
+func (err *InvalidLengthError) Unwrap() error {
+	if err.Length < err.HeadersLength {
+		return ErrRecordTooShort
+	}
+	return ErrRecordTooLong
+}
+
 // An InvalidInputError is returned when the input to Decode is not
 // usable
 type InvalidInputError struct {
@@ -59,21 +119,293 @@ func (err *InvalidTypeError) Error() string {
 	return fmt.Sprintf(`unable to create a converter for field "%s" for type "%v"`, err.Field.Name, err.Field.Type)
 }
 
+// A CastingError is returned when the value of a column can't be converted to the type of its field.
+// From and To are the offsets of the column in the record, in the units of the decoder's WidthMode and
+// limited to the length of the record, and Raw is its content before it was trimmed. For delimited
+// records From is the position of the column and in a nested layout the offsets are within the enclosing
+// column. They are not set for fields made by joining columns.
 type CastingError struct {
 	Value string
 	Err   error
 	Field reflect.StructField
+	From  int
+	To    int
+	Raw   string
 }
 
 func (err *CastingError) Error() string {
 	return fmt.Sprintf(`failed casting "%s" to "%s:%v": %+v`, err.Value, err.Field.Name, err.Field.Type, err.Err)
 }
 
+// An OverflowError is returned when a number is out of the range of the field it is decoded into.
+// Kind is the kind of number (after any pointers) and Limit is the bound which was exceeded: the
+// maximum value or, for negative values, the minimum.
 type OverflowError struct {
 	Value interface{}
 	Field reflect.StructField
+	Kind  reflect.Kind
+	Limit interface{}
 }
 
 func (err *OverflowError) Error() string {
-	return fmt.Sprintf(`value %v is too big for field %s:%v`, err.Value, err.Field.Name, err.Field.Type)
+	msg := fmt.Sprintf(`value %v is too big for field %s:%v`, err.Value, err.Field.Name, err.Field.Type)
+	if err.Limit == nil {
+		return msg
+	}
+	bound := "max"
+	switch limit := err.Limit.(type) {
+	case int64:
+		if limit < 0 {
+			bound = "min"
+		}
+	case float64:
+		if limit < 0 {
+			bound = "min"
+		}
+	}
+	return fmt.Sprintf("%s (%s %v)", msg, bound, err.Limit)
+}
+
+// A NegativeUnsignedError is returned when a negative number is decoded into an unsigned field.
+type NegativeUnsignedError struct {
+	Value string
+	Field reflect.StructField
+}
+
+func (err *NegativeUnsignedError) Error() string {
+	return fmt.Sprintf(`negative value "%s" not allowed for unsigned field %s:%v`, err.Value, err.Field.Name, err.Field.Type)
+}
+
+// newOverflowError returns the error for value, which is out of the range of t. negative selects
+// the minimum rather than the maximum as the limit.
+func newOverflowError(value interface{}, field reflect.StructField, t reflect.Type, negative bool) *OverflowError {
+	err := &OverflowError{Value: value, Field: field, Kind: t.Kind()}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if negative {
+			err.Limit = int64(-1) << (t.Bits() - 1)
+		} else {
+			err.Limit = int64(1)<<(t.Bits()-1) - 1
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		err.Limit = uint64(math.MaxUint64) >> (64 - t.Bits())
+	case reflect.Float32:
+		err.Limit = float64(math.MaxFloat32)
+	case reflect.Float64:
+		err.Limit = math.MaxFloat64
+	}
+	if limit, ok := err.Limit.(float64); ok && negative {
+		err.Limit = -limit
+	}
+	return err
+}
+
+// An UnknownAliasError is returned when [Decoder.StrictFields] is set and some of the names given to
+// [Decoder.SetAliases] are not in the header line. Aliases holds those names in order.
+type UnknownAliasError struct {
+	Aliases []string
+	LineNum int
+}
+
+func (err *UnknownAliasError) Error() string {
+	return fmt.Sprintf(`aliases for columns %q are not in header line %d`, err.Aliases, err.LineNum)
+}
+
+// A MissingColumnError is returned when [Decoder.StrictFields] is set and a field with a
+// column annotation names a column which is not in the headers.
+type MissingColumnError struct {
+	Field  reflect.StructField
+	Column string
+}
+
+func (err *MissingColumnError) Error() string {
+	return fmt.Sprintf(`column "%s" for field "%s" is not in the headers`, err.Column, err.Field.Name)
+}
+
+// An InvalidSetterError is returned when the method named by a setter annotation
+// does not exist or does not have the signature func(string) error. Methods must be
+// exported to be found, so Unexported is set when the name given is not exported.
+type InvalidSetterError struct {
+	Field      reflect.StructField
+	Method     string
+	Unexported bool
+}
+
+func (err *InvalidSetterError) Error() string {
+	if err.Unexported {
+		return fmt.Sprintf(`setter method "%s" for field "%s" is not exported, setter methods must be exported`, err.Method, err.Field.Name)
+	}
+	return fmt.Sprintf(`setter method "%s" for field "%s" must be an exported method with signature func(string) error`, err.Method, err.Field.Name)
+}
+
+// An InvalidTagError is returned when the value of an annotation cannot be used.
+type InvalidTagError struct {
+	Field reflect.StructField
+	Tag   string
+}
+
+func (err *InvalidTagError) Error() string {
+	return fmt.Sprintf(`invalid %s annotation "%s" for field "%s"`, err.Tag, err.Field.Tag.Get(err.Tag), err.Field.Name)
+}
+
+// A ValueTooLongError is returned by the [Encoder] when a value is wider than its column.
+type ValueTooLongError struct {
+	Value string
+	Field reflect.StructField
+	Width int
+}
+
+func (err *ValueTooLongError) Error() string {
+	return fmt.Sprintf(`value "%s" for field "%s" is longer than the column width %d`, err.Value, err.Field.Name, err.Width)
+}
+
+// An InvalidOffsetsError is returned when a column given to [Decoder.SetHeaders], [RecordReader.SetHeaders],
+// [Encoder.SetLayout] or [Reflow] is not given as {from, to} with 0 <= from <= to.
+type InvalidOffsetsError struct {
+	Column  string
+	Offsets []int
+}
+
+func (err *InvalidOffsetsError) Error() string {
+	return fmt.Sprintf(`invalid offsets %v for column "%s"`, err.Offsets, err.Column)
+}
+
+// An InvalidWidthError is returned by [Decoder.SetWidths] when a column can't be given the width with the same
+// index. Missing is set when there is no width for Column or, when Column is empty, no name for Width. Duplicate
+// is set when Column is given more than once. Otherwise Width is negative.
+type InvalidWidthError struct {
+	Column    string
+	Width     int
+	Missing   bool
+	Duplicate bool
+}
+
+func (err *InvalidWidthError) Error() string {
+	switch {
+	case err.Missing && err.Column == "":
+		return fmt.Sprintf("width %d has no column name", err.Width)
+	case err.Missing:
+		return fmt.Sprintf(`column "%s" has no width`, err.Column)
+	case err.Duplicate:
+		return fmt.Sprintf(`column "%s" is given more than once`, err.Column)
+	}
+	return fmt.Sprintf(`column "%s" has a negative width %d`, err.Column, err.Width)
+}
+
+// A MapValueError is returned by [MapsToStructs] when the value of a key can't be converted. Row is the
+// index of the row and Err is the error from the conversion, usually a [CastingError] naming the field.
+type MapValueError struct {
+	Row int
+	Key string
+	Err error
+}
+
+func (err *MapValueError) Error() string {
+	return fmt.Sprintf(`row %d, key "%s": %v`, err.Row, err.Key, err.Err)
+}
+
+func (err *MapValueError) Unwrap() error {
+	return err.Err
+}
+
+// A HeaderTooLongError is returned by the [Encoder] when the name of a column doesn't fit in the header line,
+// leaving a Padding character before the next column. Width is the number of characters available.
+type HeaderTooLongError struct {
+	Column string
+	Width  int
+}
+
+func (err *HeaderTooLongError) Error() string {
+	return fmt.Sprintf(`column name "%s" is longer than the %d characters available in the header line`, err.Column, err.Width)
+}
+
+// An OptionalColumnError is returned when Field is not annotated with optional:"true" but its column ends
+// after the start of the first optional column, Optional, so it would be missing from records which end
+// before the optional columns.
+type OptionalColumnError struct {
+	Field    reflect.StructField
+	Column   string
+	Optional string
+}
+
+func (err *OptionalColumnError) Error() string {
+	return fmt.Sprintf(`column "%s" of field "%s" is not optional but ends after optional column "%s" starts`, err.Column, err.Field.Name, err.Optional)
+}
+
+// A LayoutMismatchError is returned when [Decoder.CheckTypeLayout] is set and a record is
+// too short for the columns mapped by the type chosen to decode it.
+type LayoutMismatchError struct {
+	Type         reflect.Type
+	Line         string
+	LineNum      int
+	Length       int
+	LayoutLength int
+}
+
+func (err *LayoutMismatchError) Error() string {
+	return fmt.Sprintf("record in line %d is too short for type %s (%d < %d): %q",
+		err.LineNum, err.Type, err.Length, err.LayoutLength, err.Line)
+}
+
+// A MisalignmentWarning is passed to [Decoder.OnWarning] when [Decoder.DetectMisalignment] is set and
+// the text of a record runs from the end of Column into the start of Next, which suggests that the
+// record is not aligned with the headers. The record is still decoded.
+type MisalignmentWarning struct {
+	Column  string
+	Next    string
+	Line    string
+	LineNum int
+}
+
+func (err *MisalignmentWarning) Error() string {
+	return fmt.Sprintf("possible misalignment in line %d: column %q runs into column %q: %q",
+		err.LineNum, err.Column, err.Next, err.Line)
+}
+
+// A ChecksumError is returned when the checksum function registered with [Decoder.RegisterChecksum]
+// does not accept a record. Expected is the checksum calculated from the record and Value is the
+// trimmed content of the checksum column.
+type ChecksumError struct {
+	Column   string
+	Value    string
+	Expected string
+	Line     string
+	LineNum  int
+}
+
+func (err *ChecksumError) Error() string {
+	return fmt.Sprintf(`checksum "%s" in column "%s" of line %d does not match "%s"`, err.Value, err.Column, err.LineNum, err.Expected)
+}
+
+// A DynamicWidthError is returned when the width given by the field named in a widthFrom annotation
+// is negative or runs beyond the end of the record.
+type DynamicWidthError struct {
+	Field     reflect.StructField
+	Width     int64
+	Available int
+}
+
+func (err *DynamicWidthError) Error() string {
+	return fmt.Sprintf(`width %d for field "%s" is invalid, the record has %d remaining`, err.Width, err.Field.Name, err.Available)
+}
+
+// A DuplicateHeaderError is returned when [Decoder.OnDuplicateHeader] is DuplicateHeaderFail and a
+// column name appears more than once in the header line.
+type DuplicateHeaderError struct {
+	Header  string
+	LineNum int
+}
+
+func (err *DuplicateHeaderError) Error() string {
+	return fmt.Sprintf(`duplicate column "%s" in header line %d`, err.Header, err.LineNum)
+}
+
+// A MissingRulerError is returned when [Decoder.Ruled] is set and neither the first line of the header
+// nor the line after it is a ruler line.
+type MissingRulerError struct {
+	LineNum int
+}
+
+func (err *MissingRulerError) Error() string {
+	return fmt.Sprintf("no ruler line found before or after header line %d", err.LineNum)
 }
@@ -0,0 +1,331 @@
+package fw
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type valueGetter func(field reflect.Value, structField reflect.StructField) (string, error)
+
+// So we can check if a type implements TextMarshaler or Stringer
+var (
+	textMarshalerType = reflect.TypeOf(new(encoding.TextMarshaler)).Elem()
+	stringerType      = reflect.TypeOf(new(fmt.Stringer)).Elem()
+	timeType          = reflect.TypeOf(time.Time{})
+)
+
+// fieldGetter converts a single struct field into the text for its column.
+type fieldGetter struct {
+	name     string
+	index    int
+	minWidth int
+	field    reflect.StructField
+	getter   valueGetter
+	zeroNil  bool // zeroNil is set when a zero value is written in the same way as a nil pointer
+	class    valueClass
+	align    Alignment // align is given by the align annotation when aligned is set
+	aligned  bool
+}
+
+// valueClass groups the fields which are aligned in the same way when they have no align annotation.
+type valueClass int
+
+const (
+	textValue valueClass = iota
+	numberValue
+	boolValue
+)
+
+// getFieldGetter returns the getter for a field and the class of its values or an error if the type can't be
+// encoded. Numbers and booleans written as text, such as with a stringer format, are text values. A time format
+// starting with "@" names one of layouts.
+func getFieldGetter(field reflect.StructField, layouts map[string]string) (valueGetter, valueClass, error) {
+
+	var getter valueGetter
+	class := textValue
+
+	baseType := field.Type
+	if baseType.Kind() == reflect.Ptr {
+		baseType = baseType.Elem()
+	}
+
+	useStringer := field.Tag.Get(format) == stringerFormat
+
+	if baseType == timeType {
+		formatter, err := timeFormatter(field, layouts)
+		if err != nil {
+			return nil, class, err
+		}
+		getter = timeGet(formatter)
+	} else if useStringer && baseType.Implements(stringerType) {
+		getter = stringerGet
+	} else if useStringer && reflect.PointerTo(baseType).Implements(stringerType) {
+		getter = stringerGetPointer
+	} else if baseType.Implements(textMarshalerType) {
+		getter = textMarshalerGet
+	} else if reflect.PointerTo(baseType).Implements(textMarshalerType) {
+		getter = textMarshalerGetPointer
+	} else {
+		numberFormat, hasFormat := field.Tag.Lookup(format)
+		hasFormat = hasFormat && strings.HasPrefix(numberFormat, "%")
+
+		switch baseType.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			class = numberValue
+			getter = intGet
+			if hasFormat {
+				getter = formatGet(numberFormat, reflect.Value.Int)
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			class = numberValue
+			getter = uintGet
+			if hasFormat {
+				getter = formatGet(numberFormat, reflect.Value.Uint)
+			}
+		case reflect.Float32, reflect.Float64:
+			class = numberValue
+			getter = floatGet
+			if hasFormat {
+				getter = formatGet(numberFormat, reflect.Value.Float)
+			}
+		case reflect.String:
+			getter = stringGet
+		case reflect.Bool:
+			class = boolValue
+			getter = createBoolGet(field)
+		case reflect.Slice:
+			if field.Type.Kind() == reflect.Ptr || baseType.Elem().Kind() != reflect.Uint8 {
+				return nil, class, &InvalidTypeError{Field: field}
+			}
+			var err error
+			if getter, err = createBytesGet(field); err != nil {
+				return nil, class, err
+			}
+		default:
+			return nil, class, &InvalidTypeError{Field: field}
+		}
+	}
+
+	if getter == nil {
+		return nil, class, &InvalidTagError{Field: field, Tag: format}
+	}
+
+	if field.Type.Kind() == reflect.Ptr {
+		return pointerGetter(getter), class, nil
+	}
+	return getter, class, nil
+}
+
+// fieldAlignment returns the alignment given by the align annotation of field. ok is false if there is none.
+func fieldAlignment(field reflect.StructField) (align Alignment, ok bool, err error) {
+	alignTag, ok := field.Tag.Lookup(alignTagName)
+	if !ok {
+		return AlignLeft, false, nil
+	}
+	switch alignTag {
+	case "left":
+		return AlignLeft, true, nil
+	case "right":
+		return AlignRight, true, nil
+	case "center":
+		return AlignCenter, true, nil
+	}
+	return AlignLeft, false, &InvalidTagError{Field: field, Tag: alignTagName}
+}
+
+// formatGet returns a getter which formats numbers with fmt.Sprintf using numberFormat. value
+// extracts the number from the field so that methods such as String on the field's type are
+// not used. It returns nil if numberFormat is not valid for the number.
+func formatGet[T int64 | uint64 | float64](numberFormat string, value func(reflect.Value) T) valueGetter {
+	var zero T
+	if strings.Contains(fmt.Sprintf(numberFormat, zero), "%!") {
+		return nil
+	}
+	return func(field reflect.Value, structField reflect.StructField) (string, error) {
+		return fmt.Sprintf(numberFormat, value(field)), nil
+	}
+}
+
+// pointerGetter dereferences pointer fields before calling getter. Nil pointers are
+// encoded as an empty value.
+func pointerGetter(getter valueGetter) valueGetter {
+	return func(field reflect.Value, structField reflect.StructField) (string, error) {
+		if field.IsNil() {
+			return "", nil
+		}
+		return getter(field.Elem(), structField)
+	}
+}
+
+func intGet(field reflect.Value, structField reflect.StructField) (string, error) {
+	return strconv.FormatInt(field.Int(), 10), nil
+}
+
+func uintGet(field reflect.Value, structField reflect.StructField) (string, error) {
+	return strconv.FormatUint(field.Uint(), 10), nil
+}
+
+func floatGet(field reflect.Value, structField reflect.StructField) (string, error) {
+	return strconv.FormatFloat(field.Float(), 'f', -1, field.Type().Bits()), nil
+}
+
+func stringGet(field reflect.Value, structField reflect.StructField) (string, error) {
+	return field.String(), nil
+}
+
+// createBytesGet returns a getter for []byte fields which encodes the value as given by the encoding
+// annotation or writes it as it is if there is no annotation, mirroring createBytesSet.
+func createBytesGet(structField reflect.StructField) (valueGetter, error) {
+
+	var encode func([]byte) string
+	switch encodingTag, _ := structField.Tag.Lookup(encodingTagName); encodingTag {
+	case "":
+		encode = func(value []byte) string {
+			return string(value)
+		}
+	case "hex":
+		encode = hex.EncodeToString
+	case "base64":
+		encode = base64.StdEncoding.EncodeToString
+	default:
+		return nil, &InvalidTagError{Field: structField, Tag: encodingTagName}
+	}
+
+	return func(field reflect.Value, structField reflect.StructField) (string, error) {
+		return encode(field.Bytes()), nil
+	}, nil
+}
+
+func boolGet(field reflect.Value, structField reflect.StructField) (string, error) {
+	return strconv.FormatBool(field.Bool()), nil
+}
+
+// createBoolGet returns a getter which writes the values given by the true and false annotations,
+// using "true" or "false" for a value without one.
+func createBoolGet(structField reflect.StructField) valueGetter {
+
+	trueText, hasTrue := structField.Tag.Lookup(trueTagName)
+	falseText, hasFalse := structField.Tag.Lookup(falseTagName)
+	if !hasTrue && !hasFalse {
+		return boolGet
+	}
+	if !hasTrue {
+		trueText = "true"
+	}
+	if !hasFalse {
+		falseText = "false"
+	}
+
+	return func(field reflect.Value, structField reflect.StructField) (string, error) {
+		if field.Bool() {
+			return trueText, nil
+		}
+		return falseText, nil
+	}
+}
+
+// timeGet returns a getter which formats times with format.
+func timeGet(format func(time.Time) string) valueGetter {
+	return func(field reflect.Value, structField reflect.StructField) (string, error) {
+		return format(field.Interface().(time.Time)), nil
+	}
+}
+
+func textMarshalerGet(field reflect.Value, structField reflect.StructField) (string, error) {
+	text, err := field.Interface().(encoding.TextMarshaler).MarshalText()
+	return string(text), err
+}
+
+func textMarshalerGetPointer(field reflect.Value, structField reflect.StructField) (string, error) {
+	text, err := addressable(field).Addr().Interface().(encoding.TextMarshaler).MarshalText()
+	return string(text), err
+}
+
+func stringerGet(field reflect.Value, structField reflect.StructField) (string, error) {
+	return field.Interface().(fmt.Stringer).String(), nil
+}
+
+func stringerGetPointer(field reflect.Value, structField reflect.StructField) (string, error) {
+	return addressable(field).Addr().Interface().(fmt.Stringer).String(), nil
+}
+
+// addressable returns field or, if it can't be addressed, an addressable copy of it so that
+// methods with pointer receivers can be called.
+func addressable(field reflect.Value) reflect.Value {
+	if field.CanAddr() {
+		return field
+	}
+	v := reflect.New(field.Type())
+	v.Elem().Set(field)
+	return v.Elem()
+}
+
+func createStructGetter(st reflect.Type, layouts map[string]string) ([]fieldGetter, error) {
+
+	getters := make([]fieldGetter, 0)
+
+	for fieldIndex := 0; fieldIndex < st.NumField(); fieldIndex++ {
+		currentField := st.Field(fieldIndex)
+		if !currentField.IsExported() || skippedField(currentField) {
+			continue
+		}
+
+		getter, class, err := getFieldGetter(currentField, layouts)
+		if err != nil {
+			return nil, err
+		}
+		align, aligned, err := fieldAlignment(currentField)
+		if err != nil {
+			return nil, err
+		}
+
+		minWidth := 0
+		if minWidthTag, ok := currentField.Tag.Lookup(minWidthTagName); ok {
+			if minWidth, err = strconv.Atoi(minWidthTag); err != nil || minWidth < 0 {
+				return nil, &InvalidTagError{Field: currentField, Tag: minWidthTagName}
+			}
+		}
+
+		getters = append(getters, fieldGetter{
+			name:     getRefName(currentField),
+			index:    fieldIndex,
+			minWidth: minWidth,
+			field:    currentField,
+			getter:   getter,
+			zeroNil:  currentField.Type == timeType || currentField.Type == reflect.PointerTo(timeType),
+			class:    class,
+			align:    align,
+			aligned:  aligned,
+		})
+	}
+
+	return getters, nil
+}
+
+var structGetterCache sync.Map // map[structGetterKey][]fieldGetter
+
+// structGetterKey identifies cached getters, which depend on the time layouts registered with the encoder.
+type structGetterKey struct {
+	t       reflect.Type
+	layouts string
+}
+
+func cachedStructGetter(t reflect.Type, layouts map[string]string) ([]fieldGetter, error) {
+	key := structGetterKey{t: t, layouts: fmt.Sprintf("%q", layouts)}
+	if f, ok := structGetterCache.Load(key); ok {
+		return f.([]fieldGetter), nil
+	}
+	getters, err := createStructGetter(t, layouts)
+	if err != nil {
+		return nil, err
+	}
+	f, _ := structGetterCache.LoadOrStore(key, getters)
+	return f.([]fieldGetter), nil
+}
@@ -2,6 +2,8 @@ module github.com/goslogan/fw
 
 go 1.18
 
+require golang.org/x/text v0.14.0
+
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
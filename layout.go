@@ -0,0 +1,39 @@
+package fw
+
+import (
+	"math"
+	"reflect"
+)
+
+// ValidateLayout checks that the struct (or pointer to a struct) prototype can be decoded using the
+// layout given by the width annotations of its fields, which are laid out one after the other in
+// declaration order as for a sub-record. This allows a layout for headerless input defined entirely
+// in a struct to be checked when a program starts rather than when the first record is read.
+//
+// The first problem found is returned: an [InvalidTagError] for a width which is not a non-negative
+// number or a column name used by more than one field, and otherwise the error which decoding into
+// the struct would return, such as an [InvalidTypeError] for a field which can't be converted. An
+// [InvalidInputError] is returned if prototype is not a struct or has no fields with a width annotation.
+func ValidateLayout(prototype interface{}) error {
+
+	st := reflect.TypeOf(prototype)
+	if st != nil && st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+	if st == nil || st.Kind() != reflect.Struct {
+		return &InvalidInputError{Type: reflect.TypeOf(prototype)}
+	}
+
+	config := NewDecoder(nil).setterConfig()
+	headers, err := config.widthLayout(st, math.MaxInt)
+	if err != nil {
+		return err
+	}
+	if len(headers) == 0 {
+		return &InvalidInputError{Type: st}
+	}
+
+	config.headers = headers
+	_, err = createStructSetter(st, config)
+	return err
+}
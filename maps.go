@@ -0,0 +1,73 @@
+package fw
+
+import "reflect"
+
+// mapSetter converts the value of one key of a row into a struct field.
+type mapSetter struct {
+	key    string
+	index  int
+	field  reflect.StructField
+	setter valueSetter
+}
+
+// MapsToStructs converts rows of column names and values, such as records decoded without a struct, into
+// structs of type T using the same conversions and annotations as the [Decoder]. Each exported field takes
+// the value of the key with its column name; fields whose key is missing from a row are left as the zero
+// value and keys without a field are ignored. Values are converted exactly as given, without trimming.
+// Nested structs decoded from a sub-record are not supported and are left as the zero value.
+//
+// An [InvalidInputError] is returned if T is not a struct, an error from the annotations if they are invalid
+// and a [MapValueError] naming the row and key if a value can't be converted.
+func MapsToStructs[T any](rows []map[string]string) ([]T, error) {
+
+	st := reflect.TypeOf((*T)(nil)).Elem()
+	if st.Kind() != reflect.Struct {
+		return nil, &InvalidInputError{Type: st}
+	}
+
+	setters, err := createMapSetters(st)
+	if err != nil {
+		return nil, err
+	}
+
+	structs := make([]T, len(rows))
+	for i, row := range rows {
+		item := reflect.ValueOf(&structs[i]).Elem()
+		for _, setter := range setters {
+			value, ok := row[setter.key]
+			if !ok {
+				continue
+			}
+			if err := setter.setter(item.Field(setter.index), setter.field, value); err != nil {
+				return nil, &MapValueError{Row: i, Key: setter.key, Err: err}
+			}
+		}
+	}
+	return structs, nil
+}
+
+// createMapSetters returns the setters for the fields of st which can be converted from a single value.
+func createMapSetters(st reflect.Type) ([]mapSetter, error) {
+
+	config := NewDecoder(nil).setterConfig()
+	setters := make([]mapSetter, 0, st.NumField())
+
+	for fieldIndex := 0; fieldIndex < st.NumField(); fieldIndex++ {
+		field := st.Field(fieldIndex)
+		if !field.IsExported() || skippedField(field) {
+			continue
+		}
+		if _, isSubRecord := subRecordType(field.Type); isSubRecord {
+			continue
+		}
+		setter, err := config.getFieldSetter(field)
+		if err != nil {
+			return nil, err
+		}
+		if setter, err = config.wrapSetter(field, setter); err != nil {
+			return nil, err
+		}
+		setters = append(setters, mapSetter{key: getRefName(field), index: fieldIndex, field: field, setter: setter})
+	}
+	return setters, nil
+}
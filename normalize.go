@@ -0,0 +1,43 @@
+package fw
+
+import (
+	"reflect"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// A NormalizeForm defines the Unicode normalization form applied to column names (and optionally
+// values) when decoding, so that composed and decomposed forms of the same text match.
+type NormalizeForm int
+
+const (
+	// NormalizeNone leaves text as it is. This is the default.
+	NormalizeNone NormalizeForm = iota
+	// NormalizeNFC converts text to Normalization Form C (composed characters).
+	NormalizeNFC
+	// NormalizeNFD converts text to Normalization Form D (decomposed characters).
+	NormalizeNFD
+)
+
+// string returns s in the normalization form.
+func (form NormalizeForm) string(s string) string {
+	switch form {
+	case NormalizeNFC:
+		return norm.NFC.String(s)
+	case NormalizeNFD:
+		return norm.NFD.String(s)
+	default:
+		return s
+	}
+}
+
+// createNormalizeSet wraps setter so that the trimmed value is normalized before any other
+// processing.
+func createNormalizeSet(form NormalizeForm, setter valueSetter) valueSetter {
+	if form == NormalizeNone {
+		return setter
+	}
+	return func(field reflect.Value, structField reflect.StructField, rawValue string) error {
+		return setter(field, structField, form.string(rawValue))
+	}
+}
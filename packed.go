@@ -0,0 +1,89 @@
+package fw
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// createPackedSet wraps setter so that the raw value is unpacked from packed BCD into a
+// decimal string before conversion.
+func createPackedSet(structField reflect.StructField, setter valueSetter) (valueSetter, error) {
+
+	kind := structField.Type.Kind()
+	if kind == reflect.Ptr {
+		kind = structField.Type.Elem().Kind()
+	}
+
+	scale := 0
+	if scaleTag, ok := structField.Tag.Lookup(scaleTagName); ok {
+		var err error
+		if scale, err = strconv.Atoi(scaleTag); err != nil || scale < 0 {
+			return nil, &InvalidTagError{Field: structField, Tag: scaleTagName}
+		}
+	}
+
+	switch kind {
+	case reflect.Float32, reflect.Float64:
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if scale != 0 {
+			return nil, &InvalidTypeError{Field: structField}
+		}
+	default:
+		return nil, &InvalidTypeError{Field: structField}
+	}
+
+	return func(field reflect.Value, structField reflect.StructField, rawValue string) error {
+		value, err := unpackBCD([]byte(rawValue), scale)
+		if err != nil {
+			return &CastingError{Err: err, Value: rawValue, Field: structField}
+		}
+		return setter(field, structField, value)
+	}, nil
+}
+
+// unpackBCD converts packed BCD data into a decimal string with scale digits after
+// the decimal point. The final nibble holds the sign: 0xB and 0xD are negative, 0xA,
+// 0xC, 0xE and 0xF are positive.
+func unpackBCD(data []byte, scale int) (string, error) {
+
+	if len(data) == 0 {
+		return "", errors.New("empty packed decimal")
+	}
+
+	digits := make([]byte, 0, len(data)*2)
+	for i, b := range data {
+		high, low := b>>4, b&0x0f
+		if high > 9 {
+			return "", fmt.Errorf("invalid packed digit 0x%x", high)
+		}
+		digits = append(digits, '0'+high)
+		if i < len(data)-1 {
+			if low > 9 {
+				return "", fmt.Errorf("invalid packed digit 0x%x", low)
+			}
+			digits = append(digits, '0'+low)
+		} else if low < 0x0a {
+			return "", fmt.Errorf("invalid packed sign 0x%x", low)
+		} else if low == 0x0b || low == 0x0d {
+			digits = append([]byte{'-'}, digits...)
+		}
+	}
+
+	value := string(digits)
+	if scale > 0 {
+		sign := ""
+		if strings.HasPrefix(value, "-") {
+			sign, value = "-", value[1:]
+		}
+		if len(value) <= scale {
+			value = strings.Repeat("0", scale-len(value)+1) + value
+		}
+		value = sign + value[:len(value)-scale] + "." + value[len(value)-scale:]
+	}
+
+	return value, nil
+}
@@ -0,0 +1,82 @@
+package fw
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// A RecordReader decodes records from input framed purely by length, with no record terminators and
+// no header line, giving random access to any record. Records are read with [io.ReaderAt] so only the
+// record requested is read. A RecordReader is not safe for concurrent use.
+type RecordReader struct {
+	r         io.ReaderAt
+	recordLen int
+	buf       []byte
+	decoder   *Decoder
+	headerErr error // headerErr is the problem with the offsets given to SetHeaders, returned by ReadRecord
+	lengthErr error // lengthErr is ErrInvalidRecordLength if the length given to NewRecordReader can't be used
+}
+
+// NewRecordReader returns a RecordReader for r where every record is recordLen bytes long. The
+// column offsets must be given with [RecordReader.SetHeaders] before records are read. If recordLen
+// is not positive every call to ReadRecord returns [ErrInvalidRecordLength].
+func NewRecordReader(r io.ReaderAt, recordLen int) *RecordReader {
+	decoder := NewDecoder(nil)
+	decoder.WidthMode = WidthBytes
+	rr := &RecordReader{r: r, recordLen: recordLen, decoder: decoder}
+	if recordLen > 0 {
+		rr.buf = make([]byte, recordLen)
+	} else {
+		rr.lengthErr = ErrInvalidRecordLength
+	}
+	return rr
+}
+
+// SetHeaders sets the column offsets, which are measured in bytes from the start of the record. An
+// [InvalidOffsetsError] is returned by ReadRecord if a column does not have valid offsets.
+func (rr *RecordReader) SetHeaders(headers map[string][]int) {
+	rr.headerErr = checkOffsets(headers)
+	rr.decoder.SetHeaders(headers)
+	rr.decoder.lastType = nil
+}
+
+// ReadRecord decodes record n, counting from zero, into v, which must be a pointer to a struct.
+// io.EOF is returned if the input ends before record n starts and io.ErrUnexpectedEOF if it ends
+// part way through it. An error wrapping [ErrInvalidRecordNumber] is returned if n is negative.
+func (rr *RecordReader) ReadRecord(n int, v interface{}) error {
+
+	if v == nil {
+		return &InvalidInputError{Type: nil}
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return &InvalidInputError{Type: rv.Type()}
+	}
+	if rr.lengthErr != nil {
+		return rr.lengthErr
+	}
+	if rr.decoder.headers == nil {
+		return ErrNoHeaders
+	}
+	if rr.headerErr != nil {
+		return rr.headerErr
+	}
+	if n < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidRecordNumber, n)
+	}
+
+	read, err := rr.r.ReadAt(rr.buf, int64(n)*int64(rr.recordLen))
+	if read < rr.recordLen {
+		if read == 0 && (err == nil || err == io.EOF) {
+			return io.EOF
+		}
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	rr.decoder.lineNum = n + 1
+	return rr.decoder.decodeRecord(rv.Elem(), string(rr.buf))
+}
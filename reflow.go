@@ -0,0 +1,70 @@
+package fw
+
+import (
+	"io"
+	"reflect"
+	"sort"
+)
+
+// Reflow copies fixed width text from in to out, moving each column from its position in the from
+// layout to its position in the to layout. No type conversion is done: values are trimmed of spaces
+// and written left aligned in their new columns, which are padded with spaces. Offsets are measured in
+// runes and records are terminated by "\n".
+//
+// If from is nil the layout is read from the header line of in and a header line for the to layout
+// is written to out; otherwise in has no header line and none is written. Columns which are only in to
+// are left blank and columns which are only in from are dropped. If a value is longer than its column
+// in the to layout a [ValueTooLongError] is returned and the records before it are left in out. The to
+// layout is checked in the same way as by [Encoder.SetLayout] before any input is read.
+func Reflow(in io.Reader, out io.Writer, from, to map[string][]int) error {
+
+	encoder := NewEncoder(out)
+	encoder.SetLayout(to)
+	if encoder.layoutErr != nil {
+		return encoder.layoutErr
+	}
+	names := make([]string, 0, len(to))
+	for name := range to {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	decoder := NewDecoder(in)
+	if from != nil {
+		decoder.SetHeaders(from)
+	}
+	if err := decoder.parseHeaders(); err != nil {
+		return err
+	}
+
+	if from == nil && !decoder.done {
+		headers := make(map[string]encodedValue, len(names))
+		for _, name := range names {
+			headers[name] = encodedValue{value: name, field: reflect.StructField{Name: name}}
+		}
+		if err := encoder.writeRecord(headers, ""); err != nil {
+			return err
+		}
+	}
+
+	trimmer, err := decoder.setterConfig().fieldTrimmer()
+	if err != nil {
+		return err
+	}
+	for {
+		line, err, ok := decoder.readRecord(decoder.headersLength)
+		if err != nil || !ok {
+			return err
+		}
+		r := newRecord(line, nil, decoder.WidthMode)
+		values := make(map[string]encodedValue, len(names))
+		for _, name := range names {
+			if index, ok := decoder.headers[name]; ok {
+				values[name] = encodedValue{value: trimmer.trim(r.field(index[0], index[1])), field: reflect.StructField{Name: name}}
+			}
+		}
+		if err := encoder.writeRecord(values, ""); err != nil {
+			return err
+		}
+	}
+}
@@ -2,23 +2,64 @@ package fw
 
 import (
 	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"go/token"
+	"math"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
 )
 
 type valueSetter func(field reflect.Value, structField reflect.StructField, rawValue string) error
+
+// A Converter converts the trimmed text of a column into a value for a field. See [Decoder.RegisterConverter].
+type Converter func(rawValue string) (interface{}, error)
 type structSetter func(item reflect.Value, line string) error
 
 // So we can check if a type implements TextUnmarsheler
 var textUnmarshalerType = reflect.TypeOf(new(encoding.TextUnmarshaler)).Elem()
 
-// getFieldSetter returns a setter if one can be found and nil if not
-func getFieldSetter(field reflect.StructField) (valueSetter, error) {
+// The signature required of methods named by the setter annotation.
+var setterMethodType = reflect.TypeOf(func(string) error { return nil })
+
+// getFieldSetter returns the setter for a field, using a registered converter for the field's type
+// in preference to the built in conversions.
+func (config setterConfig) getFieldSetter(field reflect.StructField) (valueSetter, error) {
+	if field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Ptr {
+		inner := field
+		inner.Type = field.Type.Elem()
+		setter, err := config.getFieldSetter(inner)
+		if err != nil {
+			return nil, &InvalidTypeError{Field: field}
+		}
+		return indirectSetter(setter), nil
+	}
+	if converter, ok := config.converters[field.Type]; ok {
+		return converterSet(converter), nil
+	}
+	if field.Type.Kind() == reflect.Ptr {
+		if converter, ok := config.converters[field.Type.Elem()]; ok {
+			return converterSetPointer(converter), nil
+		}
+	}
+	return getFieldSetter(field, config.location, config.timeLayouts)
+}
+
+// getFieldSetter returns a setter if one can be found and nil if not. Times which don't
+// give a location are decoded in location.
+func getFieldSetter(field reflect.StructField, location *time.Location, layouts map[string]string) (valueSetter, error) {
 
 	var setter valueSetter
 	var err error
@@ -33,9 +74,9 @@ func getFieldSetter(field reflect.StructField) (valueSetter, error) {
 	// to handle the format annotation.
 	if field.Type == reflect.TypeOf(time.Time{}) || field.Type == reflect.TypeOf(&time.Time{}) {
 		if isPointer {
-			return createTimeSetPointer(field), nil
+			return createTimeSetPointer(field, location, layouts)
 		} else {
-			return createTimeSet(field), nil
+			return createTimeSet(field, location, layouts)
 		}
 	}
 
@@ -71,10 +112,24 @@ func getFieldSetter(field reflect.StructField) (valueSetter, error) {
 			setter = stringSet
 		}
 	case reflect.Bool:
-		if isPointer {
-			setter = boolSetPointer
+		if flag, ok := field.Tag.Lookup(flagTagName); ok {
+			setter = createFlagSet(flag, isPointer)
+		} else if isPointer {
+			setter = createBoolTextSet(field, boolSetPointer)
 		} else {
-			setter = boolSet
+			setter = createBoolTextSet(field, boolSet)
+		}
+	case reflect.Slice:
+		if isPointer || field.Type.Elem().Kind() != reflect.Uint8 {
+			err = &InvalidTypeError{Field: field}
+		} else {
+			setter, err = createBytesSet(field)
+		}
+	case reflect.Map:
+		if isPointer || field.Type.Key().Kind() != reflect.String || field.Type.Elem().Kind() != reflect.String {
+			err = &InvalidTypeError{Field: field}
+		} else {
+			setter, err = createKVSet(field)
 		}
 	default:
 		err = &InvalidTypeError{Field: field}
@@ -83,49 +138,418 @@ func getFieldSetter(field reflect.StructField) (valueSetter, error) {
 	return setter, err
 }
 
-func createTimeSet(structField reflect.StructField) valueSetter {
+// createKVSet returns a setter for map fields holding key/value pairs such as "k1=v1;k2=v2".
+// The kv annotation gives the pair separator followed by the key/value separator (default ";=").
+func createKVSet(structField reflect.StructField) (valueSetter, error) {
 
-	timeFormat, ok := structField.Tag.Lookup(format)
-	if !ok {
-		timeFormat = time.RFC3339
+	separators := []rune(defaultKVSeparators)
+	if kvTag, ok := structField.Tag.Lookup(kvTagName); ok {
+		separators = []rune(kvTag)
+	}
+	if len(separators) != 2 || separators[0] == separators[1] {
+		return nil, &InvalidTagError{Field: structField, Tag: kvTagName}
+	}
+	pairSeparator, kvSeparator := string(separators[0]), string(separators[1])
+
+	return func(field reflect.Value, structField reflect.StructField, rawValue string) error {
+		t := field.Type()
+		m := reflect.MakeMap(t)
+		for _, pair := range strings.Split(rawValue, pairSeparator) {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(pair, kvSeparator)
+			if !ok {
+				return &CastingError{Err: fmt.Errorf("missing %q in %q", kvSeparator, pair), Value: rawValue, Field: structField}
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(key)).Convert(t.Key()), reflect.ValueOf(strings.TrimSpace(value)).Convert(t.Elem()))
+		}
+		field.Set(m)
+		return nil
+	}, nil
+}
+
+func createTimeSet(structField reflect.StructField, location *time.Location, layouts map[string]string) (valueSetter, error) {
+
+	parse, err := timeParser(structField, location, layouts)
+	if err != nil {
+		return nil, err
 	}
 
 	return func(field reflect.Value, structField reflect.StructField, rawValue string) error {
-		t, err := time.Parse(timeFormat, rawValue)
+		t, err := parse(rawValue)
 		if err != nil {
 			return &CastingError{Err: err, Value: rawValue, Field: structField}
 		}
 		field.Set(reflect.ValueOf(t))
 		return nil
+	}, nil
+}
+
+func createTimeSetPointer(structField reflect.StructField, location *time.Location, layouts map[string]string) (valueSetter, error) {
+
+	parse, err := timeParser(structField, location, layouts)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(field reflect.Value, structField reflect.StructField, rawValue string) error {
+
+		if rawValue == "" {
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+		t, err := parse(rawValue)
+		if err != nil {
+			return &CastingError{Err: err, Value: rawValue, Field: structField}
+		}
+		field.Set(reflect.ValueOf(&t))
+		return nil
+	}, nil
+}
+
+// wrapSetter adds the processing required by the annotations which change the trimmed value of a
+// column before it is converted.
+func (config setterConfig) wrapSetter(structField reflect.StructField, setter valueSetter) (valueSetter, error) {
+	setter, err := createFortranSet(structField, setter)
+	if err != nil {
+		return nil, err
+	}
+	setter = config.createCurrencySet(structField, setter)
+	if setter, err = createCaseSet(structField, setter); err != nil {
+		return nil, err
+	}
+	if setter, err = createMaxLenSet(structField, setter); err != nil {
+		return nil, err
+	}
+	if setter, err = config.createStrictPadSet(structField, setter); err != nil {
+		return nil, err
+	}
+	if setter, err = createLabelSet(structField, setter); err != nil {
+		return nil, err
+	}
+	setter = createNormalizeSet(config.normalizeValues, setter)
+	return config.createDefaultSet(structField, setter)
+}
+
+// createDefaultSet wraps setter so that a blank value is replaced by the default annotation, which is then
+// processed in the same way as the content of the column. The default for a time field is checked against the
+// field's format when the setter is created.
+func (config setterConfig) createDefaultSet(structField reflect.StructField, setter valueSetter) (valueSetter, error) {
+
+	defaultValue, ok := structField.Tag.Lookup(defaultTagName)
+	if !ok {
+		return setter, nil
+	}
+
+	if structField.Type == timeType || structField.Type == reflect.PointerTo(timeType) {
+		parse, err := timeParser(structField, config.location, config.timeLayouts)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := parse(defaultValue); err != nil {
+			return nil, &InvalidTagError{Field: structField, Tag: defaultTagName}
+		}
+	}
+
+	return func(field reflect.Value, structField reflect.StructField, rawValue string) error {
+		if rawValue == "" {
+			rawValue = defaultValue
+		}
+		return setter(field, structField, rawValue)
+	}, nil
+}
+
+// createLabelSet wraps setter so that only the value following the label given by the label annotation
+// is converted. The value runs from the label separator (labelSep, default ":") to the next white space.
+// The field is left unchanged if the label is not found.
+func createLabelSet(structField reflect.StructField, setter valueSetter) (valueSetter, error) {
+
+	label, ok := structField.Tag.Lookup(labelTagName)
+	if !ok {
+		return setter, nil
+	}
+
+	separator, ok := structField.Tag.Lookup(labelSepTagName)
+	if !ok {
+		separator = defaultLabelSeparator
+	}
+	if label == "" || structField.Tag.Get(numericTagName) == packedNumeric {
+		return nil, &InvalidTagError{Field: structField, Tag: labelTagName}
+	}
+
+	pattern := regexp.MustCompile(`(?:^|\s)` + regexp.QuoteMeta(label+separator) + `(\S*)`)
+
+	return func(field reflect.Value, structField reflect.StructField, rawValue string) error {
+		match := pattern.FindStringSubmatch(rawValue)
+		if match == nil {
+			return nil
+		}
+		return setter(field, structField, match[1])
+	}, nil
+}
+
+// createCurrencySet wraps the setter of a numeric field so that a currency symbol at the start (after
+// any sign) or end of the value is removed, with the spaces between it and the number and any commas
+// grouping its digits.
+func (config setterConfig) createCurrencySet(structField reflect.StructField, setter valueSetter) valueSetter {
+
+	if config.currencySymbols == "" {
+		return setter
+	}
+
+	t := structField.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+	default:
+		return setter
+	}
+
+	return func(field reflect.Value, structField reflect.StructField, rawValue string) error {
+		value, sign := rawValue, ""
+		if strings.HasPrefix(value, "-") || strings.HasPrefix(value, "+") {
+			value, sign = value[1:], value[:1]
+		}
+		if r, size := utf8.DecodeRuneInString(value); size > 0 && strings.ContainsRune(config.currencySymbols, r) {
+			value = sign + removeGrouping(strings.TrimLeft(value[size:], " "))
+		} else if r, size := utf8.DecodeLastRuneInString(rawValue); size > 0 && strings.ContainsRune(config.currencySymbols, r) {
+			value = removeGrouping(strings.TrimRight(rawValue[:len(rawValue)-size], " "))
+		} else {
+			value = rawValue
+		}
+		return setter(field, structField, value)
+	}
+}
+
+// removeGrouping removes the commas grouping the digits before the decimal point of an amount, such as
+// "1,234.56". The value is returned unchanged unless every group after the first has three digits.
+func removeGrouping(value string) string {
+
+	if !strings.Contains(value, ",") {
+		return value
+	}
+
+	sign := ""
+	if strings.HasPrefix(value, "-") || strings.HasPrefix(value, "+") {
+		sign, value = value[:1], value[1:]
+	}
+	whole, fraction := value, ""
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		whole, fraction = value[:i], value[i:]
+	}
+
+	groups := strings.Split(whole, ",")
+	for i, group := range groups {
+		if len(group) == 0 || len(group) > 3 || (i > 0 && len(group) != 3) {
+			return sign + value
+		}
+		for _, c := range group {
+			if c < '0' || c > '9' {
+				return sign + value
+			}
+		}
+	}
+	return sign + strings.Join(groups, "") + fraction
+}
+
+// createMaxLenSet wraps setter so that the trimmed value is truncated to the number of runes
+// given by the maxlen annotation. setter is returned unchanged if there is no annotation.
+func createMaxLenSet(structField reflect.StructField, setter valueSetter) (valueSetter, error) {
+
+	maxLenTag, ok := structField.Tag.Lookup(maxLenTagName)
+	if !ok {
+		return setter, nil
+	}
+
+	maxLen, err := strconv.Atoi(maxLenTag)
+	if err != nil || maxLen < 0 {
+		return nil, &InvalidTagError{Field: structField, Tag: maxLenTagName}
+	}
+
+	return func(field reflect.Value, structField reflect.StructField, rawValue string) error {
+		if utf8.RuneCountInString(rawValue) > maxLen {
+			rawValue = string([]rune(rawValue)[:maxLen])
+		}
+		return setter(field, structField, rawValue)
+	}, nil
+}
+
+// createFortranSet wraps the setter of a floating point field annotated with float:"fortran" so that a D
+// exponent is replaced with E before the value is parsed.
+func createFortranSet(structField reflect.StructField, setter valueSetter) (valueSetter, error) {
+
+	floatTag, ok := structField.Tag.Lookup(floatTagName)
+	if !ok {
+		return setter, nil
+	}
+
+	kind := structField.Type.Kind()
+	if kind == reflect.Ptr {
+		kind = structField.Type.Elem().Kind()
+	}
+	if floatTag != fortranFloat || (kind != reflect.Float32 && kind != reflect.Float64) {
+		return nil, &InvalidTagError{Field: structField, Tag: floatTagName}
+	}
+
+	exponent := strings.NewReplacer("D", "E", "d", "E")
+	return func(field reflect.Value, structField reflect.StructField, rawValue string) error {
+		return setter(field, structField, exponent.Replace(rawValue))
+	}, nil
+}
+
+// createCaseSet wraps setter so that the case of string values is changed as given by the normalize
+// annotation. Casers from the cases package hold state so a new one is used for each value.
+func createCaseSet(structField reflect.StructField, setter valueSetter) (valueSetter, error) {
+
+	normalizeTag, ok := structField.Tag.Lookup(normalizeTagName)
+	if !ok {
+		return setter, nil
+	}
+
+	kind := structField.Type.Kind()
+	if kind == reflect.Ptr {
+		kind = structField.Type.Elem().Kind()
+	}
+	if kind != reflect.String {
+		return nil, &InvalidTagError{Field: structField, Tag: normalizeTagName}
+	}
+
+	var normalize func(string) string
+	switch normalizeTag {
+	case "lower":
+		normalize = func(value string) string {
+			return cases.Lower(language.Und).String(value)
+		}
+	case "upper":
+		normalize = func(value string) string {
+			return cases.Upper(language.Und).String(value)
+		}
+	case "title":
+		normalize = func(value string) string {
+			return cases.Title(language.Und).String(value)
+		}
+	case "trimfold":
+		normalize = func(value string) string {
+			return cases.Fold().String(strings.Join(strings.Fields(value), " "))
+		}
+	default:
+		return nil, &InvalidTagError{Field: structField, Tag: normalizeTagName}
 	}
+
+	return func(field reflect.Value, structField reflect.StructField, rawValue string) error {
+		return setter(field, structField, normalize(rawValue))
+	}, nil
 }
 
-func createTimeSetPointer(structField reflect.StructField) valueSetter {
+// createStrictPadSet wraps setter so that a trimmed value which still contains padding, such as
+// "12 34", is rejected. It is only used for numeric and boolean fields with strictPad:"true".
+func (config setterConfig) createStrictPadSet(structField reflect.StructField, setter valueSetter) (valueSetter, error) {
 
-	timeFormat, ok := structField.Tag.Lookup(format)
+	strictTag, ok := structField.Tag.Lookup(strictPadTagName)
 	if !ok {
-		timeFormat = time.RFC3339
+		return setter, nil
+	}
+
+	t := structField.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	kind := t.Kind()
+	strict, err := strconv.ParseBool(strictTag)
+	if err != nil || !(isIntegerKind(kind) || kind == reflect.Float32 || kind == reflect.Float64 || kind == reflect.Bool) {
+		return nil, &InvalidTagError{Field: structField, Tag: strictPadTagName}
+	}
+	if !strict {
+		return setter, nil
+	}
+
+	padding, err := regexp.Compile(config.fieldSeparator)
+	if config.splitter != nil {
+		padding, err = regexp.MustCompile(`\s`), nil
 	}
+	if err != nil {
+		return nil, err
+	}
+
 	return func(field reflect.Value, structField reflect.StructField, rawValue string) error {
+		if padding.MatchString(rawValue) {
+			return &CastingError{Err: errors.New("value contains padding"), Value: rawValue, Field: structField}
+		}
+		return setter(field, structField, rawValue)
+	}, nil
+}
 
-		t, err := time.Parse(timeFormat, rawValue)
+// indirectSetter handles fields with more than one level of pointer. A nil pointer at the
+// outer level is replaced with a newly allocated pointer and setter is called for the
+// pointer it points to. Pointers which are already set are reused.
+func indirectSetter(setter valueSetter) valueSetter {
+	return func(field reflect.Value, structField reflect.StructField, rawValue string) error {
+		if field.IsNil() {
+			field.Set(reflect.New(field.Type().Elem()))
+		}
+		return setter(field.Elem(), structField, rawValue)
+	}
+}
+
+func converterSet(converter Converter) valueSetter {
+	return func(field reflect.Value, structField reflect.StructField, rawValue string) error {
+		value, err := converter(rawValue)
 		if err != nil {
 			return &CastingError{Err: err, Value: rawValue, Field: structField}
 		}
-		field.Set(reflect.ValueOf(&t))
+		v := reflect.ValueOf(value)
+		if !v.IsValid() || !v.Type().AssignableTo(field.Type()) {
+			return &CastingError{Err: fmt.Errorf("converter returned %T", value), Value: rawValue, Field: structField}
+		}
+		field.Set(v)
 		return nil
 	}
 }
 
+func converterSetPointer(converter Converter) valueSetter {
+	return func(field reflect.Value, structField reflect.StructField, rawValue string) error {
+		v := reflect.New(field.Type().Elem())
+		if err := converterSet(converter)(v.Elem(), structField, rawValue); err != nil {
+			return err
+		}
+		field.Set(v)
+		return nil
+	}
+}
+
+// uintError returns the error for a value which can't be parsed as an unsigned integer, which is
+// a NegativeUnsignedError if it is a negative integer.
+func uintError(err error, rawValue string, structField reflect.StructField) error {
+	if len(rawValue) > 1 && rawValue[0] == '-' && isDigits(rawValue[1:]) && strings.Trim(rawValue[1:], "0") != "" {
+		return &NegativeUnsignedError{Value: rawValue, Field: structField}
+	}
+	return &CastingError{Err: err, Value: rawValue, Field: structField}
+}
+
+// parseUint parses rawValue as an unsigned decimal integer. A single leading "+" is accepted, as it
+// is by strconv.ParseInt and strconv.ParseFloat, so that explicitly signed values decode in the same
+// way into every numeric field.
+func parseUint(rawValue string) (uint64, error) {
+	if len(rawValue) > 1 && rawValue[0] == '+' && rawValue[1] != '+' && rawValue[1] != '-' {
+		return strconv.ParseUint(rawValue[1:], 10, 64)
+	}
+	return strconv.ParseUint(rawValue, 10, 64)
+}
+
 func uintSetPointer(field reflect.Value, structField reflect.StructField, rawValue string) error {
 	rawValue = strings.TrimSpace(rawValue)
-	value, err := strconv.ParseUint(rawValue, 10, 64)
+	value, err := parseUint(rawValue)
 	if err != nil {
-		return &CastingError{Err: err, Value: rawValue, Field: structField}
+		return uintError(err, rawValue, structField)
 	}
 	v := reflect.New(field.Type().Elem())
 	if v.Elem().OverflowUint(value) {
-		return &OverflowError{Value: value, Field: structField}
+		return newOverflowError(value, structField, v.Elem().Type(), false)
 	}
 	v.Elem().SetUint(value)
 	field.Set(v)
@@ -134,13 +558,13 @@ func uintSetPointer(field reflect.Value, structField reflect.StructField, rawVal
 
 func uintSet(field reflect.Value, structField reflect.StructField, rawValue string) error {
 	rawValue = strings.TrimSpace(rawValue)
-	value, err := strconv.ParseUint(rawValue, 10, 64)
+	value, err := parseUint(rawValue)
 	if err != nil {
-		return &CastingError{Err: err, Value: rawValue, Field: structField}
+		return uintError(err, rawValue, structField)
 	}
 
 	if field.OverflowUint(value) {
-		return &OverflowError{Value: value, Field: structField}
+		return newOverflowError(value, structField, field.Type(), false)
 	}
 	field.SetUint(value)
 	return nil
@@ -153,7 +577,7 @@ func intSetPointer(field reflect.Value, structField reflect.StructField, rawValu
 	}
 	v := reflect.New(field.Type().Elem())
 	if v.Elem().OverflowInt(value) {
-		return &OverflowError{Value: value, Field: structField}
+		return newOverflowError(value, structField, v.Elem().Type(), value < 0)
 	}
 	v.Elem().SetInt(value)
 	field.Set(v)
@@ -168,7 +592,7 @@ func intSet(field reflect.Value, structField reflect.StructField, rawValue strin
 	}
 
 	if field.OverflowInt(value) {
-		return &OverflowError{Value: value, Field: structField}
+		return newOverflowError(value, structField, field.Type(), value < 0)
 	}
 	field.SetInt(value)
 
@@ -176,13 +600,10 @@ func intSet(field reflect.Value, structField reflect.StructField, rawValue strin
 }
 
 func floatSetPointer(field reflect.Value, structField reflect.StructField, rawValue string) error {
-	value, err := strconv.ParseFloat(rawValue, 64)
-	if err != nil {
-		return &CastingError{Err: err, Value: rawValue, Field: structField}
-	}
 	v := reflect.New(field.Type().Elem())
-	if v.Elem().OverflowFloat(value) {
-		return &OverflowError{Value: value, Field: structField}
+	value, err := parseFloat(v.Elem(), structField, rawValue)
+	if err != nil {
+		return err
 	}
 	v.Elem().SetFloat(value)
 	field.Set(v)
@@ -191,19 +612,28 @@ func floatSetPointer(field reflect.Value, structField reflect.StructField, rawVa
 }
 
 func floatSet(field reflect.Value, structField reflect.StructField, rawValue string) error {
-	value, err := strconv.ParseFloat(rawValue, 64)
+	value, err := parseFloat(field, structField, rawValue)
 	if err != nil {
-		return &CastingError{Err: err, Value: rawValue, Field: structField}
-	}
-
-	if field.OverflowFloat(value) {
-		return &OverflowError{Value: value, Field: structField}
+		return err
 	}
 	field.SetFloat(value)
 
 	return nil
 }
 
+// parseFloat parses rawValue with the bit size of field so that float32 fields receive
+// the same value as a direct 32 bit parse rather than a rounded 64 bit value.
+func parseFloat(field reflect.Value, structField reflect.StructField, rawValue string) (float64, error) {
+	value, err := strconv.ParseFloat(rawValue, field.Type().Bits())
+	if errors.Is(err, strconv.ErrRange) {
+		value, _ = strconv.ParseFloat(rawValue, 64)
+		return 0, newOverflowError(value, structField, field.Type(), value < 0)
+	} else if err != nil {
+		return 0, &CastingError{Err: err, Value: rawValue, Field: structField}
+	}
+	return value, nil
+}
+
 func stringSet(field reflect.Value, structField reflect.StructField, rawValue string) error {
 	field.SetString(rawValue)
 	return nil
@@ -214,6 +644,48 @@ func stringSetPointer(field reflect.Value, structField reflect.StructField, rawV
 	return nil
 }
 
+// createFlagSet returns a setter for boolean flag columns, which are true when they hold flag
+// (or anything if flag is empty) and false when blank. Blank pointer fields are set to nil.
+func createFlagSet(flag string, isPointer bool) valueSetter {
+	return func(field reflect.Value, structField reflect.StructField, rawValue string) error {
+		if rawValue == "" {
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+		if flag != "" && rawValue != flag {
+			return &CastingError{Err: fmt.Errorf("expected %q or blank", flag), Value: rawValue, Field: structField}
+		}
+		if isPointer {
+			value := reflect.New(field.Type().Elem())
+			value.Elem().SetBool(true)
+			field.Set(value)
+		} else {
+			field.SetBool(true)
+		}
+		return nil
+	}
+}
+
+// createBoolTextSet wraps setter so that the values given by the true and false annotations are
+// accepted as well as those understood by parseBool.
+func createBoolTextSet(structField reflect.StructField, setter valueSetter) valueSetter {
+
+	trueText, hasTrue := structField.Tag.Lookup(trueTagName)
+	falseText, hasFalse := structField.Tag.Lookup(falseTagName)
+	if !hasTrue && !hasFalse {
+		return setter
+	}
+
+	return func(field reflect.Value, structField reflect.StructField, rawValue string) error {
+		if hasTrue && rawValue == trueText {
+			rawValue = "true"
+		} else if hasFalse && rawValue == falseText {
+			rawValue = "false"
+		}
+		return setter(field, structField, rawValue)
+	}
+}
+
 func boolSet(field reflect.Value, structField reflect.StructField, rawValue string) error {
 
 	value, err := parseBool(rawValue)
@@ -235,6 +707,34 @@ func boolSetPointer(field reflect.Value, structField reflect.StructField, rawVal
 	return nil
 }
 
+// createBytesSet returns a setter for []byte fields which decodes the value as given by the encoding
+// annotation or copies it if there is no annotation.
+func createBytesSet(structField reflect.StructField) (valueSetter, error) {
+
+	var decode func(string) ([]byte, error)
+	switch encodingTag, _ := structField.Tag.Lookup(encodingTagName); encodingTag {
+	case "":
+		decode = func(value string) ([]byte, error) {
+			return []byte(value), nil
+		}
+	case "hex":
+		decode = hex.DecodeString
+	case "base64":
+		decode = base64.StdEncoding.DecodeString
+	default:
+		return nil, &InvalidTagError{Field: structField, Tag: encodingTagName}
+	}
+
+	return func(field reflect.Value, structField reflect.StructField, rawValue string) error {
+		value, err := decode(rawValue)
+		if err != nil {
+			return &CastingError{Err: err, Value: rawValue, Field: structField}
+		}
+		field.SetBytes(value)
+		return nil
+	}, nil
+}
+
 func textUnmarshalerSet(field reflect.Value, structField reflect.StructField, rawValue string) error {
 	t := field.Type()
 	if t.Kind() == reflect.Ptr && field.IsNil() {
@@ -253,38 +753,413 @@ func textUnmarshalerSetPointer(field reflect.Value, structField reflect.StructFi
 	return field.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(rawValue))
 }
 
-func createStructSetter(st reflect.Type, indices map[string][]int, fieldSeparator string) (structSetter, error) {
+// setterConfig holds the decoder settings which determine how a struct setter is built.
+// Any setting which changes the setter must be included here so that it forms part of the
+// cache key.
+type setterConfig struct {
+	headers         map[string][]int
+	fieldSeparator  string
+	splitter        *regexp.Regexp // splitter is set when records are delimited rather than positional
+	converters      map[reflect.Type]Converter
+	timeLayouts     map[string]string // timeLayouts are the layouts named by format annotations starting with "@"
+	requireMapped   bool
+	strictFields    bool
+	location        *time.Location
+	widthMode       WidthMode
+	columnDelimiter rune
+	normalizeNames  NormalizeForm
+	normalizeValues NormalizeForm
+	columnPadding   map[string]columnPadding // columnPadding holds padding inferred for columns by the decoder
+	currencySymbols string                   // currencySymbols are removed from numeric values when set
+	useJSONTags     bool
+	trimGreedy      bool
+}
+
+// columnPadding is padding trimmed from a column as well as the field separator. side is "left",
+// "right" or empty for both.
+type columnPadding struct {
+	chars string
+	side  string
+}
+
+// record holds a single input record in the forms needed by the value setters.
+type record struct {
+	line    string
+	runes   []rune
+	cells   []int    // cells holds the display cell at which each rune starts when measuring in cells
+	width   int      // width is the number of cells occupied by the record when measuring in cells
+	columns []string // columns is only set for delimited records
+	mode    WidthMode
+}
+
+// valueSetterPool holds the slices used to collect the value setters while a struct setter is built.
+var valueSetterPool = sync.Pool{
+	New: func() interface{} {
+		setters := make([]func(reflect.Value, *record) error, 0, 16)
+		return &setters
+	},
+}
+
+func createStructSetter(st reflect.Type, config setterConfig) (structSetter, error) {
 
 	nFields := st.NumField()
-	valueSetters := make([]func(reflect.Value, []rune) error, 0)
-	leftTrimmer := regexp.MustCompile("^" + fieldSeparator + "+")
-	rightTrimmer := regexp.MustCompile(fieldSeparator + "+$")
+	scratch := valueSetterPool.Get().(*[]func(reflect.Value, *record) error)
+	valueSetters := (*scratch)[:0]
+	defer func() {
+		// Clear the closures so that the pool does not keep them alive.
+		for i := range valueSetters {
+			valueSetters[i] = nil
+		}
+		*scratch = valueSetters[:0]
+		valueSetterPool.Put(scratch)
+	}()
+	trimmer, err := config.fieldTrimmer()
+	if err != nil {
+		return nil, err
+	}
+
+	// Records may end before the first optional column, so every other column must end before it starts.
+	var (
+		required      []mappedColumn
+		firstOptional mappedColumn
+		hasOptional   bool
+	)
 
 	for fieldIndex := 0; fieldIndex < nFields; fieldIndex++ {
 		currentField := st.Field(fieldIndex)
-		if currentField.IsExported() {
-			tagName := getRefName(currentField)
-			if index, ok := indices[tagName]; ok {
-				setter, err := getFieldSetter(currentField)
+		methodName, isMethod := currentField.Tag.Lookup(setterTagName)
+		if (currentField.IsExported() || isMethod) && !config.skipped(currentField) {
+			tagName := config.normalizeNames.string(config.refName(currentField))
+			index, ok := config.headers[tagName]
+			var joined [][]int
+			if !ok {
+				joined, ok = joinedColumns(tagName, config.headers)
+			}
+			if position, positional, err := fieldPosition(currentField); err != nil || (positional && config.splitter == nil) {
+				return nil, &InvalidTagError{Field: currentField, Tag: indexTagName}
+			} else if positional {
+				index, ok, joined = []int{position, position + 1}, true, nil
+			}
+			if from, rest, err := fieldFrom(currentField); err != nil || (rest && config.splitter != nil) {
+				return nil, &InvalidTagError{Field: currentField, Tag: fromTagName}
+			} else if rest {
+				if _, isSubRecord := subRecordType(currentField.Type); isSubRecord {
+					return nil, &InvalidTagError{Field: currentField, Tag: fromTagName}
+				}
+				index, ok, joined = []int{from, math.MaxInt}, true, nil
+			}
+			if _, tagged := currentField.Tag.Lookup(columnTagName); !ok && tagged && config.strictFields {
+				return nil, &MissingColumnError{Field: currentField, Column: tagName}
+			}
+			inferred := config.columnPadding[tagName]
+			if fieldType := currentField.Type; !isNumericKind(fieldType.Kind()) &&
+				!(fieldType.Kind() == reflect.Ptr && isNumericKind(fieldType.Elem().Kind())) {
+				inferred = columnPadding{}
+			}
+			fieldTrim, err := trimmer.forField(currentField, inferred)
+			if err != nil {
+				return nil, err
+			}
+			widthFrom, isDynamic := currentField.Tag.Lookup(widthFromTagName)
+			optional, err := isOptional(currentField)
+			if err != nil || (optional && (joined != nil || isDynamic)) {
+				return nil, &InvalidTagError{Field: currentField, Tag: optionalTagName}
+			}
+			if ok && optional && (!hasOptional || index[0] < firstOptional.from) {
+				firstOptional, hasOptional = mappedColumn{field: currentField, column: tagName, from: index[0]}, true
+			} else if ok && !optional {
+				columns := joined
+				if columns == nil {
+					columns = [][]int{index}
+				}
+				for _, column := range columns {
+					required = append(required, mappedColumn{field: currentField, column: tagName, from: column[0], to: column[1]})
+				}
+			}
+			mapped := len(valueSetters)
+			if ok && joined != nil {
+				setter, err := createPlainSetter(currentField, config, columnTagName)
+				if err != nil {
+					return nil, err
+				}
+				valueSetters = append(valueSetters, joinedValueSetterFunc(currentField, fieldIndex, joined, currentField.Tag.Get(joinTagName), fieldTrim, setter))
+			} else if ok && isDynamic {
+				lengthField, found := st.FieldByName(widthFrom)
+				if !found || len(lengthField.Index) != 1 || lengthField.Index[0] >= fieldIndex || config.splitter != nil || !isIntegerKind(lengthField.Type.Kind()) {
+					return nil, &InvalidTagError{Field: currentField, Tag: widthFromTagName}
+				}
+				setter, err := createPlainSetter(currentField, config, widthFromTagName)
+				if err != nil {
+					return nil, err
+				}
+				valueSetters = append(valueSetters, dynamicWidthValueSetterFunc(currentField, fieldIndex, lengthField.Index[0], index[0], fieldTrim, setter))
+			} else if ok && isMethod {
+				method, err := findSetterMethod(st, currentField, methodName)
+				if err != nil {
+					return nil, err
+				}
+				valueSetters = append(valueSetters, methodValueSetterFunc(currentField, method, index[0], index[1], fieldTrim))
+			} else if subType, isSubRecord := subRecordType(currentField.Type); ok && isSubRecord {
+				subSetter, err := createSubRecordSetter(subType, index[1]-index[0], config)
+				if err != nil {
+					return nil, err
+				}
+				valueSetters = append(valueSetters, subRecordValueSetterFunc(fieldIndex, index[0], index[1], subSetter))
+			} else if ok {
+				setter, err := config.getFieldSetter(currentField)
+				if err != nil {
+					return nil, err
+				}
+				if setter, err = config.wrapSetter(currentField, setter); err != nil {
+					return nil, err
+				}
+				charset, err := config.fieldCharset(currentField)
 				if err != nil {
 					return nil, err
 				}
-				if setter != nil {
-					valueSetters = append(valueSetters, valueSetterFunc(currentField, fieldIndex, index[0], index[1], leftTrimmer, rightTrimmer, setter))
+				if numeric, ok := currentField.Tag.Lookup(numericTagName); ok && numeric == packedNumeric {
+					if charset != nil {
+						return nil, &InvalidTagError{Field: currentField, Tag: charsetTagName}
+					}
+					setter, err = createPackedSet(currentField, setter)
+					if err != nil {
+						return nil, err
+					}
+					valueSetters = append(valueSetters, packedValueSetterFunc(currentField, fieldIndex, index[0], index[1], setter))
+				} else if charset != nil {
+					valueSetters = append(valueSetters, charsetValueSetterFunc(currentField, fieldIndex, index[0], index[1], fieldTrim, charset, setter))
+				} else if setter != nil {
+					valueSetters = append(valueSetters, valueSetterFunc(currentField, fieldIndex, index[0], index[1], fieldTrim, setter))
 				}
 			}
+			if optional && len(valueSetters) > mapped {
+				valueSetters[mapped] = optionalValueSetterFunc(index[0], valueSetters[mapped])
+			}
 		}
 	}
 
-	return structSetterFunc(valueSetters), nil
+	for _, column := range required {
+		if hasOptional && column.to > firstOptional.from {
+			return nil, &OptionalColumnError{Field: column.field, Column: column.column, Optional: firstOptional.column}
+		}
+	}
+
+	if config.requireMapped && len(valueSetters) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoMappedFields, st)
+	}
 
+	// The setter keeps its own copy as the scratch slice goes back to the pool.
+	setters := make([]func(reflect.Value, *record) error, len(valueSetters))
+	copy(setters, valueSetters)
+	return structSetterFunc(setters, config.splitter, config.widthMode), nil
+
+}
+
+// mappedColumn is a column mapped to a field, used to check the layout of optional columns.
+type mappedColumn struct {
+	field    reflect.StructField
+	column   string
+	from, to int
 }
 
-func structSetterFunc(valueSetters []func(reflect.Value, []rune) error) func(item reflect.Value, line string) error {
+// fieldPosition returns the zero based position of the column given by the index annotation of a
+// field in a delimited record. positional is false if there is no annotation.
+func fieldPosition(field reflect.StructField) (position int, positional bool, err error) {
+	indexTag, ok := field.Tag.Lookup(indexTagName)
+	if !ok {
+		return 0, false, nil
+	}
+	if position, err = strconv.Atoi(indexTag); err == nil && position < 0 {
+		err = fmt.Errorf("negative index %d", position)
+	}
+	return position, true, err
+}
+
+// fieldFrom returns the offset given by the from annotation of field, from which the field takes the
+// rest of the record.
+func fieldFrom(field reflect.StructField) (from int, rest bool, err error) {
+	fromTag, ok := field.Tag.Lookup(fromTagName)
+	if !ok {
+		return 0, false, nil
+	}
+	if from, err = strconv.Atoi(fromTag); err == nil && from < 0 {
+		err = fmt.Errorf("negative offset %d", from)
+	}
+	return from, true, err
+}
+
+// joinedColumns returns the columns named by a column annotation such as "first+last" which joins
+// several columns into one value. ok is false unless name lists more than one column and all of
+// them are in the headers.
+func joinedColumns(name string, headers map[string][]int) (columns [][]int, ok bool) {
+	names := strings.Split(name, joinedColumnSeparator)
+	if len(names) < 2 {
+		return nil, false
+	}
+	for _, name := range names {
+		index, found := headers[name]
+		if !found {
+			return nil, false
+		}
+		columns = append(columns, index)
+	}
+	return columns, true
+}
+
+// createPlainSetter returns the setter for a field decoded from joined columns or a column with a
+// dynamic width. Annotations which need the raw content of a single column of known width can't be
+// used with these so an InvalidTagError for tag is returned if any are present.
+func createPlainSetter(structField reflect.StructField, config setterConfig, tag string) (valueSetter, error) {
+	_, isMethod := structField.Tag.Lookup(setterTagName)
+	_, isCharset := structField.Tag.Lookup(charsetTagName)
+	_, isSubRecord := subRecordType(structField.Type)
+	if isMethod || isCharset || isSubRecord || structField.Tag.Get(numericTagName) == packedNumeric {
+		return nil, &InvalidTagError{Field: structField, Tag: tag}
+	}
+	setter, err := config.getFieldSetter(structField)
+	if err != nil {
+		return nil, err
+	}
+	return config.wrapSetter(structField, setter)
+}
+
+// joinedValueSetterFunc trims each of the columns separately and joins those which are not empty
+// with joiner before the value is passed to setter.
+func joinedValueSetterFunc(currentField reflect.StructField, idx int, columns [][]int, joiner string, trimmer *fieldTrimmer, setter valueSetter) func(reflect.Value, *record) error {
+	return func(v reflect.Value, r *record) error {
+		parts := make([]string, 0, len(columns))
+		for _, index := range columns {
+			if part := trimmer.trim(r.field(index[0], index[1])); part != "" {
+				parts = append(parts, part)
+			}
+		}
+		return setter(v.Field(idx), currentField, strings.Join(parts, joiner))
+	}
+}
+
+// dynamicWidthValueSetterFunc reads the column which starts at from and has the width held by the
+// integer field at lengthIdx, which has already been decoded as it comes first in the struct.
+func dynamicWidthValueSetterFunc(currentField reflect.StructField, idx, lengthIdx, from int, trimmer *fieldTrimmer, setter valueSetter) func(reflect.Value, *record) error {
+	return func(v reflect.Value, r *record) error {
+		lengthVal := v.Field(lengthIdx)
+		var width int64
+		if lengthVal.CanInt() {
+			width = lengthVal.Int()
+		} else if lengthVal.Uint() > math.MaxInt64 {
+			width = math.MaxInt64
+		} else {
+			width = int64(lengthVal.Uint())
+		}
+		available := r.length() - from
+		if available < 0 {
+			available = 0
+		}
+		if width < 0 || width > int64(available) {
+			return &DynamicWidthError{Field: currentField, Width: width, Available: available}
+		}
+		to := from + int(width)
+		return r.locate(setter(v.Field(idx), currentField, trimmer.trim(r.field(from, to))), from, to)
+	}
+}
+
+func isIntegerKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// isNumericKind reports whether kind is an integer or floating point kind.
+func isNumericKind(kind reflect.Kind) bool {
+	return isIntegerKind(kind) || kind == reflect.Float32 || kind == reflect.Float64
+}
+
+// layoutLength returns the end of the last column mapped by a field of st, which is the
+// minimum length of a record which can be decoded into st.
+func (config setterConfig) layoutLength(st reflect.Type) int {
+	length := 0
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		if _, rest, _ := fieldFrom(field); rest {
+			continue
+		}
+		for _, index := range config.fieldColumns(field) {
+			if index[1] > length {
+				length = index[1]
+			}
+		}
+	}
+	return length
+}
+
+// optionalFrom returns the start of the first column mapped by a field of st annotated with optional:"true".
+// ok is false if there are none.
+func (config setterConfig) optionalFrom(st reflect.Type) (from int, ok bool) {
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		if optional, _ := isOptional(field); !optional {
+			continue
+		}
+		columns := config.fieldColumns(field)
+		if start, rest, _ := fieldFrom(field); rest {
+			columns = [][]int{{start, math.MaxInt}}
+		}
+		for _, index := range columns {
+			if !ok || index[0] < from {
+				from, ok = index[0], true
+			}
+		}
+	}
+	return from, ok
+}
+
+// fieldColumns returns the columns in the headers which field is mapped to, ignoring any from annotation.
+func (config setterConfig) fieldColumns(field reflect.StructField) [][]int {
+	if _, isMethod := field.Tag.Lookup(setterTagName); (!field.IsExported() && !isMethod) || config.skipped(field) {
+		return nil
+	}
+	name := config.refName(field)
+	columns, _ := joinedColumns(name, config.headers)
+	if index, ok := config.headers[name]; ok {
+		columns = [][]int{index}
+	}
+	if position, positional, _ := fieldPosition(field); positional {
+		columns = [][]int{{position, position + 1}}
+	}
+	return columns
+}
+
+// isOptional reports whether field is annotated with optional:"true".
+func isOptional(field reflect.StructField) (bool, error) {
+	optionalTag, ok := field.Tag.Lookup(optionalTagName)
+	if !ok {
+		return false, nil
+	}
+	optional, err := strconv.ParseBool(optionalTag)
+	if err != nil {
+		return false, &InvalidTagError{Field: field, Tag: optionalTagName}
+	}
+	return optional, nil
+}
+
+// optionalValueSetterFunc leaves the field set by setter unchanged when the record ends before the
+// column starting at from, which is the column of a field annotated with optional:"true".
+func optionalValueSetterFunc(from int, setter func(reflect.Value, *record) error) func(reflect.Value, *record) error {
+	return func(v reflect.Value, r *record) error {
+		if (r.columns != nil && from >= len(r.columns)) || (r.columns == nil && from >= r.length()) {
+			return nil
+		}
+		return setter(v, r)
+	}
+}
+
+func structSetterFunc(valueSetters []func(reflect.Value, *record) error, splitter *regexp.Regexp, mode WidthMode) func(item reflect.Value, line string) error {
 	return func(item reflect.Value, line string) error {
-		lineRunes := []rune(line)
+		r := newRecord(line, splitter, mode)
 		for _, setter := range valueSetters {
-			if err := setter(item, lineRunes); err != nil {
+			if err := setter(item, r); err != nil {
 				return err
 			}
 		}
@@ -292,24 +1167,409 @@ func structSetterFunc(valueSetters []func(reflect.Value, []rune) error) func(ite
 	}
 }
 
-func valueSetterFunc(currentField reflect.StructField, idx, from, to int, leftTrimmer, rightTrimmer *regexp.Regexp, setter valueSetter) func(reflect.Value, []rune) error {
-	return func(v reflect.Value, line []rune) error {
+func valueSetterFunc(currentField reflect.StructField, idx, from, to int, trimmer *fieldTrimmer, setter valueSetter) func(reflect.Value, *record) error {
+	return func(v reflect.Value, r *record) error {
+		fieldVal := v.Field(idx)
+		rawField := trimmer.trim(r.field(from, to))
+		return r.locate(setter(fieldVal, currentField, rawField), from, to)
+	}
+}
+
+// methodValueSetterFunc passes the trimmed value to the method with the given index on a pointer
+// to the struct being decoded.
+func methodValueSetterFunc(currentField reflect.StructField, method, from, to int, trimmer *fieldTrimmer) func(reflect.Value, *record) error {
+	return func(v reflect.Value, r *record) error {
+		rawField := trimmer.trim(r.field(from, to))
+		result := v.Addr().Method(method).Call([]reflect.Value{reflect.ValueOf(rawField)})
+		if err, _ := result[0].Interface().(error); err != nil {
+			return r.locate(&CastingError{Err: err, Value: rawField, Field: currentField}, from, to)
+		}
+		return nil
+	}
+}
+
+// findSetterMethod returns the index of the method named by the setter annotation of field.
+func findSetterMethod(st reflect.Type, field reflect.StructField, name string) (int, error) {
+	if !token.IsExported(name) {
+		return 0, &InvalidSetterError{Field: field, Method: name, Unexported: true}
+	}
+	method, ok := reflect.PointerTo(st).MethodByName(name)
+	if !ok || reflect.New(st).Method(method.Index).Type() != setterMethodType {
+		return 0, &InvalidSetterError{Field: field, Method: name}
+	}
+	return method.Index, nil
+}
+
+// fieldTrimmer removes padding (and column delimiters) from the ends of a column.
+type fieldTrimmer struct {
+	left      *regexp.Regexp
+	right     *regexp.Regexp
+	leftKeep  string // leftKeep and rightKeep replace the padding matched by left and right
+	rightKeep string
+	pad       string // pad is the expression matching a single padding character
+	delimiter string
+	digit     string         // digit is a digit used as padding, which is the value of a column holding only padding
+	leftOne   *regexp.Regexp // leftOne and rightOne match a single padding character at the ends of a column. They
+	rightOne  *regexp.Regexp // are only set when just the run of the padding character found at each end is trimmed
+}
+
+// fieldTrimmer returns the trimmer for the padding of the columns, or an error if the padding, which is given
+// by the caller, is not a valid regular expression. The expressions built from it are then valid too.
+func (config setterConfig) fieldTrimmer() (*fieldTrimmer, error) {
+	if _, err := regexp.Compile(config.fieldSeparator); err != nil {
+		return nil, err
+	}
+	trimmer := &fieldTrimmer{
+		left:  regexp.MustCompile("^(?:" + config.fieldSeparator + ")+"),
+		right: regexp.MustCompile("(?:" + config.fieldSeparator + ")+$"),
+		pad:   config.fieldSeparator,
+	}
+	if config.splitter != nil {
+		trimmer.left = regexp.MustCompile(`^\s+`)
+		trimmer.right = regexp.MustCompile(`\s+$`)
+		trimmer.pad = `\s`
+	}
+	if !config.trimGreedy {
+		trimmer.leftOne = regexp.MustCompile("^(?:" + trimmer.pad + ")")
+		trimmer.rightOne = regexp.MustCompile("(?:" + trimmer.pad + ")$")
+	}
+	if config.columnDelimiter != 0 {
+		trimmer.delimiter = string(config.columnDelimiter)
+	}
+	return trimmer, nil
+}
+
+// forField returns the trimmer for a field, which is trimmer itself unless the field has a keepOne or
+// pad annotation or padding has been inferred for its column. keepOne:"left", "right" or "both" leaves a
+// single padding character on that side of the value if there was any padding there. The pad annotation,
+// or the padding inferred, adds characters to the padding on the side given by padSide.
+func (trimmer *fieldTrimmer) forField(structField reflect.StructField, inferred columnPadding) (*fieldTrimmer, error) {
+
+	extra := inferred
+	if chars, ok := structField.Tag.Lookup(padTagName); ok {
+		extra = columnPadding{chars: chars, side: structField.Tag.Get(padSideTagName)}
+		if extra.side != "" && extra.side != "left" && extra.side != "right" {
+			return nil, &InvalidTagError{Field: structField, Tag: padSideTagName}
+		}
+	}
+	side, keep := structField.Tag.Lookup(keepOneTagName)
+	if extra.chars == "" && !keep {
+		return trimmer, nil
+	}
+
+	adjusted := *trimmer
+	leftPad, rightPad := trimmer.pad, trimmer.pad
+	if extra.chars != "" {
+		pad := trimmer.pad
+		for _, c := range extra.chars {
+			pad += "|" + regexp.QuoteMeta(string(c))
+			if unicode.IsDigit(c) && adjusted.digit == "" {
+				adjusted.digit = string(c)
+			}
+		}
+		if extra.side != "right" {
+			leftPad = pad
+			adjusted.left = regexp.MustCompile("^(?:" + leftPad + ")+")
+		}
+		if extra.side != "left" {
+			rightPad = pad
+			adjusted.right = regexp.MustCompile("(?:" + rightPad + ")+$")
+		}
+	}
+	if adjusted.leftOne != nil {
+		adjusted.leftOne = regexp.MustCompile("^(?:" + leftPad + ")")
+		adjusted.rightOne = regexp.MustCompile("(?:" + rightPad + ")$")
+	}
+	if !keep {
+		return &adjusted, nil
+	}
+
+	switch side {
+	case "left":
+		adjusted.left, adjusted.leftKeep = regexp.MustCompile("^(?:"+leftPad+")*("+leftPad+")"), "${1}"
+	case "right":
+		adjusted.right, adjusted.rightKeep = regexp.MustCompile("("+rightPad+")(?:"+rightPad+")*$"), "${1}"
+	case "both":
+		adjusted.left, adjusted.leftKeep = regexp.MustCompile("^(?:"+leftPad+")*("+leftPad+")"), "${1}"
+		adjusted.right, adjusted.rightKeep = regexp.MustCompile("("+rightPad+")(?:"+rightPad+")*$"), "${1}"
+	default:
+		return nil, &InvalidTagError{Field: structField, Tag: keepOneTagName}
+	}
+	return &adjusted, nil
+}
+
+func (trimmer *fieldTrimmer) trim(field string) string {
+	if trimmer.delimiter != "" {
+		field = strings.TrimPrefix(field, trimmer.delimiter)
+		field = strings.TrimSuffix(field, trimmer.delimiter)
+	}
+	var rawField string
+	if trimmer.leftOne != nil {
+		rawField = trimRun(field, trimmer.leftOne, false, trimmer.leftKeep != "")
+		rawField = trimRun(rawField, trimmer.rightOne, true, trimmer.rightKeep != "")
+	} else {
+		rawField = trimmer.left.ReplaceAllString(field, trimmer.leftKeep)
+		rawField = trimmer.right.ReplaceAllString(rawField, trimmer.rightKeep)
+	}
+	if rawField == "" && trimmer.digit != "" && strings.Contains(field, trimmer.digit) {
+		return trimmer.digit
+	}
+	return rawField
+}
+
+// trimRun removes the run of the padding matched by one from the start of field, or from the end if end
+// is set, leaving one of it in place if keep is set.
+func trimRun(field string, one *regexp.Regexp, end, keep bool) string {
+	pad := one.FindString(field)
+	if pad == "" {
+		return field
+	}
+	trimmed := field
+	if end {
+		for strings.HasSuffix(trimmed, pad) {
+			trimmed = trimmed[:len(trimmed)-len(pad)]
+		}
+		if keep {
+			trimmed += pad
+		}
+		return trimmed
+	}
+	for strings.HasPrefix(trimmed, pad) {
+		trimmed = trimmed[len(pad):]
+	}
+	if keep {
+		trimmed = pad + trimmed
+	}
+	return trimmed
+}
+
+// newRecord splits line into columns when splitter is set, and otherwise prepares it for
+// reading columns measured in mode.
+func newRecord(line string, splitter *regexp.Regexp, mode WidthMode) *record {
+	r := &record{line: line, mode: mode}
+	if splitter != nil {
+		r.columns = splitter.Split(line, -1)
+	} else if mode == WidthCells {
+		r.runes = []rune(line)
+		r.cells, r.width = cellStarts(r.runes)
+	} else if mode == WidthRunes {
+		r.runes = []rune(line)
+	}
+	return r
+}
+
+// length returns the length of a positional record in the units of its mode.
+func (r *record) length() int {
+	switch r.mode {
+	case WidthBytes:
+		return len(r.line)
+	case WidthCells:
+		return r.width
+	default:
+		return len(r.runes)
+	}
+}
+
+// field returns the untrimmed value of the column from the record. For delimited records
+// from is the position of the column; a column which is not present is empty. A positional
+// column which extends beyond the end of a short record is cut short and is empty if it starts
+// beyond the end. When measuring in cells a character belongs to the column containing the
+// first cell it occupies, and a combining mark to the column of the character it follows.
+func (r *record) field(from, to int) string {
+	if r.columns != nil {
+		if from < len(r.columns) {
+			return r.columns[from]
+		}
+		return ""
+	}
+	switch r.mode {
+	case WidthBytes:
+		from, to = clampColumn(from, to, len(r.line))
+		return r.line[from:to]
+	case WidthCells:
+		first := sort.SearchInts(r.cells, from)
+		last := sort.SearchInts(r.cells, to)
+		return string(r.runes[first:last])
+	default:
+		from, to = clampColumn(from, to, len(r.runes))
+		return string(r.runes[from:to])
+	}
+}
+
+// locate sets the position and content of the column from, to in err if it is a CastingError.
+func (r *record) locate(err error, from, to int) error {
+	var castingErr *CastingError
+	if err == nil || !errors.As(err, &castingErr) {
+		return err
+	}
+	castingErr.Raw = r.field(from, to)
+	if r.columns == nil {
+		from, to = clampColumn(from, to, r.length())
+	}
+	castingErr.From, castingErr.To = from, to
+	return err
+}
+
+// clampColumn limits the column from, to to a record of the given length.
+func clampColumn(from, to, length int) (int, int) {
+	if to > length {
+		to = length
+	}
+	if from > to {
+		from = to
+	}
+	return from, to
+}
+
+// subRecordValueSetterFunc decodes the untrimmed column into a struct (or pointer to a struct) using
+// the setter for its nested layout.
+func subRecordValueSetterFunc(idx, from, to int, subSetter structSetter) func(reflect.Value, *record) error {
+	return func(v reflect.Value, r *record) error {
 		fieldVal := v.Field(idx)
-		fieldRunes := line[from:to]
-		rawField := leftTrimmer.ReplaceAllString(string(fieldRunes), "")
-		rawField = rightTrimmer.ReplaceAllString(rawField, "")
-		return setter(fieldVal, currentField, rawField)
+		if fieldVal.Kind() == reflect.Ptr {
+			if fieldVal.IsNil() {
+				fieldVal.Set(reflect.New(fieldVal.Type().Elem()))
+			}
+			fieldVal = fieldVal.Elem()
+		}
+		return subSetter(fieldVal, r.field(from, to))
 	}
 }
 
+// subRecordType returns the struct type for fields which hold a nested fixed width layout. These
+// are structs (or pointers to structs) which aren't decoded as text and have at least one field with
+// a width annotation.
+func subRecordType(t reflect.Type) (reflect.Type, bool) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) ||
+		t.Implements(textUnmarshalerType) || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return nil, false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if _, ok := t.Field(i).Tag.Lookup(widthTagName); ok {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// createSubRecordSetter builds the setter for a nested layout. Fields with a width annotation
+// are laid out in declaration order from the start of the parent column, which must be wide
+// enough to hold them all. Fields without a width annotation are ignored.
+func createSubRecordSetter(st reflect.Type, parentWidth int, config setterConfig) (structSetter, error) {
+
+	headers, err := config.widthLayout(st, parentWidth)
+	if err != nil {
+		return nil, err
+	}
+
+	config.headers = headers
+	config.splitter = nil
+	return createStructSetter(st, config)
+}
+
+// widthLayout returns the columns of the fields of st with a width annotation, laid out in
+// declaration order from zero. An InvalidTagError is returned for a width which is not a
+// number, is negative or takes the layout beyond maxWidth, and for a column name which is
+// used by more than one field.
+func (config setterConfig) widthLayout(st reflect.Type, maxWidth int) (map[string][]int, error) {
+
+	headers := make(map[string][]int)
+	from := 0
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		widthTag, ok := field.Tag.Lookup(widthTagName)
+		if !ok {
+			continue
+		}
+		width, err := strconv.Atoi(widthTag)
+		if err != nil || width < 0 || width > maxWidth-from {
+			return nil, &InvalidTagError{Field: field, Tag: widthTagName}
+		}
+		if config.skipped(field) {
+			from += width
+			continue
+		}
+		name := config.refName(field)
+		if _, exists := headers[name]; exists {
+			return nil, &InvalidTagError{Field: field, Tag: columnTagName}
+		}
+		headers[name] = []int{from, from + width}
+		from += width
+	}
+	return headers, nil
+}
+
+// packedValueSetterFunc passes the untrimmed bytes of the column to the setter. Packed
+// data can legitimately contain bytes which look like padding so no trimming is done.
+func packedValueSetterFunc(currentField reflect.StructField, idx, from, to int, setter valueSetter) func(reflect.Value, *record) error {
+	return func(v reflect.Value, r *record) error {
+		if r.columns != nil || r.mode == WidthBytes {
+			return r.locate(setter(v.Field(idx), currentField, r.field(from, to)), from, to)
+		}
+		byteFrom, byteTo := byteOffsets(r.line, from, to)
+		return r.locate(setter(v.Field(idx), currentField, r.line[byteFrom:byteTo]), from, to)
+	}
+}
+
+// byteOffsets converts rune offsets into byte offsets in line. Invalid UTF-8 bytes count as
+// a single rune each, matching the conversion of a string to []rune.
+func byteOffsets(line string, from, to int) (int, int) {
+	byteFrom, byteTo := len(line), len(line)
+	runeIndex := 0
+	for i := 0; i < len(line); runeIndex++ {
+		if runeIndex == from {
+			byteFrom = i
+		}
+		if runeIndex == to {
+			byteTo = i
+			break
+		}
+		_, size := utf8.DecodeRuneInString(line[i:])
+		i += size
+	}
+	return byteFrom, byteTo
+}
+
 func getRefName(field reflect.StructField) string {
 	if name, ok := field.Tag.Lookup(columnTagName); ok {
+		if name == "-," {
+			return "-"
+		}
 		return name
 	}
 
 	return field.Name
 }
 
+// refName returns the name of the column for field. When useJSONTags is set the name in a json annotation
+// is used for a field without a column annotation.
+func (config setterConfig) refName(field reflect.StructField) string {
+	if _, tagged := field.Tag.Lookup(columnTagName); !tagged && config.useJSONTags {
+		if name, _, _ := strings.Cut(field.Tag.Get(jsonTagName), ","); name != "" {
+			return name
+		}
+	}
+	return getRefName(field)
+}
+
+// skipped reports whether field is never mapped to a column, because it is annotated with column:"-" or,
+// when useJSONTags is set and it has no column annotation, with json:"-".
+func (config setterConfig) skipped(field reflect.StructField) bool {
+	if _, tagged := field.Tag.Lookup(columnTagName); !tagged && config.useJSONTags {
+		return field.Tag.Get(jsonTagName) == "-"
+	}
+	return skippedField(field)
+}
+
+// skippedField reports whether field is annotated with column:"-" so that it is never mapped to a
+// column. column:"-," maps the field to a column named "-".
+func skippedField(field reflect.StructField) bool {
+	return field.Tag.Get(columnTagName) == "-"
+}
+
 func parseBool(str string) (bool, error) {
 	switch str {
 	case "yes", "YES", "Yes":
@@ -321,17 +1581,43 @@ func parseBool(str string) (bool, error) {
 	}
 }
 
-var structSetterCache sync.Map // map[string]structSetter
+var structSetterCache sync.Map // map[structSetterKey]structSetter
+
+// structSetterKey identifies a cached setter. The type is used directly as types with the
+// same name can be declared in different scopes.
+type structSetterKey struct {
+	t      reflect.Type
+	config string
+}
 
-func cachedStructSetter(t reflect.Type, indices map[string][]int, fieldSeparator string) (structSetter, error) {
-	key := fmt.Sprintf("%s.%s:%v:%s", t.PkgPath(), t.Name(), indices, fieldSeparator)
+// cacheKey returns the key for the setter for t built with config. Converters are not part of the
+// key as functions can't be compared; setters built with converters must not be stored in the
+// process wide cache.
+func (config setterConfig) cacheKey(t reflect.Type) structSetterKey {
+	return structSetterKey{t: t, config: fmt.Sprintf("%v:%s:%v:%t:%t:%d:%q:%p:%d:%d:%v:%q:%t:%t:%v", config.headers, config.fieldSeparator, config.splitter,
+		config.requireMapped, config.strictFields, config.widthMode, config.columnDelimiter, config.location,
+		config.normalizeNames, config.normalizeValues, config.columnPadding, config.currencySymbols, config.useJSONTags, config.trimGreedy,
+		config.timeLayouts)}
+}
+
+func cachedStructSetter(t reflect.Type, config setterConfig) (structSetter, error) {
+	key := config.cacheKey(t)
 	if f, ok := structSetterCache.Load(key); ok {
 		return f.(structSetter), nil
 	}
-	setter, err := createStructSetter(t, indices, fieldSeparator)
+	setter, err := createStructSetter(t, config)
 	if err != nil {
 		return nil, err
 	}
 	f, _ := structSetterCache.LoadOrStore(key, setter)
 	return f.(structSetter), nil
 }
+
+// ClearSetterCache removes every conversion function from the process wide cache used by
+// decoders. Decoders which are in use keep the function for the type they last decoded.
+func ClearSetterCache() {
+	structSetterCache.Range(func(key, _ interface{}) bool {
+		structSetterCache.Delete(key)
+		return true
+	})
+}
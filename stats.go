@@ -0,0 +1,11 @@
+package fw
+
+// DecoderStats holds the counts returned by [Decoder.Stats].
+type DecoderStats struct {
+	Records int64 // Records is the number of data records which passed the length checks, including those
+	// which could not then be decoded. Header lines are not included.
+	Skipped int64 // Skipped is the number of records discarded by Filter or as empty when IgnoreEmptyRecords is set.
+	Errors  int64 // Errors is the number of records which failed the length checks or could not be decoded.
+	Bytes   int64 // Bytes is the number of bytes consumed from the input (after decompression), including header
+	// lines and record terminators.
+}
@@ -0,0 +1,100 @@
+package fw
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/width"
+)
+
+// A WidthMode defines the unit in which column offsets and record lengths are measured.
+type WidthMode int
+
+const (
+	// WidthRunes measures offsets in runes (Unicode code points). This is the default.
+	WidthRunes WidthMode = iota
+	// WidthBytes measures offsets in bytes of the encoded record.
+	WidthBytes
+	// WidthCells measures offsets in display cells. East Asian wide and fullwidth characters occupy
+	// two cells, non-spacing marks occupy none and all other characters occupy one.
+	WidthCells
+)
+
+// runeCells returns the number of display cells occupied by r.
+func runeCells(r rune) int {
+	if unicode.Is(unicode.Mn, r) {
+		return 0
+	}
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// length returns the length of s in the units of mode.
+func (mode WidthMode) length(s string) int {
+	switch mode {
+	case WidthBytes:
+		return len(s)
+	case WidthCells:
+		cells := 0
+		for _, r := range s {
+			cells += runeCells(r)
+		}
+		return cells
+	default:
+		return utf8.RuneCountInString(s)
+	}
+}
+
+// cellStarts returns the display cell at which each rune in runes starts and the number of cells they
+// occupy. A rune which occupies no cells, such as a combining mark, starts with the rune before it so
+// that the two are never split between columns.
+func cellStarts(runes []rune) ([]int, int) {
+	starts := make([]int, len(runes))
+	cells := 0
+	for i, r := range runes {
+		n := runeCells(r)
+		if n == 0 && i > 0 {
+			starts[i] = starts[i-1]
+		} else {
+			starts[i] = cells
+		}
+		cells += n
+	}
+	return starts, cells
+}
+
+// expandTabs replaces each tab in s with the spaces needed to reach the next position which is a
+// multiple of tabWidth, measuring positions in the units of mode. s is returned unchanged if tabWidth
+// is not positive.
+func (mode WidthMode) expandTabs(s string, tabWidth int) string {
+	if tabWidth <= 0 || !strings.Contains(s, "\t") {
+		return s
+	}
+	var expanded strings.Builder
+	position := 0
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == '\t' {
+			spaces := tabWidth - position%tabWidth
+			expanded.WriteString(strings.Repeat(" ", spaces))
+			position += spaces
+		} else {
+			expanded.WriteString(s[i : i+size])
+			switch mode {
+			case WidthBytes:
+				position += size
+			case WidthCells:
+				position += runeCells(r)
+			default:
+				position++
+			}
+		}
+		i += size
+	}
+	return expanded.String()
+}